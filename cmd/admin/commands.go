@@ -0,0 +1,460 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/sparkycj328/JobAIO-API/internal/data"
+	"github.com/sparkycj328/JobAIO-API/internal/validator"
+	"golang.org/x/term"
+)
+
+// newCommandFlags returns a flag set for a subcommand which reports errors instead of exiting
+func newCommandFlags(name string) *flag.FlagSet {
+	return flag.NewFlagSet("admin "+name, flag.ContinueOnError)
+}
+
+// splitList splits a comma-separated flag value, dropping empty entries
+func splitList(value string) []string {
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// validationError flattens the errors held by a validator into a single error, sorted by
+// field so the message is stable
+func validationError(v *validator.Validator) error {
+	pairs := make([]string, 0, len(v.Errors))
+	for field, message := range v.Errors {
+		pairs = append(pairs, field+" "+message)
+	}
+	sort.Strings(pairs)
+	return errors.New(strings.Join(pairs, "; "))
+}
+
+// createAdmin creates an activated user holding every permission. There is deliberately no
+// flag for the password, so it never appears in ps or the shell history; see adminPassword.
+func (app *admin) createAdmin(args []string) (result, error) {
+	fs := newCommandFlags("create-admin")
+	name := fs.String("name", "", "Name of the user")
+	email := fs.String("email", "", "Email address of the user")
+	passwordFile := fs.String("password-file", os.Getenv("JOBAIO_ADMIN_PASSWORD_FILE"), "File containing the password of the user")
+	if err := fs.Parse(args); err != nil {
+		return result{}, err
+	}
+
+	password, err := adminPassword(*passwordFile)
+	if err != nil {
+		return result{}, err
+	}
+
+	user := &data.User{Name: *name, Email: *email, Activated: true}
+	if err := user.Password.Set(password); err != nil {
+		return result{}, err
+	}
+
+	v := validator.New()
+	if data.ValidateUser(v, user); !v.Valid() {
+		return result{}, validationError(v)
+	}
+
+	codes, err := app.models.Permissions.GetAll(app.ctx)
+	if err != nil {
+		return result{}, err
+	}
+
+	if app.dryRun {
+		_, err := app.models.Users.GetByEmail(app.ctx, user.Email)
+		switch {
+		case err == nil:
+			return result{}, data.ErrDuplicateEmail
+		case !errors.Is(err, data.ErrRecordNotFound):
+			return result{}, err
+		}
+		return result{
+			Summary: fmt.Sprintf("would create admin %s with permissions %s", user.Email, strings.Join(codes, ", ")),
+			Details: map[string]any{"user": user, "permissions": codes},
+		}, nil
+	}
+
+	// an admin without permissions would be useless, so both are created or neither is
+	err = app.models.WithTx(app.ctx, func(tx data.Models) error {
+		if err := tx.Users.Insert(app.ctx, user); err != nil {
+			return err
+		}
+		return tx.Permissions.AddForUser(app.ctx, user.ID, codes...)
+	})
+	if err != nil {
+		return result{}, err
+	}
+
+	return result{
+		Summary: fmt.Sprintf("created admin %s (id %d) with permissions %s", user.Email, user.ID, strings.Join(codes, ", ")),
+		Details: map[string]any{"user": user, "permissions": codes},
+	}, nil
+}
+
+// adminPassword reads the password for create-admin from file if one is given, then from
+// JOBAIO_ADMIN_PASSWORD. Failing both, it is prompted for without echo on a terminal, or
+// read as the first line of standard input when that is a pipe.
+func adminPassword(file string) (string, error) {
+	if file != "" {
+		contents, err := os.ReadFile(file)
+		if err != nil {
+			return "", err
+		}
+		return string(trimNewline(contents)), nil
+	}
+	if password := os.Getenv("JOBAIO_ADMIN_PASSWORD"); password != "" {
+		return password, nil
+	}
+
+	if fd := int(os.Stdin.Fd()); term.IsTerminal(fd) {
+		fmt.Fprint(os.Stderr, "Password: ")
+		password, err := term.ReadPassword(fd)
+		fmt.Fprintln(os.Stderr)
+		return string(password), err
+	}
+
+	line, err := bufio.NewReader(os.Stdin).ReadBytes('\n')
+	if err != nil && !errors.Is(err, io.EOF) {
+		return "", err
+	}
+	return string(trimNewline(line)), nil
+}
+
+// grant gives an existing user one or more permissions. Every code must already exist in
+// the permissions table.
+func (app *admin) grant(args []string) (result, error) {
+	fs := newCommandFlags("grant")
+	email := fs.String("email", "", "Email address of the user")
+	permissions := fs.String("permission", "", "Comma-separated permission codes to grant")
+	if err := fs.Parse(args); err != nil {
+		return result{}, err
+	}
+
+	codes := splitList(*permissions)
+	if len(codes) == 0 {
+		return result{}, errors.New("at least one permission must be provided with -permission")
+	}
+
+	known, err := app.models.Permissions.GetAll(app.ctx)
+	if err != nil {
+		return result{}, err
+	}
+	for _, code := range codes {
+		if !known.Include(code) {
+			return result{}, fmt.Errorf("unknown permission %q, must be one of %s", code, strings.Join(known, ", "))
+		}
+	}
+
+	user, err := app.models.Users.GetByEmail(app.ctx, *email)
+	if err != nil {
+		if errors.Is(err, data.ErrRecordNotFound) {
+			return result{}, fmt.Errorf("no user with email %q", *email)
+		}
+		return result{}, err
+	}
+
+	if app.dryRun {
+		return result{
+			Summary: fmt.Sprintf("would grant %s to %s", strings.Join(codes, ", "), user.Email),
+			Details: map[string]any{"user": user, "granted": codes},
+		}, nil
+	}
+
+	if err := app.models.Permissions.AddForUser(app.ctx, user.ID, codes...); err != nil {
+		return result{}, err
+	}
+
+	held, err := app.models.Permissions.GetAllForUser(app.ctx, user.ID)
+	if err != nil {
+		return result{}, err
+	}
+
+	return result{
+		Summary: fmt.Sprintf("granted %s to %s", strings.Join(codes, ", "), user.Email),
+		Details: map[string]any{"user": user, "granted": codes, "permissions": held},
+	}, nil
+}
+
+// purgeTokens deletes every expired token, or counts them in a dry run
+func (app *admin) purgeTokens(args []string) (result, error) {
+	fs := newCommandFlags("purge-tokens")
+	if err := fs.Parse(args); err != nil {
+		return result{}, err
+	}
+
+	if app.dryRun {
+		count, err := app.models.Tokens.CountExpired(app.ctx)
+		if err != nil {
+			return result{}, err
+		}
+		return result{
+			Summary: fmt.Sprintf("would delete %d expired tokens", count),
+			Details: map[string]int64{"expired": count},
+		}, nil
+	}
+
+	count, err := app.models.Tokens.DeleteExpired(app.ctx)
+	if err != nil {
+		return result{}, err
+	}
+	return result{
+		Summary: fmt.Sprintf("deleted %d expired tokens", count),
+		Details: map[string]int64{"deleted": count},
+	}, nil
+}
+
+// resendWelcome sends the welcome email again, either to the given addresses or to every
+// user who has not activated their account yet. A failed send is reported and the
+// remaining users are still processed.
+func (app *admin) resendWelcome(args []string) (result, error) {
+	fs := newCommandFlags("resend-welcome")
+	emails := fs.String("email", "", "Comma-separated email addresses of the users")
+	inactive := fs.Bool("inactive", false, "Send to every user who has not activated their account")
+	if err := fs.Parse(args); err != nil {
+		return result{}, err
+	}
+
+	var users []*data.User
+	switch {
+	case *inactive && *emails != "":
+		return result{}, errors.New("-email and -inactive cannot be used together")
+	case *inactive:
+		var err error
+		users, err = app.models.Users.GetAllInactive(app.ctx)
+		if err != nil {
+			return result{}, err
+		}
+	case *emails != "":
+		for _, email := range splitList(*emails) {
+			user, err := app.models.Users.GetByEmail(app.ctx, email)
+			if err != nil {
+				if errors.Is(err, data.ErrRecordNotFound) {
+					return result{}, fmt.Errorf("no user with email %q", email)
+				}
+				return result{}, err
+			}
+			users = append(users, user)
+		}
+	default:
+		return result{}, errors.New("either -email or -inactive must be provided")
+	}
+
+	recipients := make([]string, 0, len(users))
+	for _, user := range users {
+		recipients = append(recipients, user.Email)
+	}
+
+	if app.dryRun {
+		return result{
+			Summary: fmt.Sprintf("would send the welcome email to %d users", len(users)),
+			Details: map[string]any{"recipients": recipients},
+		}, nil
+	}
+
+	sent := make([]string, 0, len(users))
+	failed := make(map[string]string)
+	for _, user := range users {
+		if err := app.mailer.Send(app.ctx, user.Email, "user_welcome.tmpl", user); err != nil {
+			app.logger.PrintError(err, map[string]string{"email": user.Email})
+			failed[user.Email] = err.Error()
+			continue
+		}
+		sent = append(sent, user.Email)
+	}
+
+	res := result{
+		Summary: fmt.Sprintf("sent the welcome email to %d of %d users", len(sent), len(users)),
+		Details: map[string]any{"sent": sent, "failed": failed},
+	}
+	if len(failed) > 0 {
+		return res, fmt.Errorf("%d emails could not be sent", len(failed))
+	}
+	return res, nil
+}
+
+// openInput opens the file named by a -file flag, where "-" stands for standard input
+func openInput(file string) (io.ReadCloser, error) {
+	if file == "-" {
+		return io.NopCloser(os.Stdin), nil
+	}
+	return os.Open(file)
+}
+
+// importSnapshots inserts job snapshots read from a CSV file with a header row. Every row is
+// validated first and nothing is inserted unless the whole file is valid. Rows may carry a
+// creation time, so historical snapshots keep their original date.
+func (app *admin) importSnapshots(args []string) (result, error) {
+	fs := newCommandFlags("import-snapshots")
+	file := fs.String("file", "-", `CSV file to import, or "-" for standard input`)
+	if err := fs.Parse(args); err != nil {
+		return result{}, err
+	}
+
+	in, err := openInput(*file)
+	if err != nil {
+		return result{}, err
+	}
+	defer in.Close()
+
+	companies, rejected, err := readSnapshots(in)
+	if err != nil {
+		return result{}, err
+	}
+	if len(rejected) > 0 {
+		return result{
+			Summary: fmt.Sprintf("%d of %d rows are invalid", len(rejected), len(companies)+len(rejected)),
+			Details: map[string]any{"rejected": rejected},
+		}, fmt.Errorf("%d invalid rows, nothing was imported", len(rejected))
+	}
+
+	if app.dryRun {
+		return result{
+			Summary: fmt.Sprintf("would import %d snapshots", len(companies)),
+			Details: map[string]int{"valid": len(companies)},
+		}, nil
+	}
+
+	// like the validation above, the import is all or nothing, so a failed insert rolls
+	// back the rows inserted before it
+	err = app.models.WithTx(app.ctx, func(tx data.Models) error {
+		for i, c := range companies {
+			if err := tx.Vendors.Insert(app.ctx, c); err != nil {
+				return fmt.Errorf("snapshot %d: %w", i+1, err)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return result{
+			Summary: fmt.Sprintf("imported 0 of %d snapshots", len(companies)),
+			Details: map[string]int{"imported": 0},
+		}, err
+	}
+
+	return result{
+		Summary: fmt.Sprintf("imported %d snapshots", len(companies)),
+		Details: map[string]int{"imported": len(companies)},
+	}, nil
+}
+
+// readSnapshots parses and validates every row of a snapshot CSV file. Invalid rows are
+// returned keyed by their line number instead of failing the whole read.
+func readSnapshots(in io.Reader) ([]*data.Company, map[string]string, error) {
+	sr, err := data.NewSnapshotReader(in, "csv")
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var companies []*data.Company
+	rejected := make(map[string]string)
+	for {
+		c, err := sr.Read()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		var rowErr *data.RowError
+		if errors.As(err, &rowErr) {
+			rejected["line "+strconv.Itoa(rowErr.Line)] = rowErr.Err.Error()
+			continue
+		}
+		if err != nil {
+			return nil, nil, err
+		}
+		companies = append(companies, c)
+	}
+
+	return companies, rejected, nil
+}
+
+// loadSnapshots bulk loads job snapshots from a CSV or NDJSON file with PostgreSQL COPY,
+// for backfills too large for import-snapshots. Invalid rows are skipped and reported, and
+// snapshots which are already stored are updated. With -dry-run the file is only checked.
+func (app *admin) loadSnapshots(args []string) (result, error) {
+	fs := newCommandFlags("load-snapshots")
+	file := fs.String("file", "-", `CSV or NDJSON file to load, or "-" for standard input`)
+	format := fs.String("format", "", "Input format (csv|ndjson), guessed from the file extension if empty")
+	if err := fs.Parse(args); err != nil {
+		return result{}, err
+	}
+
+	if *format == "" {
+		*format = "csv"
+		if ext := strings.ToLower(filepath.Ext(*file)); ext == ".ndjson" || ext == ".jsonl" {
+			*format = "ndjson"
+		}
+	}
+
+	in, err := openInput(*file)
+	if err != nil {
+		return result{}, err
+	}
+	defer in.Close()
+
+	sr, err := data.NewSnapshotReader(in, *format)
+	if err != nil {
+		return result{}, err
+	}
+
+	if app.dryRun {
+		var valid int
+		rejected := make(map[string]string)
+		for {
+			_, err := sr.Read()
+			if errors.Is(err, io.EOF) {
+				break
+			}
+			var rowErr *data.RowError
+			if errors.As(err, &rowErr) {
+				rejected["line "+strconv.Itoa(rowErr.Line)] = rowErr.Err.Error()
+				continue
+			}
+			if err != nil {
+				return result{}, err
+			}
+			valid++
+		}
+		return result{
+			Summary: fmt.Sprintf("would load %d snapshots, rejecting %d rows", valid, len(rejected)),
+			Details: map[string]any{"valid": valid, "rejected": rejected},
+		}, nil
+	}
+
+	loaded, err := data.SnapshotLoader{DB: app.db}.Load(app.ctx, sr)
+	rejected := make(map[string]string, len(loaded.Errors))
+	for _, rowErr := range loaded.Errors {
+		rejected["line "+strconv.Itoa(rowErr.Line)] = rowErr.Err.Error()
+	}
+	if err != nil {
+		return result{
+			Summary: "nothing was loaded",
+			Details: map[string]any{"rejected": rejected},
+		}, err
+	}
+
+	return result{
+		Summary: fmt.Sprintf("inserted %d, updated %d and rejected %d snapshots", loaded.Inserted, loaded.Updated, loaded.Rejected),
+		Details: map[string]any{
+			"inserted":   loaded.Inserted,
+			"updated":    loaded.Updated,
+			"unchanged":  loaded.Unchanged,
+			"duplicates": loaded.Duplicates,
+			"rejected":   loaded.Rejected,
+			"errors":     rejected,
+		},
+	}, nil
+}
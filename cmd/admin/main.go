@@ -0,0 +1,243 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"strconv"
+	"syscall"
+	"time"
+
+	"github.com/sparkycj328/JobAIO-API/internal/data"
+	"github.com/sparkycj328/JobAIO-API/internal/jsonlog"
+	"github.com/sparkycj328/JobAIO-API/internal/mailer"
+
+	_ "github.com/lib/pq"
+)
+
+// usage describes the global flags and the available subcommands
+const usage = `Usage: admin [flags] <command> [command flags]
+
+Commands:
+  create-admin      create an activated user holding every permission
+  grant             grant permissions to an existing user
+  purge-tokens      delete expired tokens
+  resend-welcome    send the welcome email to users again
+  import-snapshots  import job snapshots from a CSV file
+  load-snapshots    bulk load job snapshots from a CSV or NDJSON file
+
+Run "admin <command> -h" for the flags of a command.
+
+Flags:
+`
+
+// admin holds the dependencies shared by every subcommand
+type admin struct {
+	ctx        context.Context // cancelled on interrupt, so a long import can be stopped
+	db         *sql.DB
+	models     data.Models
+	mailer     mailer.Mailer
+	logger     *jsonlog.Logger
+	dryRun     bool
+	jsonOutput bool
+	out        io.Writer
+}
+
+// result is the outcome of a subcommand. In JSON mode it is written as-is, otherwise only
+// the summary is printed.
+type result struct {
+	Command string `json:"command"`
+	DryRun  bool   `json:"dry_run"`
+	Summary string `json:"summary,omitempty"`
+	Details any    `json:"details,omitempty"`
+	Error   string `json:"error,omitempty"`
+}
+
+// command is a subcommand which parses its own flags from args
+type command func(app *admin, args []string) (result, error)
+
+var commands = map[string]command{
+	"create-admin":     (*admin).createAdmin,
+	"grant":            (*admin).grant,
+	"purge-tokens":     (*admin).purgeTokens,
+	"resend-welcome":   (*admin).resendWelcome,
+	"import-snapshots": (*admin).importSnapshots,
+	"load-snapshots":   (*admin).loadSnapshots,
+}
+
+func main() {
+	fs := flag.NewFlagSet("admin", flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprint(fs.Output(), usage)
+		fs.PrintDefaults()
+	}
+
+	// connection settings default to the same JOBAIO_* environment variables as the API
+	dsn := fs.String("dsn", os.Getenv("JOBAIO_DSN"), "Database connection")
+	mailTransport := fs.String("mail-transport", envOr("JOBAIO_MAIL_TRANSPORT", "smtp"), "Email transport (smtp|file|log)")
+	mailDir := fs.String("mail-dir", envOr("JOBAIO_MAIL_DIR", "tmp/mail"), "Maildir the file transport writes .eml files to")
+	smtpHost := fs.String("smtp-host", envOr("JOBAIO_SMTP_HOST", "smtp.mailtrap.io"), "SMTP host")
+	smtpPort := fs.Int("smtp-port", envIntOr("JOBAIO_SMTP_PORT", 2525), "SMTP port")
+	smtpUsername := fs.String("smtp-username", os.Getenv("JOBAIO_SMTP_USERNAME"), "SMTP username")
+	smtpSecurity := fs.String("smtp-security", envOr("JOBAIO_SMTP_SECURITY", "starttls"), "SMTP connection security (plain|starttls|tls)")
+	smtpSender := fs.String("smtp-sender", envOr("JOBAIO_SMTP_SENDER", "RestrictedJobs <no-reply@restrictedjobs.sparky.net>"), "SMTP sender")
+	queryTimeout := fs.Duration("query-timeout", data.DefaultTimeout, "Timeout of each database query")
+	dryRun := fs.Bool("dry-run", false, "Report what would be done without changing anything")
+	jsonOutput := fs.Bool("json", false, "Write machine-readable JSON output")
+	fs.Parse(os.Args[1:])
+
+	// log entries go to stderr so they never mix with the command output
+	logger := jsonlog.New(os.Stderr, jsonlog.LevelError)
+
+	if fs.NArg() == 0 {
+		fs.Usage()
+		os.Exit(2)
+	}
+	name := fs.Arg(0)
+	run, ok := commands[name]
+	if !ok {
+		fmt.Fprintf(os.Stderr, "unknown command %q\n\n", name)
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	var (
+		transport mailer.Transport
+		err       error
+	)
+	switch *mailTransport {
+	case "file":
+		transport, err = mailer.NewMaildir(*mailDir)
+	case "log":
+		// emails are logged at the info level, which the command logger leaves out
+		transport = mailer.NewLog(jsonlog.New(os.Stderr, jsonlog.LevelInfo))
+	case "smtp":
+		transport, err = mailer.NewSMTP(mailer.SMTPConfig{
+			Host:     *smtpHost,
+			Port:     *smtpPort,
+			Username: *smtpUsername,
+			Password: smtpPassword(),
+			Security: *smtpSecurity,
+		})
+	default:
+		err = fmt.Errorf("unknown mail transport %q", *mailTransport)
+	}
+	if err != nil {
+		logger.PrintFatal(err, nil)
+	}
+
+	db, err := openDB(*dsn)
+	if err != nil {
+		logger.PrintFatal(err, nil)
+	}
+	defer db.Close()
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	app := &admin{
+		ctx:        ctx,
+		db:         db,
+		models:     data.NewModel(db, data.Timeouts{Default: *queryTimeout}),
+		mailer:     mailer.New(transport, *smtpSender),
+		logger:     logger,
+		dryRun:     *dryRun,
+		jsonOutput: *jsonOutput,
+		out:        os.Stdout,
+	}
+
+	res, err := run(app, fs.Args()[1:])
+	res.Command = name
+	res.DryRun = app.dryRun
+	if err != nil {
+		res.Error = err.Error()
+	}
+
+	app.write(res)
+	if err != nil {
+		stop()
+		db.Close()
+		os.Exit(1)
+	}
+}
+
+// write prints the result of a command as JSON or as plain text
+func (app *admin) write(res result) {
+	if app.jsonOutput {
+		enc := json.NewEncoder(app.out)
+		enc.SetIndent("", "\t")
+		enc.Encode(res)
+		return
+	}
+
+	if res.Error != "" {
+		fmt.Fprintf(os.Stderr, "%s: %s\n", res.Command, res.Error)
+		return
+	}
+	if res.DryRun {
+		fmt.Fprint(app.out, "[dry run] ")
+	}
+	fmt.Fprintln(app.out, res.Summary)
+}
+
+// openDB opens the database and checks that it can be reached
+func openDB(dsn string) (*sql.DB, error) {
+	if dsn == "" {
+		return nil, fmt.Errorf("a database connection must be provided with -dsn or JOBAIO_DSN")
+	}
+
+	db, err := sql.Open("postgres", dsn)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if err := db.PingContext(ctx); err != nil {
+		return nil, err
+	}
+	return db, nil
+}
+
+// smtpPassword reads the SMTP password from JOBAIO_SMTP_PASSWORD or the file named by
+// JOBAIO_SMTP_PASSWORD_FILE. There is deliberately no flag, so it never appears in ps.
+func smtpPassword() string {
+	if file := os.Getenv("JOBAIO_SMTP_PASSWORD_FILE"); file != "" {
+		contents, err := os.ReadFile(file)
+		if err == nil {
+			return string(trimNewline(contents))
+		}
+	}
+	return os.Getenv("JOBAIO_SMTP_PASSWORD")
+}
+
+// trimNewline removes a trailing line ending, as left by most editors in secret files
+func trimNewline(b []byte) []byte {
+	for len(b) > 0 && (b[len(b)-1] == '\n' || b[len(b)-1] == '\r') {
+		b = b[:len(b)-1]
+	}
+	return b
+}
+
+// envOr returns the value of an environment variable, or fallback if it is unset
+func envOr(key, fallback string) string {
+	if value, ok := os.LookupEnv(key); ok {
+		return value
+	}
+	return fallback
+}
+
+// envIntOr returns the integer value of an environment variable, or fallback if it is
+// unset or not an integer
+func envIntOr(key string, fallback int) int {
+	if value, err := strconv.Atoi(os.Getenv(key)); err == nil {
+		return value
+	}
+	return fallback
+}
@@ -0,0 +1,266 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/sparkycj328/JobAIO-API/internal/cache"
+	"github.com/sparkycj328/JobAIO-API/internal/data"
+)
+
+// cachedResponse is a successful response to a read endpoint, kept so that identical
+// requests can be answered without querying the store
+type cachedResponse struct {
+	header http.Header
+	body   []byte
+	stored time.Time
+
+	// the writes which make the response stale: writes to any vendor found by search, for
+	// a listing, or to the vendor named in the route
+	search string
+	vendor string
+}
+
+// responseCache holds the cached responses, keyed by request, along with the count of
+// requests which bypassed it
+type responseCache struct {
+	entries  *cache.Cache[string, *cachedResponse]
+	bypasses atomic.Int64
+}
+
+// stats returns the cache counters published on /debug/vars
+func (c *responseCache) stats() map[string]any {
+	stats := c.entries.Stats()
+	return map[string]any{
+		"entries":       stats.Entries,
+		"hits":          stats.Hits,
+		"misses":        stats.Misses,
+		"bypasses":      c.bypasses.Load(),
+		"evictions":     stats.Evictions,
+		"expirations":   stats.Expirations,
+		"invalidations": stats.Invalidations,
+	}
+}
+
+// invalidate drops the responses which may have changed because a snapshot of vendor was
+// written
+func (c *responseCache) invalidate(vendor string) {
+	c.entries.DeleteFunc(func(key string, res *cachedResponse) bool {
+		if res.vendor != "" {
+			return res.vendor == vendor
+		}
+		return data.MatchesSearch(res.search, vendor)
+	})
+}
+
+// setupCache creates the response cache, if it is enabled, and has the vendor store
+// invalidate it whenever a snapshot is written. It must be called once the models are set.
+func (app *application) setupCache() {
+	if !app.config.cache.enabled {
+		return
+	}
+
+	app.cache = &responseCache{entries: cache.New[string, *cachedResponse](app.config.cache.size, app.config.cache.ttl)}
+	app.models.Vendors = cachingVendors{VendorStore: app.models.Vendors, cache: app.cache}
+
+	// expired responses are dropped when looked up, this also frees those which never are
+	app.tasks.Every("cache-prune", time.Minute, func(ctx context.Context) error {
+		app.cache.entries.Prune()
+		return nil
+	})
+}
+
+// clearCache drops every cached response, for changes which can't be tied to a vendor
+func (app *application) clearCache() {
+	if app.cache != nil {
+		app.cache.entries.Clear()
+	}
+}
+
+// cacheKey identifies the response to a request: its path, its query parameters sorted and
+// without empty values, and the response format negotiated from the Accept header
+func cacheKey(r *http.Request, f format) string {
+	query := make(url.Values)
+	for key, values := range r.URL.Query() {
+		for _, v := range values {
+			if v != "" {
+				query.Add(key, v)
+			}
+		}
+	}
+	return f.name + " " + r.URL.Path + "?" + query.Encode()
+}
+
+// cacheBypass reports whether the request asks to skip the cache with the Cache-Control
+// directives no-cache or max-age=0, which fetch a fresh response and cache it, or no-store,
+// which doesn't cache it either. Only admins carrying the admin token may bypass the cache,
+// so that clients polling the API can't defeat it.
+func (app *application) cacheBypass(r *http.Request) (bypass, store bool) {
+	if !app.isAdmin(r) {
+		return false, true
+	}
+
+	store = true
+	directives := r.Header.Values("Cache-Control")
+	if r.Header.Get("Pragma") == "no-cache" {
+		directives = append(directives, "no-cache")
+	}
+	for _, value := range directives {
+		for _, directive := range strings.Split(value, ",") {
+			switch strings.ToLower(strings.TrimSpace(directive)) {
+			case "no-cache", "max-age=0":
+				bypass = true
+			case "no-store":
+				bypass, store = true, false
+			}
+		}
+	}
+	return bypass, store
+}
+
+// cacheResponse serves successful responses of the read endpoint next from the response
+// cache. The X-Cache header tells whether a response was a HIT, a MISS or a BYPASS, and the
+// Age header how long ago a cached response was produced.
+func (app *application) cacheResponse(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		// responses the client can't accept are left to the handler to refuse
+		f, ok := negotiateFormat(r)
+		if app.cache == nil || !ok {
+			next(w, r)
+			return
+		}
+
+		key := cacheKey(r, f)
+		bypass, store := app.cacheBypass(r)
+		if bypass {
+			app.cache.bypasses.Add(1)
+		} else if res, ok := app.cache.entries.Get(key); ok {
+			copyHeader(w.Header(), res.header)
+			w.Header().Set("X-Cache", "HIT")
+			w.Header().Set("Age", strconv.Itoa(int(time.Since(res.stored).Seconds())))
+
+			// like render, skip the body when the client's copy is still current
+			if etagMatches(r.Header.Get("If-None-Match"), res.header.Get("ETag"), true) {
+				w.Header().Del("Content-Type")
+				w.WriteHeader(http.StatusNotModified)
+				return
+			}
+			w.WriteHeader(http.StatusOK)
+			w.Write(res.body)
+			return
+		}
+
+		rec := &responseRecorder{header: make(http.Header), status: http.StatusOK}
+		next(rec, r)
+
+		if rec.status == http.StatusOK && store {
+			res := &cachedResponse{header: rec.header, body: rec.body.Bytes(), stored: time.Now()}
+			if vendor, err := app.readNameParam(r); err == nil {
+				res.vendor = vendor
+			} else {
+				res.search = r.URL.Query().Get("vendor")
+			}
+			app.cache.entries.Set(key, res)
+		}
+
+		copyHeader(w.Header(), rec.header)
+		if bypass {
+			w.Header().Set("X-Cache", "BYPASS")
+		} else {
+			w.Header().Set("X-Cache", "MISS")
+		}
+		w.WriteHeader(rec.status)
+		w.Write(rec.body.Bytes())
+	}
+}
+
+// copyHeader copies the headers set by a handler onto the response. Vary is added to, so
+// that the values set by the middleware before the handler ran are kept.
+func copyHeader(dst, src http.Header) {
+	for key, values := range src {
+		if key == "Vary" {
+			dst[key] = append(dst[key], values...)
+			continue
+		}
+		dst[key] = slices.Clone(values)
+	}
+}
+
+// responseRecorder captures the response written by a handler so that it can be cached
+// before being sent
+type responseRecorder struct {
+	header      http.Header
+	status      int
+	wroteHeader bool
+	body        bytes.Buffer
+}
+
+func (rec *responseRecorder) Header() http.Header {
+	return rec.header
+}
+
+func (rec *responseRecorder) WriteHeader(status int) {
+	if !rec.wroteHeader {
+		rec.status, rec.wroteHeader = status, true
+	}
+}
+
+func (rec *responseRecorder) Write(b []byte) (int, error) {
+	rec.wroteHeader = true
+	return rec.body.Write(b)
+}
+
+// cachingVendors is a VendorStore which invalidates the cached responses about the vendors
+// whose snapshots it writes. Writes made inside Models.WithTx, or by other processes, are
+// not seen and only show once the cached responses expire.
+type cachingVendors struct {
+	data.VendorStore
+	cache *responseCache
+}
+
+func (v cachingVendors) Insert(ctx context.Context, c *data.Company) error {
+	err := v.VendorStore.Insert(ctx, c)
+	if err == nil {
+		v.cache.invalidate(c.Name)
+	}
+	return err
+}
+
+// Update also invalidates the responses about the vendor the snapshot belonged to before,
+// as the update may move it to another vendor
+func (v cachingVendors) Update(ctx context.Context, c *data.Company) error {
+	before, err := v.VendorStore.GetRecord(data.WithPrimary(ctx), c.ID)
+	if err != nil && !errors.Is(err, data.ErrRecordNotFound) {
+		return err
+	}
+
+	err = v.VendorStore.Update(ctx, c)
+	if before != nil {
+		v.cache.invalidate(before.Name)
+	}
+	if err == nil {
+		v.cache.invalidate(c.Name)
+	}
+	return err
+}
+
+func (v cachingVendors) Delete(ctx context.Context, id int64, version int32) error {
+	record, err := v.VendorStore.GetRecord(data.WithPrimary(ctx), id)
+	if err != nil {
+		return err
+	}
+
+	err = v.VendorStore.Delete(ctx, id, version)
+	if err == nil {
+		v.cache.invalidate(record.Name)
+	}
+	return err
+}
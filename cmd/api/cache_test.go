@@ -0,0 +1,179 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestResponseCache(t *testing.T) {
+	const token = "s3cret-admin-token"
+
+	tests := []struct {
+		name      string
+		write     func(t *testing.T, app *application, ts *testServer)
+		path      string
+		header    http.Header
+		wantCache string // X-Cache of the request sent once path has been cached
+	}{
+		{name: "hit", path: "/v1/companies/Acme", wantCache: "HIT"},
+		{name: "query order and empty values ignored", path: "/v1/companies?sort=country&vendor=acme&date=", wantCache: "HIT"},
+		{name: "other format", path: "/v1/companies?vendor=acme", header: headers("Accept", "text/csv"), wantCache: "MISS"},
+		{
+			name: "insert into the vendor",
+			write: func(t *testing.T, app *application, ts *testServer) {
+				body := `{"company": "Acme", "country": "CA", "total": 3, "url": "https://acme.example/jobs"}`
+				if res := ts.request(t, http.MethodPost, "/v1/companies", body, nil); res.status != http.StatusCreated {
+					t.Fatalf("got status %d, want %d: %s", res.status, http.StatusCreated, res.body)
+				}
+			},
+			path:      "/v1/companies/Acme",
+			wantCache: "MISS",
+		},
+		{
+			name: "insert into a vendor found by the listing",
+			write: func(t *testing.T, app *application, ts *testServer) {
+				insertCompany(t, app, "Acme Labs", "US", 3)
+			},
+			path:      "/v1/companies?vendor=acme",
+			wantCache: "MISS",
+		},
+		{
+			name: "insert into another vendor",
+			write: func(t *testing.T, app *application, ts *testServer) {
+				insertCompany(t, app, "Globex", "US", 3)
+			},
+			path:      "/v1/companies/Acme",
+			wantCache: "HIT",
+		},
+		{
+			name: "update moving a snapshot away from the vendor",
+			write: func(t *testing.T, app *application, ts *testServer) {
+				body := `{"company": "Globex", "country": "US", "total": 12, "url": "https://globex.example/jobs"}`
+				if res := ts.request(t, http.MethodPut, "/v1/companies/1", body, headers("If-Match", "*")); res.status != http.StatusOK {
+					t.Fatalf("got status %d, want %d: %s", res.status, http.StatusOK, res.body)
+				}
+			},
+			path:      "/v1/companies?vendor=acme",
+			wantCache: "MISS",
+		},
+		{
+			name: "delete",
+			write: func(t *testing.T, app *application, ts *testServer) {
+				if res := ts.request(t, http.MethodDelete, "/v1/companies/1", "", headers("If-Match", "*")); res.status != http.StatusOK {
+					t.Fatalf("got status %d, want %d: %s", res.status, http.StatusOK, res.body)
+				}
+			},
+			path:      "/v1/companies/Acme/history",
+			wantCache: "MISS",
+		},
+		{name: "admin no-cache", path: "/v1/companies/Acme", header: headers("Authorization", "Bearer "+token, "Cache-Control", "no-cache"), wantCache: "BYPASS"},
+		{name: "admin max-age=0", path: "/v1/companies/Acme", header: headers("Authorization", "Bearer "+token, "Cache-Control", "max-age=0"), wantCache: "BYPASS"},
+		{name: "admin Pragma", path: "/v1/companies/Acme", header: headers("Authorization", "Bearer "+token, "Pragma", "no-cache"), wantCache: "BYPASS"},
+		{name: "no-cache without the admin token", path: "/v1/companies/Acme", header: headers("Cache-Control", "no-cache"), wantCache: "HIT"},
+		{name: "no-cache with a wrong token", path: "/v1/companies/Acme", header: headers("Authorization", "Bearer guess", "Cache-Control", "no-cache"), wantCache: "HIT"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app := newTestApplication(t)
+			app.config.admin.token = token
+			ts := newTestServer(t, app.routes())
+			insertCompany(t, app, "Acme", "US", 12)
+
+			// cache the responses in the format negotiated by default, with the query
+			// parameters in another order
+			for _, path := range []string{"/v1/companies/Acme", "/v1/companies/Acme/history", "/v1/companies?vendor=acme&sort=country", "/v1/companies?vendor=acme"} {
+				if res := ts.get(t, path); res.status != http.StatusOK || res.header.Get("X-Cache") != "MISS" {
+					t.Fatalf("%s: got status %d and X-Cache %q, want %d and MISS", path, res.status, res.header.Get("X-Cache"), http.StatusOK)
+				}
+			}
+
+			if tt.write != nil {
+				tt.write(t, app, ts)
+			}
+
+			res := ts.request(t, http.MethodGet, tt.path, "", tt.header)
+			if res.status != http.StatusOK {
+				t.Fatalf("got status %d, want %d: %s", res.status, http.StatusOK, res.body)
+			}
+			if got := res.header.Get("X-Cache"); got != tt.wantCache {
+				t.Errorf("got X-Cache %q, want %q", got, tt.wantCache)
+			}
+			if got := res.header.Get("Age") != ""; got != (tt.wantCache == "HIT") {
+				t.Errorf("got Age %q on a %s", res.header.Get("Age"), tt.wantCache)
+			}
+		})
+	}
+
+	t.Run("stale response not served", func(t *testing.T) {
+		app := newTestApplication(t)
+		ts := newTestServer(t, app.routes())
+		insertCompany(t, app, "Acme", "US", 12)
+		ts.get(t, "/v1/companies/Acme")
+
+		insertCompany(t, app, "Acme", "US", 40)
+
+		var env struct {
+			Jobs []struct {
+				Total int `json:"total"`
+			} `json:"jobs"`
+		}
+		res := ts.get(t, "/v1/companies/Acme")
+		res.decode(t, &env)
+		if len(env.Jobs) != 2 {
+			t.Errorf("got jobs %s, want both snapshots", res.body)
+		}
+	})
+
+	t.Run("conditional request", func(t *testing.T) {
+		app := newTestApplication(t)
+		ts := newTestServer(t, app.routes())
+		insertCompany(t, app, "Acme", "US", 12)
+
+		etag := ts.get(t, "/v1/companies/Acme").header.Get("ETag")
+		if etag == "" {
+			t.Fatal("got no ETag")
+		}
+
+		res := ts.request(t, http.MethodGet, "/v1/companies/Acme", "", headers("If-None-Match", etag))
+		if res.status != http.StatusNotModified || res.header.Get("X-Cache") != "HIT" || len(res.body) != 0 {
+			t.Errorf("got status %d, X-Cache %q and %d bytes, want %d, HIT and no body",
+				res.status, res.header.Get("X-Cache"), len(res.body), http.StatusNotModified)
+		}
+		if got := res.header.Get("ETag"); got != etag {
+			t.Errorf("got ETag %q, want %q", got, etag)
+		}
+
+		res = ts.request(t, http.MethodGet, "/v1/companies/Acme", "", headers("If-None-Match", `W/"stale"`))
+		if res.status != http.StatusOK || res.header.Get("X-Cache") != "HIT" || len(res.body) == 0 {
+			t.Errorf("got status %d, X-Cache %q and %d bytes, want %d, HIT and the body",
+				res.status, res.header.Get("X-Cache"), len(res.body), http.StatusOK)
+		}
+	})
+
+	t.Run("errors not cached", func(t *testing.T) {
+		app := newTestApplication(t)
+		ts := newTestServer(t, app.routes())
+
+		for _, want := range []string{"MISS", "MISS"} {
+			res := ts.get(t, "/v1/companies/Initech")
+			if res.status != http.StatusNotFound || res.header.Get("X-Cache") != want {
+				t.Errorf("got status %d and X-Cache %q, want %d and %s", res.status, res.header.Get("X-Cache"), http.StatusNotFound, want)
+			}
+		}
+	})
+
+	t.Run("disabled", func(t *testing.T) {
+		app := newTestApplication(t)
+		app.config.cache.enabled = false
+		app.cache = nil
+		ts := newTestServer(t, app.routes())
+		insertCompany(t, app, "Acme", "US", 12)
+
+		for range 2 {
+			if res := ts.get(t, "/v1/companies/Acme"); res.header.Get("X-Cache") != "" {
+				t.Errorf("got X-Cache %q, want none", res.header.Get("X-Cache"))
+			}
+		}
+	})
+}
@@ -1,14 +1,27 @@
 package main
 
 import (
+	"bytes"
+	"encoding/json"
 	"errors"
 	"fmt"
+	jsonpatch "github.com/evanphx/json-patch/v5"
 	"github.com/sparkycj328/JobAIO-API/internal/data"
 	"github.com/sparkycj328/JobAIO-API/internal/validator"
+	"io"
+	"mime"
 	"net/http"
+	"strconv"
+	"strings"
 	"time"
 )
 
+// media types accepted by the PATCH endpoint
+const (
+	mergePatchMediaType = "application/merge-patch+json"
+	jsonPatchMediaType  = "application/json-patch+json"
+)
+
 // createCompanyHandler will insert job postings into the database based on the company name
 func (app *application) createCompanyHandler(w http.ResponseWriter, r *http.Request) {
 
@@ -44,7 +57,7 @@ func (app *application) createCompanyHandler(w http.ResponseWriter, r *http.Requ
 	}
 
 	// Insert the data into the jobs table
-	if err = app.models.Vendors.Insert(company); err != nil {
+	if err = app.models.Vendors.Insert(r.Context(), company); err != nil {
 		app.serverErrorResponse(w, r, err)
 		return
 	}
@@ -70,7 +83,7 @@ func (app *application) showRecordHandler(w http.ResponseWriter, r *http.Request
 		app.notFoundResponse(w, r)
 		return
 	}
-	record, err := app.models.Vendors.GetRecord(id)
+	record, err := app.models.Vendors.GetRecord(r.Context(), id)
 	if err != nil {
 		switch {
 		case errors.Is(err, data.ErrRecordNotFound):
@@ -80,8 +93,15 @@ func (app *application) showRecordHandler(w http.ResponseWriter, r *http.Request
 		}
 		return
 	}
-	// if no error was returned by our Get record query, write the record to JSON
-	if err := app.writeJSON(w, http.StatusOK, envelope{"record": record}, nil); err != nil {
+	// tag the response with the record's version so that the client can make
+	// conditional requests against it
+	headers := make(http.Header)
+	headers.Set("ETag", recordETag(record))
+	headers.Set("Accept-Patch", mergePatchMediaType+", "+jsonPatchMediaType)
+
+	// if no error was returned by our Get record query, render the record in the
+	// format requested by the client
+	if err := app.render(w, r, http.StatusOK, envelope{"record": record}, headers); err != nil {
 		app.serverErrorResponse(w, r, err)
 	}
 }
@@ -129,13 +149,13 @@ func (app *application) listCompanyHandler(w http.ResponseWriter, r *http.Reques
 	}
 
 	// Call the GetAll function in order to grab all rows
-	jobs, metadata, err := app.models.Vendors.GetAllRows(input.Name, input.Total, input.Date, input.Filters)
+	jobs, metadata, err := app.models.Vendors.GetAllRows(r.Context(), input.Name, input.Total, input.Date, input.Filters)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 		return
 	}
 
-	if err := app.writeJSON(w, http.StatusOK, envelope{"metadata": metadata, "jobs": jobs}, nil); err != nil {
+	if err := app.render(w, r, http.StatusOK, envelope{"metadata": metadata, "jobs": jobs}, nil); err != nil {
 		app.serverErrorResponse(w, r, err)
 	}
 }
@@ -148,7 +168,7 @@ func (app *application) showCompanyHandler(w http.ResponseWriter, r *http.Reques
 		return
 	}
 
-	jobs, err := app.models.Vendors.GetRows(name)
+	jobs, err := app.models.Vendors.GetRows(r.Context(), name)
 	if err != nil {
 		switch {
 		case errors.Is(err, data.ErrRecordNotFound):
@@ -159,7 +179,44 @@ func (app *application) showCompanyHandler(w http.ResponseWriter, r *http.Reques
 		return
 	}
 
-	if err := app.writeJSON(w, http.StatusOK, envelope{"jobs": &jobs}, nil); err != nil {
+	if err := app.render(w, r, http.StatusOK, envelope{"jobs": &jobs}, nil); err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// showCompanyHistoryHandler summarises the job snapshots of the specified company over
+// time, by week or by month. Periods which have been rolled up are read from the rollups,
+// so they stay available once the raw snapshots have been purged.
+func (app *application) showCompanyHistoryHandler(w http.ResponseWriter, r *http.Request) {
+	name, err := app.readNameParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	v := validator.New()
+	qs := r.URL.Query()
+
+	// both from and to are included, and default to the year up to today
+	period := app.readString(qs, "period", data.PeriodWeek)
+	country := app.readString(qs, "country", "")
+	to := app.readDate(qs, "to", time.Now(), v)
+	from := app.readDate(qs, "from", to.AddDate(-1, 0, 0), v)
+
+	v.Check(data.ValidPeriod(period), "period", "must be week or month")
+	v.Check(!from.After(to), "from", "must not be after to")
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	history, err := app.models.Rollups.History(r.Context(), name, country, period, from, to.AddDate(0, 0, 1))
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	if err := app.render(w, r, http.StatusOK, envelope{"history": history}, nil); err != nil {
 		app.serverErrorResponse(w, r, err)
 	}
 }
@@ -170,10 +227,12 @@ func (app *application) updateCompanyHandler(w http.ResponseWriter, r *http.Requ
 	id, err := app.readIdParam(r)
 	if err != nil {
 		app.notFoundResponse(w, r)
+		return
 	}
 
-	// fetch the individual record to be updated
-	record, err := app.models.Vendors.GetRecord(id)
+	// fetch the individual record to be updated. It is read from the primary, as a
+	// stale copy from the replica would fail the version check of the write
+	record, err := app.models.Vendors.GetRecord(data.WithPrimary(r.Context()), id)
 	if err != nil {
 		switch {
 		case errors.Is(err, data.ErrRecordNotFound):
@@ -184,6 +243,11 @@ func (app *application) updateCompanyHandler(w http.ResponseWriter, r *http.Requ
 		return
 	}
 
+	// reject the update if the client's copy of the record is stale
+	if !app.checkIfMatch(w, r, record) {
+		return
+	}
+
 	// create a local copy of the company struct which will store the request body
 	var input struct {
 		ID        int64      `json:"-"`                 // Unique integer id for the company
@@ -195,6 +259,7 @@ func (app *application) updateCompanyHandler(w http.ResponseWriter, r *http.Requ
 	}
 	if err := app.readJSON(w, r, &input); err != nil {
 		app.badRequestResponse(w, r, err)
+		return
 	}
 
 	// read the json data from the local input struct into the returned record struct
@@ -205,12 +270,156 @@ func (app *application) updateCompanyHandler(w http.ResponseWriter, r *http.Requ
 
 	// validate that the json data is valid before updating the record in our table
 	v := validator.New()
-	if data.ValidateCompany(v, record); v.Valid() {
+	if data.ValidateCompany(v, record); !v.Valid() {
 		app.failedValidationResponse(w, r, v.Errors)
+		return
 	}
 
 	// write the new company struct to our database
-	if err := app.models.Vendors.Update(record); err != nil {
+	if err := app.models.Vendors.Update(r.Context(), record); err != nil {
+		switch {
+		case errors.Is(err, data.ErrEditConflict):
+			app.editConflictResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	// once updated, return the JSON struct and its new ETag to the client making the request
+	headers := make(http.Header)
+	headers.Set("ETag", recordETag(record))
+
+	if err := app.writeJSON(w, http.StatusOK, envelope{"updated": record}, headers); err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// patchRecordHandler will partially update a record based on the ID parameter. The request
+// body is either a JSON Merge Patch (RFC 7396) or a JSON Patch (RFC 6902) document, chosen
+// by the Content-Type header, which is applied to the editable fields of the current record.
+func (app *application) patchRecordHandler(w http.ResponseWriter, r *http.Request) {
+	// retrieve the id parameter
+	id, err := app.readIdParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	// work out which kind of patch document was sent before doing any further work
+	mediaType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if err != nil || (mediaType != mergePatchMediaType && mediaType != jsonPatchMediaType) {
+		app.unsupportedMediaTypeResponse(w, r)
+		return
+	}
+
+	// fetch the individual record to be patched, from the primary like in
+	// updateCompanyHandler
+	record, err := app.models.Vendors.GetRecord(data.WithPrimary(r.Context()), id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	// reject the patch if the client's copy of the record is stale
+	if !app.checkIfMatch(w, r, record) {
+		return
+	}
+
+	// the fields which a client is allowed to change, using the same JSON keys as the
+	// PUT endpoint. The id, version and created timestamp can never be patched.
+	type fields struct {
+		Name    string `json:"company"`
+		Country string `json:"country"`
+		Total   int    `json:"total"`
+		URL     string `json:"url"`
+	}
+
+	doc, err := json.Marshal(fields{
+		Name:    record.Name,
+		Country: record.Country,
+		Total:   record.Total,
+		URL:     record.URL,
+	})
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	patch, err := io.ReadAll(http.MaxBytesReader(w, r.Body, 1_048_576))
+	if err != nil {
+		app.badRequestResponse(w, r, fmt.Errorf("unable to read patch document: %w", err))
+		return
+	}
+
+	// apply the patch document to the JSON representation of the record
+	var patched []byte
+	switch mediaType {
+	case mergePatchMediaType:
+		patched, err = jsonpatch.MergePatch(doc, patch)
+		if err != nil {
+			app.badRequestResponse(w, r, fmt.Errorf("body contains an invalid merge patch: %w", err))
+			return
+		}
+	case jsonPatchMediaType:
+		operations, err := jsonpatch.DecodePatch(patch)
+		if err != nil {
+			app.badRequestResponse(w, r, fmt.Errorf("body contains an invalid JSON patch: %w", err))
+			return
+		}
+		patched, err = operations.Apply(doc)
+		switch {
+		// a failed test operation means the record isn't in the state the client expected
+		case errors.Is(err, jsonpatch.ErrTestFailed):
+			app.patchConflictResponse(w, r, err)
+			return
+		case err != nil:
+			app.badRequestResponse(w, r, fmt.Errorf("unable to apply the JSON patch: %w", err))
+			return
+		}
+	}
+
+	// read the patched document back, rejecting any keys that aren't editable fields
+	var input fields
+	dec := json.NewDecoder(bytes.NewReader(patched))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&input); err != nil {
+		var unmarshalTypeError *json.UnmarshalTypeError
+		v := validator.New()
+
+		switch {
+		case errors.As(err, &unmarshalTypeError) && unmarshalTypeError.Field != "":
+			v.AddError(unmarshalTypeError.Field, fmt.Sprintf("must not be a JSON %s", unmarshalTypeError.Value))
+		case strings.HasPrefix(err.Error(), "json: unknown field "):
+			key, _ := strconv.Unquote(strings.TrimPrefix(err.Error(), "json: unknown field "))
+			v.AddError(key, "cannot be patched")
+		default:
+			app.badRequestResponse(w, r, fmt.Errorf("patched record is not valid: %w", err))
+			return
+		}
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	record.Name = input.Name
+	record.Country = input.Country
+	record.Total = input.Total
+	record.URL = input.URL
+
+	// validate the patched record before saving it
+	v := validator.New()
+	if data.ValidateCompany(v, record); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	// write the patched record to our database, checking it hasn't changed in the meantime
+	if err := app.models.Vendors.Update(r.Context(), record); err != nil {
 		switch {
 		case errors.Is(err, data.ErrEditConflict):
 			app.editConflictResponse(w, r)
@@ -220,8 +429,10 @@ func (app *application) updateCompanyHandler(w http.ResponseWriter, r *http.Requ
 		return
 	}
 
-	// once updated, return the JSON struct to the client making the request
-	if err := app.writeJSON(w, http.StatusOK, envelope{"updated": record}, nil); err != nil {
+	headers := make(http.Header)
+	headers.Set("ETag", recordETag(record))
+
+	if err := app.writeJSON(w, http.StatusOK, envelope{"updated": record}, headers); err != nil {
 		app.serverErrorResponse(w, r, err)
 	}
 }
@@ -232,9 +443,12 @@ func (app *application) deleteCompanyHandler(w http.ResponseWriter, r *http.Requ
 	id, err := app.readIdParam(r)
 	if err != nil {
 		app.notFoundResponse(w, r)
+		return
 	}
-	// pass the id parameter to the delete function
-	err = app.models.Vendors.Delete(id)
+
+	// fetch the record from the primary so the client's If-Match header can be checked
+	// against its current version
+	record, err := app.models.Vendors.GetRecord(data.WithPrimary(r.Context()), id)
 	if err != nil {
 		switch {
 		case errors.Is(err, data.ErrRecordNotFound):
@@ -244,6 +458,25 @@ func (app *application) deleteCompanyHandler(w http.ResponseWriter, r *http.Requ
 		}
 		return
 	}
+
+	if !app.checkIfMatch(w, r, record) {
+		return
+	}
+
+	// only delete the version the If-Match header was checked against, in case the
+	// record was changed since it was read
+	err = app.models.Vendors.Delete(r.Context(), id, record.Version)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		case errors.Is(err, data.ErrEditConflict):
+			app.preconditionFailedResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
 	// write a JSON response upon successful deletion of the record
 	if err := app.writeJSON(w, http.StatusOK, envelope{"message": "record successfully updated"}, nil); err != nil {
 		app.serverErrorResponse(w, r, err)
@@ -0,0 +1,637 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sparkycj328/JobAIO-API/internal/data"
+)
+
+func TestCreateCompany(t *testing.T) {
+	app := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+
+	tests := []struct {
+		name       string
+		body       string
+		wantStatus int
+		wantFields []string // fields expected in a failed validation response
+		wantError  string   // substring expected in a bad request response
+	}{
+		{
+			name:       "valid",
+			body:       `{"company": "Acme", "country": "US", "total": 12, "url": "https://acme.example/jobs"}`,
+			wantStatus: http.StatusCreated,
+		},
+		{
+			name:       "zero total",
+			body:       `{"company": "Acme", "country": "CA", "total": 0, "url": "https://acme.example/jobs"}`,
+			wantStatus: http.StatusCreated,
+		},
+		{
+			name:       "missing fields",
+			body:       `{"total": 1}`,
+			wantStatus: http.StatusUnprocessableEntity,
+			wantFields: []string{"name", "country", "url"},
+		},
+		{
+			name:       "negative total",
+			body:       `{"company": "Acme", "country": "US", "total": -1, "url": "https://acme.example/jobs"}`,
+			wantStatus: http.StatusUnprocessableEntity,
+			wantFields: []string{"amount"},
+		},
+		{
+			name:       "name too long",
+			body:       `{"company": "` + strings.Repeat("a", 101) + `", "country": "US", "total": 1, "url": "https://acme.example/jobs"}`,
+			wantStatus: http.StatusUnprocessableEntity,
+			wantFields: []string{"name"},
+		},
+		{
+			name:       "empty body",
+			wantStatus: http.StatusBadRequest,
+			wantError:  "body must not be empty",
+		},
+		{
+			name:       "badly-formed JSON",
+			body:       `{"company": "Acme",}`,
+			wantStatus: http.StatusBadRequest,
+			wantError:  "badly-formed JSON",
+		},
+		{
+			name:       "truncated JSON",
+			body:       `{"company": "Acme"`,
+			wantStatus: http.StatusBadRequest,
+			wantError:  "badly-formed JSON",
+		},
+		{
+			name:       "wrong type",
+			body:       `{"company": "Acme", "total": "twelve"}`,
+			wantStatus: http.StatusBadRequest,
+			wantError:  `incorrect JSON type for field "total"`,
+		},
+		{
+			name:       "unknown key",
+			body:       `{"company": "Acme", "employees": 10}`,
+			wantStatus: http.StatusBadRequest,
+			wantError:  `unknown key "employees"`,
+		},
+		{
+			name:       "several values",
+			body:       `{"company": "Acme"} {"company": "Globex"}`,
+			wantStatus: http.StatusBadRequest,
+			wantError:  "single JSON value",
+		},
+		{
+			name:       "too large",
+			body:       `{"company": "` + strings.Repeat("a", 1_048_576) + `"}`,
+			wantStatus: http.StatusBadRequest,
+			wantError:  "no larger than 1048576 bytes",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			res := ts.request(t, http.MethodPost, "/v1/companies", tt.body, nil)
+			if res.status != tt.wantStatus {
+				t.Fatalf("got status %d, want %d: %s", res.status, tt.wantStatus, res.body)
+			}
+
+			switch {
+			case tt.wantStatus == http.StatusCreated:
+				var env struct {
+					Company data.Company `json:"company"`
+				}
+				res.decode(t, &env)
+				if env.Company.ID < 1 || env.Company.Version != 1 || env.Company.CreatedAt == nil {
+					t.Errorf("got company %+v, want an id, version 1 and a created time", env.Company)
+				}
+				if got, want := res.header.Get("Location"), "/v1/companies/Acme"; got != want {
+					t.Errorf("got Location %q, want %q", got, want)
+				}
+			case tt.wantFields != nil:
+				fields := res.errorFields(t)
+				for _, field := range tt.wantFields {
+					if _, ok := fields[field]; !ok {
+						t.Errorf("got errors %v, want an error for %q", fields, field)
+					}
+				}
+			case tt.wantError != "":
+				if msg := res.errorMessage(t); !strings.Contains(msg, tt.wantError) {
+					t.Errorf("got error %q, want it to contain %q", msg, tt.wantError)
+				}
+			}
+		})
+	}
+}
+
+func TestShowRecord(t *testing.T) {
+	app := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+
+	record := insertCompany(t, app, "Acme", "US", 12)
+	etag := recordETag(record)
+
+	tests := []struct {
+		name        string
+		path        string
+		header      http.Header
+		wantStatus  int
+		wantType    string
+		wantETag    string
+		wantEmpty   bool
+		wantContent string
+	}{
+		{name: "json", path: "/v1/record/1", wantStatus: http.StatusOK, wantType: "application/json", wantETag: etag, wantContent: `"company": "Acme"`},
+		{name: "csv", path: "/v1/record/1", header: headers("Accept", "text/csv"), wantStatus: http.StatusOK, wantType: "text/csv", wantETag: `"1-1-csv"`, wantContent: "Acme"},
+		{name: "xml", path: "/v1/record/1", header: headers("Accept", "application/xml"), wantStatus: http.StatusOK, wantType: "application/xml", wantContent: "<company>Acme</company>"},
+		{name: "format in the URL", path: "/v1/record/1?format=csv", wantStatus: http.StatusOK, wantType: "text/csv"},
+		{name: "not acceptable", path: "/v1/record/1", header: headers("Accept", "image/png"), wantStatus: http.StatusNotAcceptable},
+		{name: "wildcard without refused format", path: "/v1/record/1", header: headers("Accept", "application/json;q=0, */*"), wantStatus: http.StatusOK, wantType: "text/csv"},
+		{name: "only format refused", path: "/v1/record/1", header: headers("Accept", "application/json;q=0"), wantStatus: http.StatusNotAcceptable},
+		{name: "not modified", path: "/v1/record/1", header: headers("If-None-Match", etag), wantStatus: http.StatusNotModified, wantEmpty: true},
+		{name: "modified", path: "/v1/record/1", header: headers("If-None-Match", `"1-0"`), wantStatus: http.StatusOK},
+		{name: "csv not modified", path: "/v1/record/1", header: headers("Accept", "text/csv", "If-None-Match", `"1-1-csv"`), wantStatus: http.StatusNotModified, wantEmpty: true},
+		{name: "other format cached", path: "/v1/record/1", header: headers("Accept", "text/csv", "If-None-Match", etag), wantStatus: http.StatusOK, wantContent: "Acme"},
+		{name: "missing record", path: "/v1/record/2", wantStatus: http.StatusNotFound},
+		{name: "zero id", path: "/v1/record/0", wantStatus: http.StatusNotFound},
+		{name: "negative id", path: "/v1/record/-1", wantStatus: http.StatusNotFound},
+		{name: "non-numeric id", path: "/v1/record/acme", wantStatus: http.StatusNotFound},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			res := ts.request(t, http.MethodGet, tt.path, "", tt.header)
+			if res.status != tt.wantStatus {
+				t.Fatalf("got status %d, want %d: %s", res.status, tt.wantStatus, res.body)
+			}
+			if tt.wantType != "" && !strings.HasPrefix(res.header.Get("Content-Type"), tt.wantType) {
+				t.Errorf("got Content-Type %q, want %q", res.header.Get("Content-Type"), tt.wantType)
+			}
+			if tt.wantETag != "" && res.header.Get("ETag") != tt.wantETag {
+				t.Errorf("got ETag %q, want %q", res.header.Get("ETag"), tt.wantETag)
+			}
+			if tt.wantEmpty && len(res.body) != 0 {
+				t.Errorf("got body %s, want none", res.body)
+			}
+			if !strings.Contains(string(res.body), tt.wantContent) {
+				t.Errorf("got body %s, want it to contain %q", res.body, tt.wantContent)
+			}
+		})
+	}
+}
+
+func TestListCompanies(t *testing.T) {
+	app := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+
+	insertCompany(t, app, "Acme Corp", "US", 30)
+	insertCompany(t, app, "Acme Corp", "CA", 10)
+	insertCompany(t, app, "Globex", "US", 20)
+	insertCompany(t, app, "Initech", "DE", 0)
+
+	// a snapshot from another day, which is only listed when its date is asked for
+	old := time.Date(2024, time.March, 5, 12, 0, 0, 0, time.UTC)
+	err := app.models.Vendors.Insert(context.Background(), &data.Company{Name: "Acme Corp", Country: "FR", Total: 5, URL: "https://acme.example", CreatedAt: &old})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name         string
+		query        string
+		wantStatus   int
+		wantIDs      []int64
+		wantMetadata data.Metadata
+		wantFields   []string
+	}{
+		{
+			name:         "default sort by vendor",
+			wantStatus:   http.StatusOK,
+			wantIDs:      []int64{1, 2, 3},
+			wantMetadata: data.Metadata{CurrentPage: 1, PageSize: 20, FirstPage: 1, LastPage: 1, TotalRecords: 3},
+		},
+		{
+			name:         "vendor search",
+			query:        "?vendor=acme",
+			wantStatus:   http.StatusOK,
+			wantIDs:      []int64{1, 2},
+			wantMetadata: data.Metadata{CurrentPage: 1, PageSize: 20, FirstPage: 1, LastPage: 1, TotalRecords: 2},
+		},
+		{
+			name:       "vendor search needs every word",
+			query:      "?vendor=acme+globex",
+			wantStatus: http.StatusOK,
+			wantIDs:    []int64{},
+		},
+		{
+			name:         "more than a total",
+			query:        "?total=15",
+			wantStatus:   http.StatusOK,
+			wantIDs:      []int64{1, 3},
+			wantMetadata: data.Metadata{CurrentPage: 1, PageSize: 20, FirstPage: 1, LastPage: 1, TotalRecords: 2},
+		},
+		{
+			name:         "sort descending",
+			query:        "?sort=-amount",
+			wantStatus:   http.StatusOK,
+			wantIDs:      []int64{1, 3, 2},
+			wantMetadata: data.Metadata{CurrentPage: 1, PageSize: 20, FirstPage: 1, LastPage: 1, TotalRecords: 3},
+		},
+		{
+			name:         "sort by country",
+			query:        "?sort=country",
+			wantStatus:   http.StatusOK,
+			wantIDs:      []int64{2, 1, 3},
+			wantMetadata: data.Metadata{CurrentPage: 1, PageSize: 20, FirstPage: 1, LastPage: 1, TotalRecords: 3},
+		},
+		{
+			name:         "second page",
+			query:        "?sort=id&page=2&page_size=2",
+			wantStatus:   http.StatusOK,
+			wantIDs:      []int64{3},
+			wantMetadata: data.Metadata{CurrentPage: 2, PageSize: 2, FirstPage: 1, LastPage: 2, TotalRecords: 3},
+		},
+		{
+			name:       "page past the end",
+			query:      "?page=5&page_size=2",
+			wantStatus: http.StatusOK,
+			wantIDs:    []int64{},
+		},
+		{
+			name:         "another day",
+			query:        "?date=2024-Mar-05",
+			wantStatus:   http.StatusOK,
+			wantIDs:      []int64{5},
+			wantMetadata: data.Metadata{CurrentPage: 1, PageSize: 20, FirstPage: 1, LastPage: 1, TotalRecords: 1},
+		},
+		{name: "invalid sort", query: "?sort=url", wantStatus: http.StatusUnprocessableEntity, wantFields: []string{"sort"}},
+		{name: "zero page", query: "?page=0", wantStatus: http.StatusUnprocessableEntity, wantFields: []string{"page"}},
+		{name: "page size too large", query: "?page_size=101", wantStatus: http.StatusUnprocessableEntity, wantFields: []string{"page_size"}},
+		{name: "non-numeric total", query: "?total=many", wantStatus: http.StatusUnprocessableEntity, wantFields: []string{"total"}},
+		{name: "invalid date", query: "?date=2024-03-05", wantStatus: http.StatusUnprocessableEntity, wantFields: []string{"date"}},
+		{name: "several errors", query: "?page=-1&page_size=0&sort=nope", wantStatus: http.StatusUnprocessableEntity, wantFields: []string{"page", "page_size", "sort"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			res := ts.get(t, "/v1/companies"+tt.query)
+			if res.status != tt.wantStatus {
+				t.Fatalf("got status %d, want %d: %s", res.status, tt.wantStatus, res.body)
+			}
+
+			if tt.wantFields != nil {
+				fields := res.errorFields(t)
+				for _, field := range tt.wantFields {
+					if _, ok := fields[field]; !ok {
+						t.Errorf("got errors %v, want an error for %q", fields, field)
+					}
+				}
+				return
+			}
+
+			var env struct {
+				Jobs     []data.Company `json:"jobs"`
+				Metadata data.Metadata  `json:"metadata"`
+			}
+			res.decode(t, &env)
+
+			ids := make([]int64, 0, len(env.Jobs))
+			for _, job := range env.Jobs {
+				ids = append(ids, job.ID)
+			}
+			if !slices.Equal(ids, tt.wantIDs) {
+				t.Errorf("got ids %v, want %v", ids, tt.wantIDs)
+			}
+			if env.Metadata != tt.wantMetadata {
+				t.Errorf("got metadata %+v, want %+v", env.Metadata, tt.wantMetadata)
+			}
+		})
+	}
+}
+
+func TestShowCompany(t *testing.T) {
+	app := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+
+	insertCompany(t, app, "Acme", "US", 30)
+	insertCompany(t, app, "Acme", "CA", 10)
+	insertCompany(t, app, "Acme", "DE", 0)
+	insertCompany(t, app, "Globex", "US", 20)
+
+	tests := []struct {
+		name          string
+		path          string
+		wantStatus    int
+		wantCountries []string
+	}{
+		{name: "countries with jobs today", path: "/v1/companies/Acme", wantStatus: http.StatusOK, wantCountries: []string{"CA", "US"}},
+		{name: "exact name only", path: "/v1/companies/acme", wantStatus: http.StatusNotFound},
+		{name: "unknown company", path: "/v1/companies/Initech", wantStatus: http.StatusNotFound},
+		{name: "invalid name", path: "/v1/companies/Acme-Corp", wantStatus: http.StatusNotFound},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			res := ts.get(t, tt.path)
+			if res.status != tt.wantStatus {
+				t.Fatalf("got status %d, want %d: %s", res.status, tt.wantStatus, res.body)
+			}
+			if tt.wantCountries == nil {
+				return
+			}
+
+			var env struct {
+				Jobs []data.Company `json:"jobs"`
+			}
+			res.decode(t, &env)
+
+			var countries []string
+			for _, job := range env.Jobs {
+				countries = append(countries, job.Country)
+			}
+			if strings.Join(countries, ",") != strings.Join(tt.wantCountries, ",") {
+				t.Errorf("got countries %v, want %v", countries, tt.wantCountries)
+			}
+		})
+	}
+}
+
+func TestUpdateCompany(t *testing.T) {
+	valid := `{"company": "Acme", "country": "CA", "total": 40, "url": "https://acme.example/jobs"}`
+
+	tests := []struct {
+		name        string
+		path        string
+		body        string
+		ifMatch     string
+		wantStatus  int
+		wantVersion int32 // version stored once the request is done
+	}{
+		{name: "valid", path: "/v1/companies/1", body: valid, ifMatch: `"1-1"`, wantStatus: http.StatusOK, wantVersion: 2},
+		{name: "any version", path: "/v1/companies/1", body: valid, ifMatch: "*", wantStatus: http.StatusOK, wantVersion: 2},
+		{name: "missing If-Match", path: "/v1/companies/1", body: valid, wantStatus: http.StatusPreconditionRequired, wantVersion: 1},
+		{name: "stale If-Match", path: "/v1/companies/1", body: valid, ifMatch: `"1-0"`, wantStatus: http.StatusPreconditionFailed, wantVersion: 1},
+		{name: "weak If-Match", path: "/v1/companies/1", body: valid, ifMatch: `W/"1-1"`, wantStatus: http.StatusPreconditionFailed, wantVersion: 1},
+		{name: "If-Match of another format", path: "/v1/companies/1", body: valid, ifMatch: `"1-1-xml"`, wantStatus: http.StatusOK, wantVersion: 2},
+		{name: "badly-formed JSON", path: "/v1/companies/1", body: `{"company": `, ifMatch: `"1-1"`, wantStatus: http.StatusBadRequest, wantVersion: 1},
+		{name: "empty body", path: "/v1/companies/1", ifMatch: `"1-1"`, wantStatus: http.StatusBadRequest, wantVersion: 1},
+		{name: "invalid record", path: "/v1/companies/1", body: `{"company": "Acme", "total": -5}`, ifMatch: `"1-1"`, wantStatus: http.StatusUnprocessableEntity, wantVersion: 1},
+		{name: "missing record", path: "/v1/companies/7", body: valid, ifMatch: `"7-1"`, wantStatus: http.StatusNotFound, wantVersion: 1},
+		{name: "invalid id", path: "/v1/companies/acme", body: valid, ifMatch: `"1-1"`, wantStatus: http.StatusNotFound, wantVersion: 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app := newTestApplication(t)
+			ts := newTestServer(t, app.routes())
+			insertCompany(t, app, "Acme", "US", 12)
+
+			var header http.Header
+			if tt.ifMatch != "" {
+				header = headers("If-Match", tt.ifMatch)
+			}
+
+			res := ts.request(t, http.MethodPut, tt.path, tt.body, header)
+			if res.status != tt.wantStatus {
+				t.Fatalf("got status %d, want %d: %s", res.status, tt.wantStatus, res.body)
+			}
+
+			record, err := app.models.Vendors.GetRecord(context.Background(), 1)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if record.Version != tt.wantVersion {
+				t.Errorf("got stored version %d, want %d", record.Version, tt.wantVersion)
+			}
+
+			if tt.wantStatus == http.StatusOK {
+				if record.Country != "CA" || record.Total != 40 {
+					t.Errorf("got stored record %+v, want the update applied", record)
+				}
+				if got, want := res.header.Get("ETag"), recordETag(record); got != want {
+					t.Errorf("got ETag %q, want %q", got, want)
+				}
+			}
+		})
+	}
+}
+
+// racingVendors is a VendorStore in which another client updates every record straight
+// after it has been read, so that the caller's update always conflicts
+type racingVendors struct {
+	data.VendorStore
+}
+
+func (v racingVendors) GetRecord(ctx context.Context, id int64) (*data.Company, error) {
+	record, err := v.VendorStore.GetRecord(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	other := *record
+	other.Total++
+	if err := v.VendorStore.Update(ctx, &other); err != nil {
+		return nil, err
+	}
+	return record, nil
+}
+
+func TestEditConflict(t *testing.T) {
+	tests := []struct {
+		name   string
+		method string
+		header http.Header
+		body   string
+	}{
+		{name: "update", method: http.MethodPut, header: headers("If-Match", `"1-1"`), body: `{"company": "Acme", "country": "CA", "total": 1, "url": "https://acme.example"}`},
+		{name: "merge patch", method: http.MethodPatch, header: headers("If-Match", `"1-1"`, "Content-Type", mergePatchMediaType), body: `{"total": 1}`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app := newTestApplication(t)
+			insertCompany(t, app, "Acme", "US", 12)
+			app.models.Vendors = racingVendors{app.models.Vendors}
+			ts := newTestServer(t, app.routes())
+
+			path := "/v1/companies/1"
+			if tt.method == http.MethodPatch {
+				path = "/v1/record/1"
+			}
+
+			res := ts.request(t, tt.method, path, tt.body, tt.header)
+			if res.status != http.StatusConflict {
+				t.Fatalf("got status %d, want %d: %s", res.status, http.StatusConflict, res.body)
+			}
+			if !strings.Contains(string(res.body), "edit conflict") {
+				t.Errorf("got body %s, want an edit conflict error", res.body)
+			}
+		})
+	}
+}
+
+func TestPatchRecord(t *testing.T) {
+	tests := []struct {
+		name        string
+		contentType string
+		ifMatch     string
+		body        string
+		wantStatus  int
+		wantTotal   int // total stored once the request is done
+		wantCountry string
+	}{
+		{name: "merge patch", contentType: mergePatchMediaType, ifMatch: `"1-1"`, body: `{"total": 20}`, wantStatus: http.StatusOK, wantTotal: 20, wantCountry: "US"},
+		{name: "merge patch with charset", contentType: mergePatchMediaType + "; charset=utf-8", ifMatch: `"1-1"`, body: `{"country": "CA"}`, wantStatus: http.StatusOK, wantTotal: 12, wantCountry: "CA"},
+		{name: "JSON patch", contentType: jsonPatchMediaType, ifMatch: `"1-1"`, body: `[{"op": "replace", "path": "/total", "value": 30}]`, wantStatus: http.StatusOK, wantTotal: 30, wantCountry: "US"},
+		{name: "JSON patch test passes", contentType: jsonPatchMediaType, ifMatch: `"1-1"`, body: `[{"op": "test", "path": "/total", "value": 12}, {"op": "replace", "path": "/total", "value": 13}]`, wantStatus: http.StatusOK, wantTotal: 13, wantCountry: "US"},
+		{name: "JSON patch test fails", contentType: jsonPatchMediaType, ifMatch: `"1-1"`, body: `[{"op": "test", "path": "/total", "value": 99}, {"op": "replace", "path": "/total", "value": 13}]`, wantStatus: http.StatusConflict, wantTotal: 12, wantCountry: "US"},
+		{name: "patch read-only field", contentType: mergePatchMediaType, ifMatch: `"1-1"`, body: `{"version": 9}`, wantStatus: http.StatusUnprocessableEntity, wantTotal: 12, wantCountry: "US"},
+		{name: "patch wrong type", contentType: mergePatchMediaType, ifMatch: `"1-1"`, body: `{"total": "many"}`, wantStatus: http.StatusUnprocessableEntity, wantTotal: 12, wantCountry: "US"},
+		{name: "JSON patch missing path", contentType: jsonPatchMediaType, ifMatch: `"1-1"`, body: `[{"op": "remove", "path": "/missing"}]`, wantStatus: http.StatusBadRequest, wantTotal: 12, wantCountry: "US"},
+		{name: "invalid merge patch", contentType: mergePatchMediaType, ifMatch: `"1-1"`, body: `{"total": `, wantStatus: http.StatusBadRequest, wantTotal: 12, wantCountry: "US"},
+		{name: "invalid JSON patch", contentType: jsonPatchMediaType, ifMatch: `"1-1"`, body: `{"op": "replace"}`, wantStatus: http.StatusBadRequest, wantTotal: 12, wantCountry: "US"},
+		{name: "invalid record", contentType: mergePatchMediaType, ifMatch: `"1-1"`, body: `{"total": -1}`, wantStatus: http.StatusUnprocessableEntity, wantTotal: 12, wantCountry: "US"},
+		{name: "plain JSON", contentType: "application/json", ifMatch: `"1-1"`, body: `{"total": 20}`, wantStatus: http.StatusUnsupportedMediaType, wantTotal: 12, wantCountry: "US"},
+		{name: "missing If-Match", contentType: mergePatchMediaType, body: `{"total": 20}`, wantStatus: http.StatusPreconditionRequired, wantTotal: 12, wantCountry: "US"},
+		{name: "stale If-Match", contentType: mergePatchMediaType, ifMatch: `"1-2"`, body: `{"total": 20}`, wantStatus: http.StatusPreconditionFailed, wantTotal: 12, wantCountry: "US"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app := newTestApplication(t)
+			ts := newTestServer(t, app.routes())
+			insertCompany(t, app, "Acme", "US", 12)
+
+			header := headers("Content-Type", tt.contentType)
+			if tt.ifMatch != "" {
+				header.Set("If-Match", tt.ifMatch)
+			}
+
+			res := ts.request(t, http.MethodPatch, "/v1/record/1", tt.body, header)
+			if res.status != tt.wantStatus {
+				t.Fatalf("got status %d, want %d: %s", res.status, tt.wantStatus, res.body)
+			}
+			if tt.wantStatus == http.StatusUnsupportedMediaType && res.header.Get("Accept-Patch") == "" {
+				t.Error("got no Accept-Patch header")
+			}
+
+			record, err := app.models.Vendors.GetRecord(context.Background(), 1)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if record.Total != tt.wantTotal || record.Country != tt.wantCountry || record.Name != "Acme" {
+				t.Errorf("got stored record %+v, want total %d in %s", record, tt.wantTotal, tt.wantCountry)
+			}
+		})
+	}
+}
+
+func TestPatchMissingRecord(t *testing.T) {
+	app := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+
+	res := ts.request(t, http.MethodPatch, "/v1/record/1", `{"total": 1}`, headers("Content-Type", mergePatchMediaType, "If-Match", "*"))
+	if res.status != http.StatusNotFound {
+		t.Fatalf("got status %d, want %d: %s", res.status, http.StatusNotFound, res.body)
+	}
+}
+
+func TestDeleteCompany(t *testing.T) {
+	tests := []struct {
+		name       string
+		path       string
+		ifMatch    string
+		wantStatus int
+		wantKept   bool
+	}{
+		{name: "valid", path: "/v1/companies/1", ifMatch: `"1-1"`, wantStatus: http.StatusOK},
+		{name: "missing If-Match", path: "/v1/companies/1", wantStatus: http.StatusPreconditionRequired, wantKept: true},
+		{name: "stale If-Match", path: "/v1/companies/1", ifMatch: `"1-2"`, wantStatus: http.StatusPreconditionFailed, wantKept: true},
+		{name: "missing record", path: "/v1/companies/2", ifMatch: "*", wantStatus: http.StatusNotFound, wantKept: true},
+		{name: "invalid id", path: "/v1/companies/0", ifMatch: "*", wantStatus: http.StatusNotFound, wantKept: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app := newTestApplication(t)
+			ts := newTestServer(t, app.routes())
+			insertCompany(t, app, "Acme", "US", 12)
+
+			var header http.Header
+			if tt.ifMatch != "" {
+				header = headers("If-Match", tt.ifMatch)
+			}
+
+			res := ts.request(t, http.MethodDelete, tt.path, "", header)
+			if res.status != tt.wantStatus {
+				t.Fatalf("got status %d, want %d: %s", res.status, tt.wantStatus, res.body)
+			}
+
+			if got := ts.get(t, "/v1/record/1").status == http.StatusOK; got != tt.wantKept {
+				t.Errorf("got record kept %t, want %t", got, tt.wantKept)
+			}
+		})
+	}
+}
+
+func TestDeleteStaleVersion(t *testing.T) {
+	tests := []struct {
+		name    string
+		id      int64
+		version int32
+		wantErr error
+	}{
+		// a record updated between the If-Match check and the delete must be kept
+		{name: "updated record", id: 1, version: 2, wantErr: data.ErrEditConflict},
+		// while one deleted in the meantime is reported as missing by every store
+		{name: "deleted record", id: 2, version: 1, wantErr: data.ErrRecordNotFound},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app := newTestApplication(t)
+			insertCompany(t, app, "Acme", "US", 12)
+
+			err := app.models.Vendors.Delete(context.Background(), tt.id, tt.version)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("got error %v, want %v", err, tt.wantErr)
+			}
+			if _, err := app.models.Vendors.GetRecord(context.Background(), 1); err != nil {
+				t.Fatalf("got error %v fetching the kept record", err)
+			}
+		})
+	}
+}
+
+func TestStoreErrors(t *testing.T) {
+	tests := []struct {
+		name       string
+		err        error
+		wantStatus int
+	}{
+		{name: "failure", err: errStoreFailed, wantStatus: http.StatusInternalServerError},
+		{name: "timeout", err: context.DeadlineExceeded, wantStatus: http.StatusGatewayTimeout},
+		{name: "unavailable", err: data.ErrUnavailable, wantStatus: http.StatusServiceUnavailable},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app := newTestApplication(t)
+			app.models.Vendors = failingVendors{VendorStore: app.models.Vendors, err: tt.err}
+			ts := newTestServer(t, app.routes())
+
+			res := ts.get(t, "/v1/record/1")
+			if res.status != tt.wantStatus {
+				t.Fatalf("got status %d, want %d: %s", res.status, tt.wantStatus, res.body)
+			}
+			if strings.Contains(string(res.body), tt.err.Error()) {
+				t.Errorf("got body %s, which leaks the store's error", res.body)
+			}
+		})
+	}
+}
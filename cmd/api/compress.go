@@ -0,0 +1,313 @@
+package main
+
+import (
+	"compress/gzip"
+	"io"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/andybalholm/brotli"
+)
+
+// encoder is implemented by both gzip.Writer and brotli.Writer, which lets pooled
+// writers be pointed at a new response with Reset instead of being reallocated
+type encoder interface {
+	io.WriteCloser
+	Flush() error
+	Reset(w io.Writer)
+}
+
+// encoderPools holds a pool of reusable encoders for each supported content coding
+var encoderPools = map[string]*sync.Pool{
+	"br": {New: func() any {
+		return brotli.NewWriterLevel(io.Discard, 4)
+	}},
+	"gzip": {New: func() any {
+		w, _ := gzip.NewWriterLevel(io.Discard, 5)
+		return w
+	}},
+}
+
+// incompressibleTypes lists content types which are already compressed, so running them
+// through another encoder would only waste CPU
+var incompressibleTypes = []string{
+	"image/",
+	"video/",
+	"audio/",
+	"font/woff",
+	"application/zip",
+	"application/gzip",
+	"application/x-gzip",
+	"application/zstd",
+	"application/x-bzip2",
+	"application/x-7z-compressed",
+	"application/pdf",
+	"application/octet-stream",
+}
+
+// negotiateEncoding picks the content coding to use for a response from the client's
+// Accept-Encoding header, returning an empty string if the response should be sent
+// uncompressed. Brotli is preferred over gzip when the client weights them equally.
+func negotiateEncoding(header string) string {
+	best, bestQ := "", 0.0
+	wildcardQ := -1.0
+	seen := make(map[string]float64)
+
+	for _, part := range strings.Split(header, ",") {
+		coding, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		coding = strings.ToLower(strings.TrimSpace(coding))
+		if coding == "" {
+			continue
+		}
+
+		// read the quality value, which defaults to 1 when omitted
+		q := 1.0
+		for _, param := range strings.Split(params, ";") {
+			key, value, ok := strings.Cut(strings.TrimSpace(param), "=")
+			if ok && strings.EqualFold(key, "q") {
+				if parsed, err := strconv.ParseFloat(value, 64); err == nil {
+					q = parsed
+				}
+			}
+		}
+
+		if coding == "*" {
+			wildcardQ = q
+			continue
+		}
+		seen[coding] = q
+	}
+
+	for _, coding := range []string{"br", "gzip"} {
+		q, ok := seen[coding]
+		if !ok {
+			q = wildcardQ
+		}
+		if q > bestQ {
+			best, bestQ = coding, q
+		}
+	}
+
+	return best
+}
+
+// stripEncodingSuffix removes the content coding suffix which compressWriter adds to ETags
+// from each tag in an If-Match or If-None-Match header, so that handlers can compare them
+// with the ETags of their uncompressed responses
+func stripEncodingSuffix(header string) string {
+	tags := strings.Split(header, ",")
+	for i, tag := range tags {
+		tag = strings.TrimSpace(tag)
+		for coding := range encoderPools {
+			if trimmed, ok := strings.CutSuffix(tag, "-"+coding+`"`); ok {
+				tag = trimmed + `"`
+				break
+			}
+		}
+		tags[i] = tag
+	}
+	return strings.Join(tags, ", ")
+}
+
+// compressible reports whether a response with the given Content-Type header is worth
+// compressing
+func compressible(contentType string) bool {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+
+	for _, prefix := range incompressibleTypes {
+		if strings.HasPrefix(mediaType, prefix) {
+			return false
+		}
+	}
+	return true
+}
+
+// compressWriter wraps a http.ResponseWriter and buffers the start of the response body
+// until minSize bytes have been written. Only then does it decide whether to compress the
+// response, so small bodies are sent as-is and never pay the encoding overhead.
+type compressWriter struct {
+	http.ResponseWriter
+	encoding    string
+	minSize     int
+	ifNoneMatch string // the request's If-None-Match header, before its suffixes were removed
+
+	status      int
+	wroteHeader bool
+	decided     bool
+	buf         []byte
+	enc         encoder
+}
+
+// WriteHeader records the status code. The underlying header is only written once it is
+// known whether the body will be compressed, as that changes the response headers.
+func (cw *compressWriter) WriteHeader(status int) {
+	if cw.wroteHeader {
+		return
+	}
+	cw.wroteHeader = true
+	cw.status = status
+
+	// responses which cannot carry a body are passed straight through. A 304 repeats the
+	// ETag of the client's cached copy, which is the compressed one if that is what it sent.
+	if status < http.StatusOK || status == http.StatusNoContent || status == http.StatusNotModified {
+		if etag := cw.Header().Get("ETag"); status == http.StatusNotModified && etag != "" {
+			if suffixed := suffixETag(etag, cw.encoding); etagMatches(cw.ifNoneMatch, suffixed, true) {
+				cw.Header().Set("ETag", suffixed)
+			}
+		}
+		cw.decided = true
+		cw.ResponseWriter.WriteHeader(status)
+	}
+}
+
+// Write buffers the body until the minimum size is reached, after which all data is sent
+// through the encoder (or directly to the client if compression was ruled out).
+func (cw *compressWriter) Write(p []byte) (int, error) {
+	if !cw.wroteHeader {
+		cw.WriteHeader(http.StatusOK)
+	}
+
+	if cw.decided {
+		if cw.enc != nil {
+			return cw.enc.Write(p)
+		}
+		return cw.ResponseWriter.Write(p)
+	}
+
+	cw.buf = append(cw.buf, p...)
+	if len(cw.buf) >= cw.minSize {
+		if err := cw.start(true); err != nil {
+			return 0, err
+		}
+	}
+	return len(p), nil
+}
+
+// start writes the real response header and any buffered body. If allowed is true, the
+// response is compressed unless its headers show that it shouldn't be.
+func (cw *compressWriter) start(allowed bool) error {
+	cw.decided = true
+	h := cw.Header()
+
+	if h.Get("Content-Type") == "" && len(cw.buf) > 0 {
+		h.Set("Content-Type", http.DetectContentType(cw.buf))
+	}
+
+	if allowed && h.Get("Content-Encoding") == "" && compressible(h.Get("Content-Type")) {
+		h.Del("Content-Length")
+		h.Set("Content-Encoding", cw.encoding)
+
+		// the compressed body is a different sequence of bytes, so it needs its own ETag
+		if etag := h.Get("ETag"); etag != "" {
+			h.Set("ETag", suffixETag(etag, cw.encoding))
+		}
+
+		cw.enc = encoderPools[cw.encoding].Get().(encoder)
+		cw.enc.Reset(cw.ResponseWriter)
+	}
+
+	cw.ResponseWriter.WriteHeader(cw.status)
+
+	if len(cw.buf) == 0 {
+		return nil
+	}
+
+	var err error
+	if cw.enc != nil {
+		_, err = cw.enc.Write(cw.buf)
+	} else {
+		_, err = cw.ResponseWriter.Write(cw.buf)
+	}
+	cw.buf = nil
+	return err
+}
+
+// Flush sends any buffered data to the client, compressing it if the threshold was
+// already reached, so that streaming handlers keep working behind the middleware
+func (cw *compressWriter) Flush() {
+	if !cw.wroteHeader {
+		cw.WriteHeader(http.StatusOK)
+	}
+	if !cw.decided {
+		cw.start(len(cw.buf) >= cw.minSize)
+	}
+	if cw.enc != nil {
+		cw.enc.Flush()
+	}
+	if f, ok := cw.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Close finishes the response. Bodies which never reached the minimum size are written
+// uncompressed, and the encoder is flushed and returned to its pool.
+func (cw *compressWriter) Close() error {
+	if !cw.wroteHeader {
+		cw.WriteHeader(http.StatusOK)
+	}
+	if !cw.decided {
+		if err := cw.start(false); err != nil {
+			return err
+		}
+	}
+
+	if cw.enc == nil {
+		return nil
+	}
+
+	err := cw.enc.Close()
+	cw.enc.Reset(io.Discard)
+	encoderPools[cw.encoding].Put(cw.enc)
+	cw.enc = nil
+	return err
+}
+
+// Unwrap allows http.ResponseController to reach the underlying ResponseWriter
+func (cw *compressWriter) Unwrap() http.ResponseWriter {
+	return cw.ResponseWriter
+}
+
+// compress negotiates a content coding with the client using the Accept-Encoding header
+// and compresses response bodies which are larger than the configured minimum size
+func (app *application) compress(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// the response varies on Accept-Encoding whether or not it ends up compressed,
+		// so caches must always key on it
+		w.Header().Add("Vary", "Accept-Encoding")
+
+		// preconditions are evaluated against the uncompressed responses, so the coding
+		// suffixes of ETags sent back by the client are removed
+		ifNoneMatch := r.Header.Get("If-None-Match")
+		for _, name := range []string{"If-Match", "If-None-Match"} {
+			if value := r.Header.Get(name); value != "" {
+				r.Header.Set(name, stripEncodingSuffix(value))
+			}
+		}
+
+		encoding := negotiateEncoding(r.Header.Get("Accept-Encoding"))
+		if !app.config.compression.enabled || encoding == "" || r.Method == http.MethodHead {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		cw := &compressWriter{
+			ResponseWriter: w,
+			encoding:       encoding,
+			minSize:        app.config.compression.minSize,
+			ifNoneMatch:    ifNoneMatch,
+		}
+		defer func() {
+			if err := cw.Close(); err != nil {
+				app.logError(r, err)
+			}
+		}()
+
+		next.ServeHTTP(cw, r)
+	})
+}
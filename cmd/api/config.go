@@ -0,0 +1,567 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"github.com/sparkycj328/JobAIO-API/internal/data"
+	"github.com/sparkycj328/JobAIO-API/internal/jsonlog"
+	"github.com/sparkycj328/JobAIO-API/internal/mailer"
+	"github.com/sparkycj328/JobAIO-API/internal/validator"
+	"gopkg.in/yaml.v3"
+)
+
+// envPrefix is prepended to the upper-cased setting name to form its environment variable,
+// for example JOBAIO_SMTP_PASSWORD for the smtp-password setting
+const envPrefix = "JOBAIO_"
+
+// secretSettings lists the settings whose values must never be printed in full
+var secretSettings = map[string]bool{
+	"dsn":           true,
+	"replica-dsn":   true,
+	"smtp-password": true,
+	"admin-token":   true,
+}
+
+// newFlagSet declares every configuration setting as a flag bound to a field of cfg. The
+// flag names double as the keys used in config files and, upper-cased with the JOBAIO_
+// prefix, as environment variable names.
+func newFlagSet(cfg *config) *flag.FlagSet {
+	fs := flag.NewFlagSet("api", flag.ContinueOnError)
+
+	// settings which control how the configuration itself is loaded
+	fs.StringVar(&cfg.file, "config", "", "Path to a YAML or TOML config file")
+	fs.BoolVar(&cfg.printConfig, "print-config", false, "Print the effective configuration with secrets redacted and exit")
+
+	// read flag values into config struct
+	fs.IntVar(&cfg.port, "port", 4000, "API server port")
+	fs.StringVar(&cfg.env, "env", "development", "Environment (development|staging|production)")
+	fs.StringVar(&cfg.storage, "storage", "postgres", "Storage backend (postgres|sqlite|memory)")
+	fs.StringVar(&cfg.db.dsn, "dsn", "", "Database connection")
+	fs.StringVar(&cfg.db.sqliteFile, "sqlite-file", "jobaio.db", "SQLite database file used with -storage=sqlite")
+	// read flag values to configure the database
+	fs.IntVar(&cfg.db.maxOpenConns, "db-max-open-conns", 25, "PostgreSQL max open connections")
+	fs.IntVar(&cfg.db.maxIdleConns, "db-max-idle-conns", 25, "PostgresQL max idle connections")
+	fs.StringVar(&cfg.db.maxIdleTime, "db-max-idle-time", "15m", "PostgreSQL max connection idle time")
+	fs.DurationVar(&cfg.db.queryTimeout, "db-query-timeout", data.DefaultTimeout, "Timeout of each database query")
+	cfg.db.operationTimeouts = make(map[string]time.Duration)
+	fs.Var(durationMapFlag(cfg.db.operationTimeouts), "db-operation-timeout", "Timeout of a single model operation, as Operation=duration such as VendorModel.GetAllRows=10s (repeatable)")
+	// read flag values to configure the optional read replica, which has a pool of its own
+	fs.StringVar(&cfg.db.replica.dsn, "replica-dsn", "", "PostgreSQL read replica connection used by read-only queries (disabled if empty)")
+	fs.IntVar(&cfg.db.replica.maxOpenConns, "replica-max-open-conns", 25, "Read replica max open connections")
+	fs.IntVar(&cfg.db.replica.maxIdleConns, "replica-max-idle-conns", 25, "Read replica max idle connections")
+	fs.StringVar(&cfg.db.replica.maxIdleTime, "replica-max-idle-time", "15m", "Read replica max connection idle time")
+	fs.DurationVar(&cfg.db.replica.maxLag, "replica-max-lag", 10*time.Second, "Replication lag beyond which reads go back to the primary")
+	fs.DurationVar(&cfg.db.replica.checkInterval, "replica-check-interval", 5*time.Second, "How often the read replica's health and lag are checked")
+	// read flag values to configure the monthly partitions of the jobs table
+	fs.IntVar(&cfg.db.partitionAhead, "partition-ahead", 3, "Number of months ahead for which jobs table partitions are created")
+	fs.DurationVar(&cfg.db.partitionCheckInterval, "partition-check-interval", time.Hour, "How often jobs table partitions are created and removed")
+	fs.IntVar(&cfg.db.retentionMonths, "retention-months", 0, "Remove jobs table partitions older than this many months (0 keeps everything)")
+	fs.StringVar(&cfg.db.retentionMode, "retention-mode", "detach", "How old partitions are removed (detach|drop)")
+	// read flag values to configure the response cache of the read endpoints
+	fs.BoolVar(&cfg.cache.enabled, "cache-enabled", true, "Cache the responses of the company read endpoints")
+	fs.IntVar(&cfg.cache.size, "cache-size", 1000, "Maximum number of cached responses")
+	fs.DurationVar(&cfg.cache.ttl, "cache-ttl", 10*time.Second, "How long a cached response is served for")
+	// read flag values to configure the rollups of old snapshots
+	fs.DurationVar(&cfg.rollups.interval, "rollup-interval", time.Hour, "How often complete weeks and months of snapshots are rolled up")
+	fs.IntVar(&cfg.rollups.rawRetentionMonths, "raw-retention-months", 0, "Purge raw snapshots older than this many months once rolled up (0 keeps them)")
+	// read flag values to configure schema migrations
+	fs.StringVar(&cfg.db.migrate, "migrate", "", "Run schema migrations and exit (up|down|down N|to N)")
+	fs.BoolVar(&cfg.db.autoMigrate, "auto-migrate", false, "Apply pending schema migrations on startup")
+	// read flag values to configure the rate limiter
+	fs.Float64Var(&cfg.limiter.rps, "limiter-rps", 2, "Rate limiter maximum requests per second")
+	fs.IntVar(&cfg.limiter.burst, "limiter-burst", 4, "Rate limiter maximum burst")
+	fs.BoolVar(&cfg.limiter.enabled, "limiter-enabled", true, "Enable rate limiter")
+	// read flag values to configure TLS and client certificate authentication
+	fs.StringVar(&cfg.tls.certFile, "tls-cert", "", "TLS certificate file, enables HTTPS and HTTP/2 when set together with -tls-key")
+	fs.StringVar(&cfg.tls.keyFile, "tls-key", "", "TLS private key file")
+	fs.StringVar(&cfg.tls.clientCAFile, "tls-client-ca", "", "CA bundle used to verify client certificates")
+	fs.StringVar(&cfg.tls.clientAuth, "tls-client-auth", "none", "Client certificate authentication (none|optional|require)")
+	cfg.tls.clientIdentities = make(map[string]string)
+	fs.Var(identityFlag(cfg.tls.clientIdentities), "tls-client-identity", "Map a client certificate CN or SAN to a service identity, as subject=identity (repeatable)")
+
+	// read flag values to configure logging and cross-origin requests
+	fs.StringVar(&cfg.logLevel, "log-level", "info", "Minimum log level (info|error|fatal|off)")
+	fs.Var(listFlag{&cfg.cors.trustedOrigins}, "cors-trusted-origins", "Trusted CORS origins (space separated, repeatable)")
+
+	// read flag values to configure the readiness probe
+	fs.BoolVar(&cfg.health.checkMigrations, "readyz-check-migrations", true, "Fail readiness if the schema version is missing or dirty")
+	fs.BoolVar(&cfg.health.checkSMTP, "readyz-check-smtp", false, "Fail readiness if the SMTP server cannot be reached")
+	fs.DurationVar(&cfg.health.timeout, "readyz-timeout", 2*time.Second, "Timeout for the readiness checks")
+
+	// read flag value to select the format of error responses
+	fs.StringVar(&cfg.errors.format, "error-format", "legacy", "Error response format (legacy|problem)")
+
+	// read flag values to configure response compression
+	fs.BoolVar(&cfg.compression.enabled, "compression-enabled", true, "Enable gzip and brotli response compression")
+	fs.IntVar(&cfg.compression.minSize, "compression-min-size", 1024, "Minimum response size in bytes before compression is applied")
+
+	// read flag values to configure the admin listener serving pprof and expvar
+	fs.StringVar(&cfg.admin.addr, "admin-addr", "", "Address of the admin listener, such as localhost:4001 (disabled if empty)")
+	fs.StringVar(&cfg.admin.token, "admin-token", "", "Shared secret required as a bearer token by the admin listener")
+
+	// read flag values to configure graceful shutdown and background tasks
+	fs.DurationVar(&cfg.shutdown.timeout, "shutdown-timeout", 20*time.Second, "Time allowed for in-flight requests and background tasks to finish on shutdown")
+	fs.DurationVar(&cfg.shutdown.drain, "shutdown-drain", 0, "Time between failing the readiness probe and stopping to accept requests on shutdown, so load balancers can take the instance out of rotation")
+	fs.DurationVar(&cfg.shutdown.taskTimeout, "task-timeout", 30*time.Second, "Maximum run time of a single background task, such as sending an email")
+
+	// read flag values to configure OpenTelemetry tracing
+	fs.StringVar(&cfg.otel.endpoint, "otel-endpoint", "", "OTLP/HTTP collector URL traces are exported to, such as http://localhost:4318 (disabled if empty)")
+	fs.Float64Var(&cfg.otel.sampleRatio, "otel-sample-ratio", 1, "Fraction of new traces to sample, between 0 and 1")
+
+	// read flag values to choose how emails are delivered. Outside production they can be
+	// written to a maildir or the log instead of being sent.
+	fs.StringVar(&cfg.mail.transport, "mail-transport", "smtp", "Email transport (smtp|file|log|capture)")
+	fs.StringVar(&cfg.mail.dir, "mail-dir", "tmp/mail", "Maildir the file transport writes .eml files to")
+
+	// Read the SMTP server configuration settings into the config struct, using the
+	// Mailtrap settings as the default values. The credentials have no defaults and
+	// should be supplied through JOBAIO_SMTP_USERNAME and JOBAIO_SMTP_PASSWORD (or
+	// JOBAIO_SMTP_PASSWORD_FILE) so they don't show up in the process list.
+	fs.StringVar(&cfg.smtp.host, "smtp-host", "smtp.mailtrap.io", "SMTP host")
+	fs.IntVar(&cfg.smtp.port, "smtp-port", 2525, "SMTP port")
+	fs.StringVar(&cfg.smtp.username, "smtp-username", "", "SMTP username")
+	fs.StringVar(&cfg.smtp.password, "smtp-password", "", "SMTP password")
+	fs.StringVar(&cfg.smtp.security, "smtp-security", "starttls", "SMTP connection security (plain|starttls|tls)")
+	fs.StringVar(&cfg.smtp.sender, "smtp-sender", "RestrictedJobs <no-reply@restrictedjobs.sparky.net>", "SMTP sender")
+
+	return fs
+}
+
+// loadConfig builds the configuration from, in increasing order of precedence, the flag
+// defaults, a YAML or TOML config file, JOBAIO_* environment variables and the command-line
+// arguments. The merged configuration is validated before it is returned.
+func loadConfig(args []string) (config, *flag.FlagSet, error) {
+	var cfg config
+	fs := newFlagSet(&cfg)
+
+	if err := fs.Parse(args); err != nil {
+		return config{}, nil, err
+	}
+
+	// remember which settings were given on the command line so the other sources
+	// don't override them
+	explicit := make(map[string]bool)
+	fs.Visit(func(f *flag.Flag) {
+		explicit[f.Name] = true
+	})
+
+	if cfg.file == "" {
+		cfg.file = os.Getenv(envPrefix + "CONFIG")
+	}
+	if cfg.file != "" {
+		settings, err := readConfigFile(cfg.file)
+		if err != nil {
+			return config{}, nil, err
+		}
+		if err := applySettings(fs, settings, explicit); err != nil {
+			return config{}, nil, fmt.Errorf("config file %s: %w", cfg.file, err)
+		}
+	}
+
+	if err := applyEnvironment(fs, explicit); err != nil {
+		return config{}, nil, err
+	}
+
+	v := validator.New()
+	if validateConfig(v, cfg); !v.Valid() {
+		return config{}, nil, validationError(v)
+	}
+
+	return cfg, fs, nil
+}
+
+// readConfigFile reads a YAML or TOML config file, chosen by its extension, and flattens it
+// into setting names and values. Nested tables are joined with hyphens, so the YAML
+//
+//	smtp:
+//	  host: smtp.example.com
+//
+// sets smtp-host, exactly like the flag of the same name.
+func readConfigFile(path string) (map[string][]string, error) {
+	contents, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	raw := make(map[string]any)
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(contents, &raw)
+	case ".toml":
+		err = toml.Unmarshal(contents, &raw)
+	default:
+		return nil, fmt.Errorf("config file %s must have a .yaml, .yml or .toml extension", path)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("config file %s: %w", path, err)
+	}
+
+	settings := make(map[string][]string)
+	flattenSettings("", raw, settings)
+	return settings, nil
+}
+
+// tableSettings lists the settings which take key=value pairs, and so may be given as a table
+// in a config file
+var tableSettings = map[string]bool{
+	"tls-client-identity":  true,
+	"db-operation-timeout": true,
+}
+
+// flattenSettings walks a decoded config file, collecting the values for each setting name.
+// Lists become multiple values, and a table whose own name is a setting (such as
+// tls-client-identity) becomes one key=value value per entry.
+func flattenSettings(prefix string, value any, settings map[string][]string) {
+	switch value := value.(type) {
+	case map[string]any:
+		for key, child := range value {
+			name := key
+			if prefix != "" {
+				name = prefix + "-" + key
+			}
+			if pairs, ok := child.(map[string]any); ok && tableSettings[name] {
+				for key, value := range pairs {
+					settings[name] = append(settings[name], fmt.Sprintf("%s=%v", key, value))
+				}
+				continue
+			}
+			flattenSettings(name, child, settings)
+		}
+	case []any:
+		for _, item := range value {
+			settings[prefix] = append(settings[prefix], fmt.Sprint(item))
+		}
+	default:
+		settings[prefix] = append(settings[prefix], fmt.Sprint(value))
+	}
+}
+
+// applySettings sets each named setting on the flag set, skipping any given explicitly on
+// the command line. Unknown names are reported as errors rather than silently ignored.
+func applySettings(fs *flag.FlagSet, settings map[string][]string, explicit map[string]bool) error {
+	names := make([]string, 0, len(settings))
+	for name := range settings {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		if name == "config" || name == "print-config" || fs.Lookup(name) == nil {
+			return fmt.Errorf("unknown setting %q", name)
+		}
+		if explicit[name] {
+			continue
+		}
+
+		// a list given by this source replaces, rather than extends, one given by a
+		// source of lower precedence
+		if list, ok := fs.Lookup(name).Value.(resettable); ok {
+			list.reset()
+		}
+		for _, value := range settings[name] {
+			if err := fs.Set(name, value); err != nil {
+				return fmt.Errorf("invalid value %q for %s: %w", value, name, err)
+			}
+		}
+	}
+	return nil
+}
+
+// envName returns the environment variable which holds the named setting
+func envName(name string) string {
+	return envPrefix + strings.ToUpper(strings.ReplaceAll(name, "-", "_"))
+}
+
+// applyEnvironment reads each setting from its JOBAIO_* environment variable. A variable of
+// the same name with a _FILE suffix instead names a file containing the value, which lets
+// secrets be mounted as files rather than exposed in the environment. Repeatable settings
+// take a comma-separated list.
+func applyEnvironment(fs *flag.FlagSet, explicit map[string]bool) error {
+	settings := make(map[string][]string)
+
+	var err error
+	fs.VisitAll(func(f *flag.Flag) {
+		if err != nil || f.Name == "config" || f.Name == "print-config" {
+			return
+		}
+
+		key := envName(f.Name)
+		value, hasValue := os.LookupEnv(key)
+		file, hasFile := os.LookupEnv(key + "_FILE")
+
+		switch {
+		case hasValue && hasFile:
+			err = fmt.Errorf("only one of %s and %s_FILE may be set", key, key)
+			return
+		case hasFile:
+			contents, readErr := os.ReadFile(file)
+			if readErr != nil {
+				err = fmt.Errorf("%s_FILE: %w", key, readErr)
+				return
+			}
+			value = strings.TrimRight(string(contents), "\r\n")
+		case !hasValue:
+			return
+		}
+
+		if _, repeatable := f.Value.(resettable); repeatable {
+			settings[f.Name] = strings.Split(value, ",")
+		} else {
+			settings[f.Name] = []string{value}
+		}
+	})
+	if err != nil {
+		return err
+	}
+
+	if err := applySettings(fs, settings, explicit); err != nil {
+		return fmt.Errorf("environment: %w", err)
+	}
+	return nil
+}
+
+// resettable is implemented by the values of settings which hold a list, so that a source
+// of higher precedence can clear the values set by a lower one before adding its own
+type resettable interface {
+	reset()
+}
+
+// listFlag implements flag.Value for settings holding a list of strings. Set appends the
+// whitespace-separated values it is given, so the flag may be repeated.
+type listFlag struct {
+	values *[]string
+}
+
+func (f listFlag) String() string {
+	if f.values == nil {
+		return ""
+	}
+	return strings.Join(*f.values, " ")
+}
+
+func (f listFlag) Set(value string) error {
+	*f.values = append(*f.values, strings.Fields(value)...)
+	return nil
+}
+
+func (f listFlag) reset() {
+	*f.values = nil
+}
+
+// durationMapFlag implements flag.Value for repeatable settings of the form key=duration
+type durationMapFlag map[string]time.Duration
+
+func (f durationMapFlag) String() string {
+	pairs := make([]string, 0, len(f))
+	for key, duration := range f {
+		pairs = append(pairs, key+"="+duration.String())
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, ",")
+}
+
+func (f durationMapFlag) Set(value string) error {
+	key, raw, ok := strings.Cut(value, "=")
+	if !ok || key == "" {
+		return errors.New("must be in the format key=duration")
+	}
+	duration, err := time.ParseDuration(raw)
+	if err != nil {
+		return err
+	}
+	f[key] = duration
+	return nil
+}
+
+func (f durationMapFlag) reset() {
+	for key := range f {
+		delete(f, key)
+	}
+}
+
+// validateConfig checks the merged configuration, adding an error to the validator (keyed by
+// setting name) for every invalid value
+func validateConfig(v *validator.Validator, cfg config) {
+	v.Check(cfg.port > 0 && cfg.port <= 65535, "port", "must be between 1 and 65535")
+	v.Check(validator.PermittedValue(cfg.env, "development", "staging", "production"), "env", "must be development, staging or production")
+
+	v.Check(validator.PermittedValue(cfg.storage, "postgres", "sqlite", "memory"), "storage", "must be postgres, sqlite or memory")
+	switch cfg.storage {
+	case "postgres":
+		v.Check(cfg.db.dsn != "", "dsn", "must be provided")
+	case "sqlite":
+		v.Check(cfg.db.sqliteFile != "", "sqlite-file", "must be provided")
+		v.Check(!strings.ContainsAny(cfg.db.sqliteFile, "?#"), "sqlite-file", "must not contain ? or #")
+	case "memory":
+		v.Check(cfg.db.migrate == "", "migrate", "requires postgres or sqlite storage")
+		v.Check(!cfg.db.autoMigrate, "auto-migrate", "requires postgres or sqlite storage")
+	}
+	v.Check(cfg.db.maxOpenConns > 0, "db-max-open-conns", "must be greater than 0")
+	v.Check(cfg.db.maxIdleConns >= 0, "db-max-idle-conns", "must not be negative")
+	v.Check(cfg.db.maxIdleConns <= cfg.db.maxOpenConns, "db-max-idle-conns", "must not be more than db-max-open-conns")
+	_, err := time.ParseDuration(cfg.db.maxIdleTime)
+	v.Check(err == nil, "db-max-idle-time", "must be a duration such as 15m")
+
+	if cfg.db.replica.dsn != "" {
+		v.Check(cfg.storage == "postgres", "replica-dsn", "requires postgres storage")
+		v.Check(cfg.db.replica.maxOpenConns > 0, "replica-max-open-conns", "must be greater than 0")
+		v.Check(cfg.db.replica.maxIdleConns >= 0, "replica-max-idle-conns", "must not be negative")
+		v.Check(cfg.db.replica.maxIdleConns <= cfg.db.replica.maxOpenConns, "replica-max-idle-conns", "must not be more than replica-max-open-conns")
+		_, err := time.ParseDuration(cfg.db.replica.maxIdleTime)
+		v.Check(err == nil, "replica-max-idle-time", "must be a duration such as 15m")
+		v.Check(cfg.db.replica.maxLag > 0, "replica-max-lag", "must be greater than 0")
+		v.Check(cfg.db.replica.checkInterval > 0, "replica-check-interval", "must be greater than 0")
+	}
+
+	v.Check(cfg.db.partitionAhead >= 0, "partition-ahead", "must not be negative")
+	v.Check(cfg.db.partitionCheckInterval > 0, "partition-check-interval", "must be greater than 0")
+	v.Check(cfg.db.retentionMonths >= 0, "retention-months", "must not be negative")
+	v.Check(cfg.db.retentionMonths == 0 || cfg.storage == "postgres", "retention-months", "requires postgres storage")
+	v.Check(validator.PermittedValue(cfg.db.retentionMode, "detach", "drop"), "retention-mode", "must be detach or drop")
+
+	if cfg.cache.enabled {
+		v.Check(cfg.cache.size > 0, "cache-size", "must be greater than 0")
+		v.Check(cfg.cache.ttl > 0, "cache-ttl", "must be greater than 0")
+	}
+	v.Check(cfg.rollups.interval > 0, "rollup-interval", "must be greater than 0")
+	v.Check(cfg.rollups.rawRetentionMonths >= 0, "raw-retention-months", "must not be negative")
+
+	v.Check(cfg.db.queryTimeout > 0, "db-query-timeout", "must be greater than 0")
+	for operation, timeout := range cfg.db.operationTimeouts {
+		v.Check(data.ValidOperation(operation), "db-operation-timeout", fmt.Sprintf("unknown operation %q", operation))
+		v.Check(timeout > 0, "db-operation-timeout", "must be greater than 0")
+	}
+
+	if cfg.db.migrate != "" {
+		_, _, err := parseMigrateCommand(cfg.db.migrate)
+		v.Check(err == nil, "migrate", `must be "up", "down", "down N" or "to N"`)
+	}
+
+	v.Check(cfg.limiter.rps > 0, "limiter-rps", "must be greater than 0")
+	v.Check(cfg.limiter.burst > 0, "limiter-burst", "must be greater than 0")
+
+	v.Check((cfg.tls.certFile == "") == (cfg.tls.keyFile == ""), "tls-cert", "must be provided together with tls-key")
+	v.Check(validator.PermittedValue(cfg.tls.clientAuth, "none", "optional", "require"), "tls-client-auth", "must be none, optional or require")
+	if cfg.tls.clientAuth != "none" {
+		v.Check(cfg.tls.certFile != "", "tls-client-auth", "requires tls-cert and tls-key")
+		v.Check(cfg.tls.clientCAFile != "", "tls-client-ca", "must be provided when client certificates are verified")
+	}
+
+	_, err = jsonlog.ParseLevel(cfg.logLevel)
+	v.Check(err == nil, "log-level", "must be info, error, fatal or off")
+	for _, origin := range cfg.cors.trustedOrigins {
+		u, err := url.Parse(origin)
+		v.Check(err == nil && u.Scheme != "" && u.Host != "" && u.Path == "", "cors-trusted-origins", "must only contain origins such as https://example.com")
+	}
+
+	v.Check(cfg.health.timeout > 0, "readyz-timeout", "must be greater than 0")
+
+	v.Check(validator.PermittedValue(cfg.errors.format, "legacy", "problem"), "error-format", "must be legacy or problem")
+	v.Check(cfg.compression.minSize >= 0, "compression-min-size", "must not be negative")
+
+	if cfg.admin.addr != "" {
+		_, _, err := net.SplitHostPort(cfg.admin.addr)
+		v.Check(err == nil, "admin-addr", "must be a host and port such as localhost:4001")
+		v.Check(len(cfg.admin.token) >= 16, "admin-token", "must be at least 16 bytes long when admin-addr is set")
+	}
+
+	v.Check(cfg.shutdown.timeout > 0, "shutdown-timeout", "must be greater than 0")
+	v.Check(cfg.shutdown.drain >= 0, "shutdown-drain", "must not be negative")
+	v.Check(cfg.shutdown.taskTimeout > 0, "task-timeout", "must be greater than 0")
+
+	if cfg.otel.endpoint != "" {
+		u, err := url.Parse(cfg.otel.endpoint)
+		v.Check(err == nil && (u.Scheme == "http" || u.Scheme == "https") && u.Host != "", "otel-endpoint", "must be an http or https URL such as http://localhost:4318")
+	}
+	v.Check(cfg.otel.sampleRatio >= 0 && cfg.otel.sampleRatio <= 1, "otel-sample-ratio", "must be between 0 and 1")
+
+	v.Check(validator.PermittedValue(cfg.mail.transport, "smtp", "file", "log", "capture"), "mail-transport", "must be smtp, file, log or capture")
+	if cfg.mail.transport == "file" {
+		v.Check(cfg.mail.dir != "", "mail-dir", "must be provided when mail-transport is file")
+	}
+	if cfg.mail.transport == "smtp" {
+		v.Check(cfg.smtp.host != "", "smtp-host", "must be provided")
+		v.Check(cfg.smtp.port > 0 && cfg.smtp.port <= 65535, "smtp-port", "must be between 1 and 65535")
+		v.Check(validator.PermittedValue(cfg.smtp.security, mailer.SecurityPlain, mailer.SecuritySTARTTLS, mailer.SecurityTLS), "smtp-security", "must be plain, starttls or tls")
+	}
+	v.Check(cfg.smtp.sender != "", "smtp-sender", "must be provided")
+}
+
+// validationError combines the errors held by the validator into a single error, sorted by
+// setting name so the output is stable
+func validationError(v *validator.Validator) error {
+	keys := make([]string, 0, len(v.Errors))
+	for key := range v.Errors {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	messages := make([]string, len(keys))
+	for i, key := range keys {
+		messages[i] = fmt.Sprintf("%s %s", key, v.Errors[key])
+	}
+	return errors.New("invalid configuration: " + strings.Join(messages, "; "))
+}
+
+// printConfig writes the effective configuration to w as YAML, in the same flat format
+// accepted by -config, with the values of secret settings redacted
+func printConfig(w io.Writer, fs *flag.FlagSet) error {
+	settings := make(map[string]any)
+
+	fs.VisitAll(func(f *flag.Flag) {
+		if f.Name == "config" || f.Name == "print-config" {
+			return
+		}
+
+		var value any = f.Value.String()
+		if getter, ok := f.Value.(flag.Getter); ok {
+			value = getter.Get()
+		}
+		switch flagValue := f.Value.(type) {
+		case identityFlag:
+			value = map[string]string(flagValue)
+		case durationMapFlag:
+			timeouts := make(map[string]string, len(flagValue))
+			for operation, timeout := range flagValue {
+				timeouts[operation] = timeout.String()
+			}
+			value = timeouts
+		case listFlag:
+			value = *flagValue.values
+		}
+
+		if secretSettings[f.Name] {
+			value = redact(f.Value.String())
+		}
+		settings[f.Name] = value
+	})
+
+	enc := yaml.NewEncoder(w)
+	defer enc.Close()
+	return enc.Encode(settings)
+}
+
+// redact hides a secret value. Connection URLs keep everything except their password so
+// that the printed DSN is still useful for troubleshooting.
+func redact(value string) string {
+	if value == "" {
+		return ""
+	}
+	if u, err := url.Parse(value); err == nil && u.User != nil {
+		if _, hasPassword := u.User.Password(); hasPassword {
+			return u.Redacted()
+		}
+	}
+	return "[REDACTED]"
+}
@@ -0,0 +1,28 @@
+package main
+
+import (
+	"context"
+	"net/http"
+)
+
+// contextKey is a custom type for the keys our application stores in request contexts,
+// which avoids collisions with keys set by other packages
+type contextKey string
+
+// clientIdentityContextKey is the key under which the service identity of a client
+// authenticated by its TLS certificate is stored
+const clientIdentityContextKey = contextKey("clientIdentity")
+
+// contextSetClientIdentity returns a copy of the request with the client's service identity
+// added to its context
+func (app *application) contextSetClientIdentity(r *http.Request, identity string) *http.Request {
+	ctx := context.WithValue(r.Context(), clientIdentityContextKey, identity)
+	return r.WithContext(ctx)
+}
+
+// contextGetClientIdentity retrieves the client's service identity from the request context,
+// returning an empty string if the client did not authenticate with a certificate
+func (app *application) contextGetClientIdentity(r *http.Request) string {
+	identity, _ := r.Context().Value(clientIdentityContextKey).(string)
+	return identity
+}
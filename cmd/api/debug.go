@@ -0,0 +1,167 @@
+package main
+
+import (
+	"crypto/subtle"
+	"expvar"
+	"net/http"
+	"net/http/pprof"
+	"runtime"
+	"runtime/debug"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Counters published through expvar. They are package-level because expvar names are global
+// and may only be registered once per process.
+var (
+	totalRequestsReceived      = expvar.NewInt("total_requests_received")
+	totalResponsesSent         = expvar.NewInt("total_responses_sent")
+	totalProcessingTimeMicros  = expvar.NewInt("total_processing_time_μs")
+	totalResponsesSentByStatus = expvar.NewMap("total_responses_sent_by_status")
+)
+
+// publishMetrics registers the application values reported on /debug/vars. It must only be
+// called once, after the storage has been set up.
+func (app *application) publishMetrics() {
+	started := time.Now()
+
+	expvar.NewString("version").Set(version)
+	expvar.Publish("uptime_seconds", expvar.Func(func() any {
+		return int64(time.Since(started).Seconds())
+	}))
+	expvar.Publish("goroutines", expvar.Func(func() any {
+		return runtime.NumGoroutine()
+	}))
+	expvar.Publish("database", expvar.Func(func() any {
+		if app.db == nil {
+			return nil
+		}
+		return app.db.Stats()
+	}))
+	expvar.Publish("replica_database", expvar.Func(func() any {
+		if app.replica == nil {
+			return nil
+		}
+		return app.replica.DB.Stats()
+	}))
+	expvar.Publish("background_tasks", expvar.Func(func() any {
+		return app.tasks.Stats()
+	}))
+	expvar.Publish("response_cache", expvar.Func(func() any {
+		if app.cache == nil {
+			return nil
+		}
+		return app.cache.stats()
+	}))
+}
+
+// metricsResponseWriter records the status code written through it
+type metricsResponseWriter struct {
+	http.ResponseWriter
+	statusCode    int
+	headerWritten bool
+}
+
+func (mw *metricsResponseWriter) WriteHeader(statusCode int) {
+	if !mw.headerWritten {
+		mw.statusCode = statusCode
+		mw.headerWritten = true
+	}
+	mw.ResponseWriter.WriteHeader(statusCode)
+}
+
+func (mw *metricsResponseWriter) Write(b []byte) (int, error) {
+	mw.headerWritten = true
+	return mw.ResponseWriter.Write(b)
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer, for example to flush
+func (mw *metricsResponseWriter) Unwrap() http.ResponseWriter {
+	return mw.ResponseWriter
+}
+
+// metrics counts the requests received and responses sent, by status code, along with the
+// total time spent processing them
+func (app *application) metrics(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		totalRequestsReceived.Add(1)
+
+		mw := &metricsResponseWriter{ResponseWriter: w, statusCode: http.StatusOK}
+		next.ServeHTTP(mw, r)
+
+		totalResponsesSent.Add(1)
+		totalResponsesSentByStatus.Add(strconv.Itoa(mw.statusCode), 1)
+		totalProcessingTimeMicros.Add(time.Since(start).Microseconds())
+	})
+}
+
+// isAdmin reports whether the request carries the configured admin token as a bearer
+// token. The comparison takes constant time so the token can't be guessed byte by byte from
+// response times. Nobody is an admin when no token is configured.
+func (app *application) isAdmin(r *http.Request) bool {
+	scheme, token, ok := strings.Cut(r.Header.Get("Authorization"), " ")
+	return ok && strings.EqualFold(scheme, "Bearer") && app.config.admin.token != "" &&
+		subtle.ConstantTimeCompare([]byte(token), []byte(app.config.admin.token)) == 1
+}
+
+// requireAdminToken only lets through requests carrying the admin token
+func (app *application) requireAdminToken(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !app.isAdmin(r) {
+			app.invalidCredentialsResponse(w, r)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// buildInfoHandler reports how the binary was built: the Go version, module versions and
+// VCS settings embedded by the toolchain
+func (app *application) buildInfoHandler(w http.ResponseWriter, r *http.Request) {
+	info, ok := debug.ReadBuildInfo()
+	if !ok {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	settings := make(map[string]string, len(info.Settings))
+	for _, s := range info.Settings {
+		settings[s.Key] = s.Value
+	}
+
+	dependencies := make(map[string]string, len(info.Deps))
+	for _, dep := range info.Deps {
+		dependencies[dep.Path] = dep.Version
+	}
+
+	env := envelope{
+		"version":      version,
+		"go_version":   info.GoVersion,
+		"path":         info.Path,
+		"settings":     settings,
+		"dependencies": dependencies,
+	}
+	if err := app.writeJSON(w, http.StatusOK, env, nil); err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// adminRoutes returns the handler for the admin listener. It is deliberately separate from
+// routes() so that profiling and internal metrics are never reachable through the public
+// listener, and every endpoint requires the admin token.
+func (app *application) adminRoutes() http.Handler {
+	mux := http.NewServeMux()
+
+	mux.HandleFunc("/debug/pprof/", pprof.Index)
+	mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+	mux.Handle("/debug/vars", expvar.Handler())
+	mux.HandleFunc("/debug/build", app.buildInfoHandler)
+
+	return app.recoverPanic(app.requireAdminToken(mux))
+}
@@ -1,75 +1,281 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"mime"
 	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/sparkycj328/JobAIO-API/internal/data"
 )
 
 // logError is a generic helper for logging error messages
 func (app *application) logError(r *http.Request, err error) {
-	app.logger.PrintError(err, map[string]string{
+	properties := map[string]string{
 		"request_method": r.Method,
 		"request_url":    r.URL.String(),
-	})
+	}
+	if identity := app.contextGetClientIdentity(r); identity != "" {
+		properties["client_identity"] = identity
+	}
+
+	app.logger.PrintErrorContext(r.Context(), err, properties)
+}
+
+// Problem type URIs identify each kind of error in application/problem+json responses
+// (RFC 9457). Clients may rely on them, so once published they must never change.
+const (
+	problemBaseURI              = "https://restrictedjobs.sparky.net/problems/"
+	problemServerError          = problemBaseURI + "server-error"
+	problemNotFound             = problemBaseURI + "not-found"
+	problemMethodNotAllowed     = problemBaseURI + "method-not-allowed"
+	problemBadRequest           = problemBaseURI + "bad-request"
+	problemFailedValidation     = problemBaseURI + "failed-validation"
+	problemEditConflict         = problemBaseURI + "edit-conflict"
+	problemRateLimitExceeded    = problemBaseURI + "rate-limit-exceeded"
+	problemPreconditionFailed   = problemBaseURI + "precondition-failed"
+	problemPreconditionRequired = problemBaseURI + "precondition-required"
+	problemNotAcceptable        = problemBaseURI + "not-acceptable"
+	problemUnsupportedMediaType = problemBaseURI + "unsupported-media-type"
+	problemPatchConflict        = problemBaseURI + "patch-conflict"
+	problemUnknownClientCert    = problemBaseURI + "unknown-client-certificate"
+	problemInvalidCredentials   = problemBaseURI + "invalid-credentials"
+	problemTimeout              = problemBaseURI + "timeout"
+	problemServiceUnavailable   = problemBaseURI + "service-unavailable"
+)
+
+// problemMediaType is the content type of RFC 9457 problem details responses
+const problemMediaType = "application/problem+json"
+
+// problemError describes a single failed validation check within a problem
+type problemError struct {
+	Field  string `json:"field"`
+	Detail string `json:"detail"`
 }
 
-// errorResponse will send JSON-formatted error messages to the client with a given status code
-func (app *application) errorResponse(w http.ResponseWriter, r *http.Request, status int, message any) {
+// wantsProblem reports whether the error response for r should use problem details, which
+// is the case when the server is configured for it or the client asks for it by listing
+// application/problem+json in its Accept header
+func (app *application) wantsProblem(r *http.Request) bool {
+	if app.config.errors.format == "problem" {
+		return true
+	}
+
+	for _, part := range strings.Split(r.Header.Get("Accept"), ",") {
+		mediaType, params, err := mime.ParseMediaType(strings.TrimSpace(part))
+		if err != nil || mediaType != problemMediaType {
+			continue
+		}
+		if q, err := strconv.ParseFloat(params["q"], 64); err == nil && q == 0 {
+			continue
+		}
+		return true
+	}
+	return false
+}
+
+// errorResponse will send JSON-formatted error messages to the client with a given status code.
+// The message is either a string or the errors map of a validator.Validator. By default it is
+// wrapped in an {"error": ...} envelope; problem details clients instead receive an
+// application/problem+json body identified by problemType.
+func (app *application) errorResponse(w http.ResponseWriter, r *http.Request, status int, problemType string, message any) {
 	env := envelope{"error": message}
+	var headers http.Header
+
+	if app.wantsProblem(r) {
+		env = envelope{
+			"type":     problemType,
+			"title":    http.StatusText(status),
+			"status":   status,
+			"instance": r.URL.Path,
+		}
+
+		switch message := message.(type) {
+		case string:
+			env["detail"] = message
+		case map[string]string:
+			// flatten the validator's errors map into an array sorted by field name
+			problemErrors := make([]problemError, 0, len(message))
+			for field, detail := range message {
+				problemErrors = append(problemErrors, problemError{Field: field, Detail: detail})
+			}
+			sort.Slice(problemErrors, func(i, j int) bool {
+				return problemErrors[i].Field < problemErrors[j].Field
+			})
+
+			env["detail"] = "one or more fields failed validation"
+			env["errors"] = problemErrors
+		}
+
+		headers = make(http.Header)
+		headers.Set("Content-Type", problemMediaType)
+	}
 
 	// writes the response using the writeJSON helper, if an error occurs
 	// returns an empty response with 500 internal server error
-	err := app.writeJSON(w, status, env, nil)
+	err := app.writeJSON(w, status, env, headers)
 	if err != nil {
 		app.logError(r, err)
 		w.WriteHeader(500)
 	}
 }
 
+// statusClientClosedRequest is the non-standard status, popularised by nginx, recorded for
+// requests abandoned by the client before a response could be written
+const statusClientClosedRequest = 499
+
 // The serverErrorResponse() method will be used when our application encounters an
 // unexpected problem at runtime. It logs the detailed error message, then uses the
 // errorResponse() helper to send a 500 Internal Server Error status code and JSON
-// response (containing a generic error message) to the client.
+// response (containing a generic error message) to the client. Errors caused by the client
+// going away, a query timing out or the database being unreachable are answered more
+// specifically instead.
 func (app *application) serverErrorResponse(w http.ResponseWriter, r *http.Request, err error) {
+	switch {
+	case r.Context().Err() != nil && (errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded)):
+		app.clientClosedRequest(w, r, err)
+		return
+	case errors.Is(err, context.DeadlineExceeded):
+		app.timeoutResponse(w, r, err)
+		return
+	case errors.Is(err, data.ErrUnavailable):
+		app.serviceUnavailableResponse(w, r, err)
+		return
+	}
+
 	app.logError(r, err)
 
 	message := "the server encountered a problem and could not process your request"
-	app.errorResponse(w, r, http.StatusInternalServerError, message)
+	app.errorResponse(w, r, http.StatusInternalServerError, problemServerError, message)
+}
+
+// clientClosedRequest handles a request whose client disconnected before its response was
+// ready, which cancelled the request context. It is not a server fault, so it is logged at
+// the info level, and the 499 status only serves the access metrics and traces since
+// nobody is left to read it.
+func (app *application) clientClosedRequest(w http.ResponseWriter, r *http.Request, err error) {
+	app.logger.PrintInfoContext(r.Context(), "client closed request", map[string]string{
+		"request_method": r.Method,
+		"request_url":    r.URL.String(),
+		"status":         strconv.Itoa(statusClientClosedRequest),
+		"error":          err.Error(),
+	})
+	w.WriteHeader(statusClientClosedRequest)
+}
+
+// timeoutResponse will be sent when a database query ran for longer than its configured
+// timeout
+func (app *application) timeoutResponse(w http.ResponseWriter, r *http.Request, err error) {
+	app.logError(r, err)
+
+	message := "the server did not complete your request in time, please try again later"
+	app.errorResponse(w, r, http.StatusGatewayTimeout, problemTimeout, message)
+}
+
+// serviceUnavailableResponse will be sent when the database can't be reached. Retry-After
+// suggests when the client might try again.
+func (app *application) serviceUnavailableResponse(w http.ResponseWriter, r *http.Request, err error) {
+	app.logError(r, err)
+
+	w.Header().Set("Retry-After", "5")
+	message := "the service is temporarily unavailable, please try again later"
+	app.errorResponse(w, r, http.StatusServiceUnavailable, problemServiceUnavailable, message)
 }
 
 // The notFoundResponse() method will be used to send a 404 Not Found status code and
 // JSON response to the client.
 func (app *application) notFoundResponse(w http.ResponseWriter, r *http.Request) {
 	message := "the requested resource could not be found"
-	app.errorResponse(w, r, http.StatusNotFound, message)
+	app.errorResponse(w, r, http.StatusNotFound, problemNotFound, message)
 }
 
 // The methodNotAllowedResponse() method will be used to send a 405 Method Not Allowed
 // status code and JSON response to the client.
 func (app *application) methodNotAllowedResponse(w http.ResponseWriter, r *http.Request) {
 	message := fmt.Sprintf("the %s method is not supported for this resource", r.Method)
-	app.errorResponse(w, r, http.StatusMethodNotAllowed, message)
+	app.errorResponse(w, r, http.StatusMethodNotAllowed, problemMethodNotAllowed, message)
 }
 
 // badRequestResponse will call our errorResponse and provide it the appropriate error
 func (app *application) badRequestResponse(w http.ResponseWriter, r *http.Request, err error) {
-	app.errorResponse(w, r, http.StatusBadRequest, err.Error())
+	app.errorResponse(w, r, http.StatusBadRequest, problemBadRequest, err.Error())
 }
 
 // Note that the errors parameter here has the type map[string]string, which is exactly
 // the same as the errors map contained in our Validator type.
 func (app *application) failedValidationResponse(w http.ResponseWriter, r *http.Request, errors map[string]string) {
-	app.errorResponse(w, r, http.StatusUnprocessableEntity, errors)
+	app.errorResponse(w, r, http.StatusUnprocessableEntity, problemFailedValidation, errors)
 }
 
 // editConflictResponse will call our errorResponse and provide it the appropriate error
 func (app *application) editConflictResponse(w http.ResponseWriter, r *http.Request) {
 	message := "unable to update the record due to an edit conflict, please try again"
-	app.errorResponse(w, r, http.StatusConflict, message)
+	app.errorResponse(w, r, http.StatusConflict, problemEditConflict, message)
 }
 
 // rateLimitExceeded will send a message if a client IP has been rate limited
 func (app *application) rateLimitExceeded(w http.ResponseWriter, r *http.Request) {
 	message := "rate limit exceeded"
-	app.errorResponse(w, r, http.StatusTooManyRequests, message)
+	app.errorResponse(w, r, http.StatusTooManyRequests, problemRateLimitExceeded, message)
+}
+
+// preconditionFailedResponse will be sent when the If-Match header of a write request no
+// longer matches the current version of the record
+func (app *application) preconditionFailedResponse(w http.ResponseWriter, r *http.Request) {
+	message := "the record has been modified since it was last fetched, please fetch it again and retry"
+	app.errorResponse(w, r, http.StatusPreconditionFailed, problemPreconditionFailed, message)
+}
+
+// preconditionRequiredResponse will be sent when a write request is missing the If-Match header
+func (app *application) preconditionRequiredResponse(w http.ResponseWriter, r *http.Request) {
+	message := "this request must include an If-Match header containing the record's ETag"
+	app.errorResponse(w, r, http.StatusPreconditionRequired, problemPreconditionRequired, message)
+}
+
+// notAcceptableResponse will be sent when none of the response formats the client accepts
+// can be produced
+func (app *application) notAcceptableResponse(w http.ResponseWriter, r *http.Request) {
+	supported := make([]string, 0, len(formats))
+	for _, f := range formats {
+		supported = append(supported, f.mediaTypes[0])
+	}
+
+	message := fmt.Sprintf("the requested format is not supported, supported formats are: %s", strings.Join(supported, ", "))
+	app.errorResponse(w, r, http.StatusNotAcceptable, problemNotAcceptable, message)
+}
+
+// unsupportedMediaTypeResponse will be sent when a PATCH request body is not one of the
+// supported patch formats. The Accept-Patch header tells the client which ones are.
+func (app *application) unsupportedMediaTypeResponse(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Accept-Patch", mergePatchMediaType+", "+jsonPatchMediaType)
+
+	message := fmt.Sprintf("the %q content type is not supported for this resource", r.Header.Get("Content-Type"))
+	app.errorResponse(w, r, http.StatusUnsupportedMediaType, problemUnsupportedMediaType, message)
+}
+
+// patchConflictResponse will be sent when a well-formed patch document cannot be applied to
+// the current state of the record, for example when a JSON Patch test operation fails
+func (app *application) patchConflictResponse(w http.ResponseWriter, r *http.Request, err error) {
+	message := fmt.Sprintf("unable to apply the patch to the record: %s", err.Error())
+	app.errorResponse(w, r, http.StatusConflict, problemPatchConflict, message)
+}
+
+// unknownClientCertificateResponse will be sent when a client presents a valid TLS certificate
+// which is not mapped to any service identity
+func (app *application) unknownClientCertificateResponse(w http.ResponseWriter, r *http.Request) {
+	message := "your client certificate is not authorized to access this resource"
+	app.errorResponse(w, r, http.StatusForbidden, problemUnknownClientCert, message)
+}
+
+// invalidCredentialsResponse will be sent when a request to the admin listener is missing
+// the admin token or presents the wrong one
+func (app *application) invalidCredentialsResponse(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("WWW-Authenticate", "Bearer")
+
+	message := "a valid admin token is required to access this resource"
+	app.errorResponse(w, r, http.StatusUnauthorized, problemInvalidCredentials, message)
 }
@@ -1,18 +1,42 @@
 package main
 
 import (
+	"context"
+	"fmt"
 	"net/http"
+	"strconv"
+	"sync"
+	"time"
 )
 
 // healthcheckHandler writes plain-text response with information about the
 // application status, operating environment and version.
 func (app *application) healthcheckHandler(w http.ResponseWriter, r *http.Request) {
+	systemInformation := map[string]string{
+		"environment": app.config.env,
+		"version":     version,
+	}
+
+	// the schema version is informational only, so leave it out if it can't be read
+	if app.migrator != nil {
+		ctx, cancel := context.WithTimeout(r.Context(), time.Second)
+		defer cancel()
+		if current, _, err := app.migrator.Version(ctx); err == nil {
+			systemInformation["schema_version"] = strconv.FormatInt(current, 10)
+		}
+	}
+
+	// reads are served by the primary whatever the state of the replica, so it doesn't
+	// change the status
+	if app.replica != nil {
+		health := app.replicaHealth()
+		systemInformation["replica"] = health["state"]
+		systemInformation["replica_lag"] = health["lag"]
+	}
+
 	env := envelope{
-		"status": "available",
-		"systemInformation": map[string]string{
-			"environment": app.config.env,
-			"version":     version,
-		},
+		"status":            "available",
+		"systemInformation": systemInformation,
 	}
 
 	if err := app.writeJSON(w, http.StatusOK, env, nil); err != nil {
@@ -20,3 +44,132 @@ func (app *application) healthcheckHandler(w http.ResponseWriter, r *http.Reques
 	}
 
 }
+
+// livenessHandler reports that the process is running and able to serve requests. It does
+// not look at any dependencies, so an orchestrator will only restart the process if the
+// process itself is stuck.
+func (app *application) livenessHandler(w http.ResponseWriter, r *http.Request) {
+	if err := app.writeJSON(w, http.StatusOK, envelope{"status": "alive"}, nil); err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// checkResult holds the outcome of a single readiness check
+type checkResult struct {
+	Status  string `json:"status"`
+	Latency string `json:"latency"`
+	Error   string `json:"error,omitempty"`
+	Detail  any    `json:"detail,omitempty"`
+}
+
+// readinessCheck is a named dependency check. The check returns optional detail to report
+// alongside its status, or an error if the dependency is unavailable.
+type readinessCheck struct {
+	name  string
+	check func(ctx context.Context) (any, error)
+}
+
+// readinessChecks returns the dependency checks enabled in the configuration. In-memory
+// storage has no database to check.
+func (app *application) readinessChecks() []readinessCheck {
+	var checks []readinessCheck
+
+	if app.db != nil {
+		checks = append(checks, readinessCheck{name: "database", check: func(ctx context.Context) (any, error) {
+			return nil, app.db.PingContext(ctx)
+		}})
+	}
+
+	if app.migrator != nil && app.config.health.checkMigrations {
+		checks = append(checks, readinessCheck{name: "migrations", check: func(ctx context.Context) (any, error) {
+			current, dirty, err := app.migrator.Version(ctx)
+			if err != nil {
+				return nil, err
+			}
+
+			detail := map[string]int64{"current": current, "latest": app.migrator.Latest()}
+			switch {
+			case dirty:
+				return detail, fmt.Errorf("schema version %d is dirty", current)
+			case current < app.migrator.Latest():
+				return detail, fmt.Errorf("schema version %d is behind version %d", current, app.migrator.Latest())
+			}
+			return detail, nil
+		}})
+	}
+
+	// an unhealthy replica is reported without failing readiness, since reads fall back to
+	// the primary
+	if app.replica != nil {
+		checks = append(checks, readinessCheck{name: "replica", check: func(ctx context.Context) (any, error) {
+			return app.replicaHealth(), nil
+		}})
+	}
+
+	if app.config.health.checkSMTP {
+		checks = append(checks, readinessCheck{name: "smtp", check: func(ctx context.Context) (any, error) {
+			return nil, app.mailer.Ping(ctx)
+		}})
+	}
+
+	return checks
+}
+
+// readinessHandler reports whether the application is ready to receive traffic. It runs
+// every enabled dependency check concurrently, reporting the status and latency of each,
+// and responds with 503 Service Unavailable if any check fails or the server has begun
+// shutting down.
+func (app *application) readinessHandler(w http.ResponseWriter, r *http.Request) {
+	if app.shuttingDown.Load() {
+		env := envelope{"status": "shutting down"}
+		if err := app.writeJSON(w, http.StatusServiceUnavailable, env, nil); err != nil {
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), app.config.health.timeout)
+	defer cancel()
+
+	checks := app.readinessChecks()
+	results := make(map[string]checkResult, len(checks))
+
+	var (
+		mu sync.Mutex
+		wg sync.WaitGroup
+	)
+	for _, c := range checks {
+		wg.Add(1)
+		go func(c readinessCheck) {
+			defer wg.Done()
+
+			start := time.Now()
+			detail, err := c.check(ctx)
+			result := checkResult{
+				Status:  "ok",
+				Latency: time.Since(start).String(),
+				Detail:  detail,
+			}
+			if err != nil {
+				result.Status = "failed"
+				result.Error = err.Error()
+			}
+
+			mu.Lock()
+			results[c.name] = result
+			mu.Unlock()
+		}(c)
+	}
+	wg.Wait()
+
+	status, code := "ready", http.StatusOK
+	for _, result := range results {
+		if result.Status != "ok" {
+			status, code = "not ready", http.StatusServiceUnavailable
+		}
+	}
+
+	if err := app.writeJSON(w, code, envelope{"status": status, "checks": results}, nil); err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"errors"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/sparkycj328/JobAIO-API/internal/mailer"
+)
+
+func TestHealthcheck(t *testing.T) {
+	app := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+
+	res := ts.get(t, "/v1/healthcheck")
+	if res.status != http.StatusOK {
+		t.Fatalf("got status %d, want %d: %s", res.status, http.StatusOK, res.body)
+	}
+
+	var env struct {
+		Status            string            `json:"status"`
+		SystemInformation map[string]string `json:"systemInformation"`
+	}
+	res.decode(t, &env)
+	if env.Status != "available" || env.SystemInformation["version"] != version || env.SystemInformation["environment"] != "development" {
+		t.Errorf("got %+v, want the status, version and environment", env)
+	}
+}
+
+func TestLiveness(t *testing.T) {
+	app := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+
+	// the process is alive even while it shuts down
+	app.shuttingDown.Store(true)
+
+	if res := ts.get(t, "/livez"); res.status != http.StatusOK {
+		t.Fatalf("got status %d, want %d: %s", res.status, http.StatusOK, res.body)
+	}
+}
+
+func TestReadiness(t *testing.T) {
+	tests := []struct {
+		name         string
+		checkSMTP    bool
+		pingErr      error
+		shuttingDown bool
+		wantStatus   int
+		wantFailed   string // name of the check expected to fail
+	}{
+		{name: "ready", wantStatus: http.StatusOK},
+		{name: "smtp reachable", checkSMTP: true, wantStatus: http.StatusOK},
+		{name: "smtp unreachable", checkSMTP: true, pingErr: errors.New("connection refused"), wantStatus: http.StatusServiceUnavailable, wantFailed: "smtp"},
+		{name: "smtp not checked", pingErr: errors.New("connection refused"), wantStatus: http.StatusOK},
+		{name: "shutting down", shuttingDown: true, wantStatus: http.StatusServiceUnavailable},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app := newTestApplication(t)
+			app.config.health.checkSMTP = tt.checkSMTP
+			if tt.pingErr != nil {
+				app.mailer = mailer.New(unreachableTransport{err: tt.pingErr}, app.config.smtp.sender)
+			}
+			app.shuttingDown.Store(tt.shuttingDown)
+			ts := newTestServer(t, app.routes())
+
+			res := ts.get(t, "/readyz")
+			if res.status != tt.wantStatus {
+				t.Fatalf("got status %d, want %d: %s", res.status, tt.wantStatus, res.body)
+			}
+
+			var env struct {
+				Checks map[string]checkResult `json:"checks"`
+			}
+			res.decode(t, &env)
+			for name, result := range env.Checks {
+				if failed := result.Status != "ok"; failed != (name == tt.wantFailed) {
+					t.Errorf("got check %s %+v, want failed to be %t", name, result, name == tt.wantFailed)
+				}
+			}
+		})
+	}
+}
+
+func TestReadinessSlowSMTP(t *testing.T) {
+	// a server which accepts connections but never sends its greeting
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	go func() {
+		var conns []net.Conn
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				for _, conn := range conns {
+					conn.Close()
+				}
+				return
+			}
+			conns = append(conns, conn)
+		}
+	}()
+
+	transport, err := mailer.NewSMTP(mailer.SMTPConfig{
+		Host:     "127.0.0.1",
+		Port:     ln.Addr().(*net.TCPAddr).Port,
+		Security: mailer.SecurityPlain,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	app := newTestApplication(t)
+	app.config.health.checkSMTP = true
+	app.config.health.timeout = 100 * time.Millisecond
+	app.mailer = mailer.New(transport, app.config.smtp.sender)
+	ts := newTestServer(t, app.routes())
+
+	// the check gives up at the health timeout instead of the dialer's own timeout
+	start := time.Now()
+	res := ts.get(t, "/readyz")
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("got a response after %s, want one within the health timeout", elapsed)
+	}
+	if res.status != http.StatusServiceUnavailable {
+		t.Fatalf("got status %d, want %d: %s", res.status, http.StatusServiceUnavailable, res.body)
+	}
+
+	var env struct {
+		Checks map[string]checkResult `json:"checks"`
+	}
+	res.decode(t, &env)
+	if got := env.Checks["smtp"]; got.Status != "failed" {
+		t.Errorf("got smtp check %+v, want it failed", got)
+	}
+}
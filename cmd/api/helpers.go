@@ -1,10 +1,13 @@
 package main
 
 import (
+	"context"
+	"crypto/sha256"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"github.com/julienschmidt/httprouter"
+	"github.com/sparkycj328/JobAIO-API/internal/data"
 	"github.com/sparkycj328/JobAIO-API/internal/validator"
 	"io"
 	"net/http"
@@ -13,6 +16,8 @@ import (
 	"strconv"
 	"strings"
 	"time"
+
+	"go.opentelemetry.io/otel/codes"
 )
 
 // alphaNumeric will check the URL query parameter to ensure only alphanumeric characters are present
@@ -51,21 +56,21 @@ type envelope map[string]any
 // writeJSON is a helper function which will iterate through the data passed and convert it into
 // a JSON object to return.
 func (app *application) writeJSON(w http.ResponseWriter, status int, data envelope, headers http.Header) error {
-	js, err := json.MarshalIndent(data, "", "\t")
+	js, err := marshalJSON(data)
 	if err != nil {
 		return err
 	}
 
-	// append a newline to make the JSON more viewer-friendly
-	js = append(js, '\n')
-
 	// iterate through map and write the Header for each key-value pair
 	for key, value := range headers {
 		w.Header()[key] = value
 	}
 
-	// sets a response header so client knows response contains json
-	w.Header().Set("Content-Type", "application/json")
+	// sets a response header so client knows response contains json, unless a more
+	// specific JSON media type was passed in the headers
+	if w.Header().Get("Content-Type") == "" {
+		w.Header().Set("Content-Type", "application/json")
+	}
 
 	// write the status code for the response
 	w.WriteHeader(status)
@@ -75,9 +80,86 @@ func (app *application) writeJSON(w http.ResponseWriter, status int, data envelo
 	return nil
 }
 
+// marshalJSON encodes the envelope as indented JSON followed by a newline
+func marshalJSON(data envelope) ([]byte, error) {
+	js, err := json.MarshalIndent(data, "", "\t")
+	if err != nil {
+		return nil, err
+	}
+
+	// append a newline to make the JSON more viewer-friendly
+	return append(js, '\n'), nil
+}
+
+// recordETag returns the strong ETag for a single record. It is derived from the
+// record id and the version column used for optimistic locking, so it changes
+// every time the record is updated. This is the tag of the JSON representation;
+// render suffixes it with the format name for the others.
+func recordETag(c *data.Company) string {
+	return fmt.Sprintf(`"%d-%d"`, c.ID, c.Version)
+}
+
+// suffixETag appends "-suffix" to the opaque part of an ETag, keeping any weak prefix, so
+// that different representations of the same resource are tagged differently
+func suffixETag(etag, suffix string) string {
+	if !strings.HasSuffix(etag, `"`) || len(etag) < 2 {
+		return etag
+	}
+	return etag[:len(etag)-1] + "-" + suffix + `"`
+}
+
+// bodyETag returns a weak ETag derived from a SHA-256 hash of the response body
+func bodyETag(body []byte) string {
+	sum := sha256.Sum256(body)
+	return fmt.Sprintf(`W/"%x"`, sum[:16])
+}
+
+// etagMatches reports whether etag is listed in the value of an If-Match or If-None-Match
+// header. If-None-Match uses the weak comparison function, so weak should be true for it,
+// while If-Match requires the strong comparison and never matches a weak ETag.
+func etagMatches(header, etag string, weak bool) bool {
+	for _, candidate := range strings.Split(header, ",") {
+		candidate = strings.TrimSpace(candidate)
+		switch {
+		case candidate == "":
+			continue
+		case candidate == "*":
+			return true
+		case weak && strings.TrimPrefix(candidate, "W/") == strings.TrimPrefix(etag, "W/"):
+			return true
+		case !weak && candidate == etag && !strings.HasPrefix(etag, "W/"):
+			return true
+		}
+	}
+	return false
+}
+
+// checkIfMatch enforces the If-Match precondition for writes to an existing record. Clients
+// must send the ETag they last saw for the record; a missing header gets a 428 response and
+// a stale one a 412 response. It returns false if a response has already been sent.
+func (app *application) checkIfMatch(w http.ResponseWriter, r *http.Request, record *data.Company) bool {
+	ifMatch := r.Header.Get("If-Match")
+	if ifMatch == "" {
+		app.preconditionRequiredResponse(w, r)
+		return false
+	}
+
+	// the client may have read the record in any format, so the ETag of each
+	// representation is accepted
+	for _, f := range formats {
+		if etagMatches(ifMatch, representationETag(recordETag(record), f), false) {
+			return true
+		}
+	}
+
+	app.preconditionFailedResponse(w, r)
+	return false
+}
+
 // readJSON will read the request body into a struct and check for different types of errors
 func (app *application) readJSON(w http.ResponseWriter, r *http.Request, dst any) error {
 	maxBytes := 1_048_576
+	r.Body = http.MaxBytesReader(w, r.Body, int64(maxBytes))
 	dec := json.NewDecoder(r.Body)
 	dec.DisallowUnknownFields()
 
@@ -101,7 +183,7 @@ func (app *application) readJSON(w http.ResponseWriter, r *http.Request, dst any
 		case errors.Is(err, io.EOF):
 			return errors.New("body must not be empty")
 		case strings.HasPrefix(err.Error(), "json: unknown field"):
-			fieldName := strings.TrimPrefix(err.Error(), "json: unknown field")
+			fieldName := strings.TrimPrefix(err.Error(), "json: unknown field ")
 			return fmt.Errorf("body contains unknown key %s", fieldName)
 		case errors.As(err, &maxBytesError):
 			return fmt.Errorf("body must be no larger than %d bytes", maxBytes)
@@ -114,7 +196,7 @@ func (app *application) readJSON(w http.ResponseWriter, r *http.Request, dst any
 	// Call Decode() again, using a pointer to an empty anonymous struct as the
 	// destination. If the request body only contained a single JSON value this will // return an io.EOF error. So if we get anything else, we know that there is
 	// additional data in the request body and we return our own custom error message. err = dec.Decode(&struct{}{})
-	err := dec.Decode(struct{}{})
+	err := dec.Decode(&struct{}{})
 	if err != io.EOF {
 		return errors.New("body must only contain a single JSON value")
 	}
@@ -179,21 +261,21 @@ func (app *application) readInt(qs url.Values, key string, defaultValue int, v *
 	return i
 }
 
-// background is a helper function that accepts an arbitrary function as a parameter
-func (app *application) background(fn func()) {
-
-	app.wg.Add(1)
-
-	// Launch a background routine
-	go func() {
-		defer app.wg.Done()
-
-		defer func() {
-			if err := recover(); err != nil {
-				app.logger.PrintError(fmt.Errorf("%s", err), nil)
-			}
-		}()
-		//Recover any panic
-		fn()
-	}()
+// background runs fn as a named one-off task under the application's supervisor, which
+// recovers panics, logs failures and lets the task finish during graceful shutdown. fn
+// should give up once ctx is done, as that means its timeout or the shutdown deadline passed.
+// The task outlives the request that started it, so ctx carries the trace of parent but
+// not its cancellation. Each task runs in its own span within that trace.
+func (app *application) background(parent context.Context, name string, fn func(ctx context.Context) error) {
+	app.tasks.Go(parent, name, func(ctx context.Context) error {
+		ctx, span := tracer.Start(ctx, "task "+name)
+		defer span.End()
+
+		err := fn(ctx)
+		if err != nil {
+			span.RecordError(err)
+			span.SetStatus(codes.Error, err.Error())
+		}
+		return err
+	})
 }
@@ -3,39 +3,123 @@ package main
 import (
 	"context"
 	"database/sql"
+	"errors"
 	"flag"
+	"fmt"
 	"github.com/sparkycj328/JobAIO-API/internal/data"
 	"github.com/sparkycj328/JobAIO-API/internal/jsonlog"
 	"github.com/sparkycj328/JobAIO-API/internal/mailer"
+	"github.com/sparkycj328/JobAIO-API/internal/migrate"
+	"github.com/sparkycj328/JobAIO-API/internal/supervisor"
+	"github.com/sparkycj328/JobAIO-API/migrations"
+	"net/url"
 	"os"
-	"sync"
+	"sync/atomic"
 	"time"
 
 	_ "github.com/lib/pq"
+	_ "modernc.org/sqlite"
 )
 
 const version = "1.0.0"
 
 // config struct will hold all configuration settings for our applications
 type config struct {
-	port int
-	env  string
-	db   struct {
+	file        string // path of the config file the settings were read from, if any
+	printConfig bool   // print the effective configuration and exit instead of serving
+	port        int
+	env         string
+	storage     string // postgres, sqlite or memory
+	db          struct {
 		dsn          string
+		sqliteFile   string
 		maxOpenConns int
 		maxIdleConns int
 		maxIdleTime  string
+		migrate      string
+		autoMigrate  bool
+		// queryTimeout applies to every model operation without a timeout in
+		// operationTimeouts, which is keyed by operation name such as VendorModel.GetAllRows
+		queryTimeout      time.Duration
+		operationTimeouts map[string]time.Duration
+		replica           struct {
+			dsn           string
+			maxOpenConns  int
+			maxIdleConns  int
+			maxIdleTime   string
+			maxLag        time.Duration
+			checkInterval time.Duration
+		}
+		// the jobs table is partitioned by month in PostgreSQL, with partitions created
+		// partitionAhead months in advance and those older than retentionMonths removed
+		partitionAhead         int
+		partitionCheckInterval time.Duration
+		retentionMonths        int    // 0 keeps every partition
+		retentionMode          string // detach or drop
 	}
 	limiter struct {
 		rps     float64
 		burst   int
 		enabled bool
 	}
+	logLevel string
+	health   struct {
+		checkSMTP       bool
+		checkMigrations bool
+		timeout         time.Duration
+	}
+	cors struct {
+		trustedOrigins []string
+	}
+	tls struct {
+		certFile         string
+		keyFile          string
+		clientCAFile     string
+		clientAuth       string
+		clientIdentities map[string]string
+	}
+	errors struct {
+		format string
+	}
+	compression struct {
+		enabled bool
+		minSize int
+	}
+	admin struct {
+		addr  string
+		token string
+	}
+	shutdown struct {
+		timeout     time.Duration
+		taskTimeout time.Duration
+		drain       time.Duration // how long /readyz reports 503 before the server stops accepting requests
+	}
+	// successful responses of the read endpoints are cached for ttl, keeping at most size
+	cache struct {
+		enabled bool
+		size    int
+		ttl     time.Duration
+	}
+	// old snapshots are rolled up by week and month every interval, and the raw snapshots
+	// older than rawRetentionMonths are then purged
+	rollups struct {
+		interval           time.Duration
+		rawRetentionMonths int // 0 keeps every raw snapshot
+	}
+	otel struct {
+		endpoint    string
+		sampleRatio float64
+	}
+	mail struct {
+		transport string
+		dir       string
+	}
 	smtp struct {
 		host     string
 		port     int
 		username string
 		password string
+		security string
 		sender   string
 	}
 }
@@ -43,80 +127,180 @@ type config struct {
 // application struct will hold the dependencies for our HTTP handlers
 // helper functions and middleware
 type application struct {
-	config config
-	logger *jsonlog.Logger
-	models data.Models
-	mailer mailer.Mailer
-	wg     sync.WaitGroup
+	config       config
+	live         atomic.Pointer[config] // config with the latest reloadable settings applied
+	shuttingDown atomic.Bool            // set once graceful shutdown has begun
+	logger       *jsonlog.Logger
+	db           *sql.DB           // nil when using in-memory storage
+	migrator     *migrate.Migrator // nil when using in-memory storage
+	replica      *data.Replica     // nil unless a read replica is configured
+	cache        *responseCache    // nil when response caching is disabled
+	models       data.Models
+	mailer       mailer.Mailer
+	tasks        *supervisor.Supervisor
 }
 
 func main() {
-	var cfg config
-
-	// read flag values into config struct
-	flag.IntVar(&cfg.port, "port", 4000, "API server port")
-	flag.StringVar(&cfg.env, "env", "development", "Environment (development|staging|production)\"")
-	flag.StringVar(&cfg.db.dsn, "dsn", os.Getenv(""), "Database connection")
-	// read flag values to configure the database
-	flag.IntVar(&cfg.db.maxOpenConns, "db-max-open-conns", 25, "PostgreSQL max open connections")
-	flag.IntVar(&cfg.db.maxIdleConns, "db-max-idle-conns", 25, "PostgresQL max idle connections")
-	flag.StringVar(&cfg.db.maxIdleTime, "db-max-idle-time", "15m", "PostgreSQL max connection idle time")
-	// read flag values to configure the rate limiter
-	flag.Float64Var(&cfg.limiter.rps, "limiter-rps", 2, "Rate limiter maximum requests per second")
-	flag.IntVar(&cfg.limiter.burst, "limiter-burst", 4, "Rate limiter maximum burst")
-	flag.BoolVar(&cfg.limiter.enabled, "limiter-enabled", true, "Enable rate limiter")
-
-	// Read the SMTP server configuration settings into the config struct, using the
-	// Mailtrap settings as the default values. IMPORTANT: If you're following along,
-	// make sure to replace the default values for smtp-username and smtp-password
-	// with your own Mailtrap credentials.
-	flag.StringVar(&cfg.smtp.host, "smtp-host", "smtp.mailtrap.io", "SMTP host")
-	flag.IntVar(&cfg.smtp.port, "smtp-port", 2525, "SMTP port")
-	flag.StringVar(&cfg.smtp.username, "smtp-username", os.Getenv("USERNAME"), "SMTP username")
-	flag.StringVar(&cfg.smtp.password, "smtp-password", os.Getenv("PASSWORD"), "SMTP password")
-	flag.StringVar(&cfg.smtp.sender, "smtp-sender", "RestrictedJobs <no-reply@restrictedjobs.sparky.net>", "SMTP sender")
-
-	flag.Parse()
-
-	logger := jsonlog.New(os.Stdout, jsonlog.LevelInfo)
-
-	// call openDB and defer the db from closing until main finishes
-	db, err := openDB(cfg)
+	// merge the config file, environment and command-line flags into the config struct
+	cfg, fs, err := loadConfig(os.Args[1:])
+	if err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return
+		}
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+
+	if cfg.printConfig {
+		if err := printConfig(os.Stdout, fs); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	// the level has already been validated by loadConfig
+	logLevel, _ := jsonlog.ParseLevel(cfg.logLevel)
+	logger := jsonlog.New(os.Stdout, logLevel)
+
+	transport, err := newMailTransport(cfg, logger)
 	if err != nil {
 		logger.PrintFatal(err, nil)
 	}
-	defer db.Close()
-	logger.PrintInfo("database connection pool established", nil)
 
 	// declares an instance of the application struct
-	// passes it our config, logger, and the database connection pool
+	// passes it our config and logger, the storage is attached below
 	app := &application{
 		config: cfg,
 		logger: logger,
-		models: data.NewModel(db),
-		mailer: mailer.New(cfg.smtp.host, cfg.smtp.port, cfg.smtp.username, cfg.smtp.password, cfg.smtp.sender),
+		mailer: mailer.New(transport, cfg.smtp.sender),
+		tasks:  supervisor.New(logger, cfg.shutdown.taskTimeout),
 	}
+	app.live.Store(&cfg)
+
+	if cfg.storage == "memory" {
+		app.models = data.NewMemoryModels()
+		logger.PrintInfo("using in-memory storage, records will be lost when the server stops", nil)
+	} else {
+		// call openDB and defer the db from closing until main finishes
+		db, err := openDB(cfg)
+		if err != nil {
+			logger.PrintFatal(err, nil)
+		}
+		defer db.Close()
+		logger.PrintInfo("database connection pool established", map[string]string{"storage": cfg.storage})
+
+		timeouts := data.Timeouts{
+			Default:    cfg.db.queryTimeout,
+			Operations: cfg.db.operationTimeouts,
+		}
+
+		// load the schema migrations embedded in the binary for the database in use
+		var migrator *migrate.Migrator
+		if cfg.storage == "sqlite" {
+			migrator, err = migrate.NewDialect(db, migrate.SQLite, migrations.SQLite)
+			app.models = data.NewSQLiteModels(db, timeouts)
+		} else {
+			migrator, err = migrate.New(db, migrations.FS)
+			if cfg.db.replica.dsn != "" {
+				replicaDB, err := openPool("postgres", cfg.db.replica.dsn, cfg.db.replica.maxOpenConns, cfg.db.replica.maxIdleConns, cfg.db.replica.maxIdleTime)
+				if err != nil {
+					logger.PrintFatal(err, nil)
+				}
+				defer replicaDB.Close()
+				app.replica = data.NewReplica(replicaDB, cfg.db.replica.maxLag)
+			}
+			app.models = data.NewReplicatedModel(db, app.replica, timeouts)
+		}
+		if err != nil {
+			logger.PrintFatal(err, nil)
+		}
+
+		app.db = db
+		app.migrator = migrator
+
+		// when asked to migrate, do so and exit without starting the server
+		if cfg.db.migrate != "" {
+			if err := app.runMigrateCommand(context.Background(), cfg.db.migrate); err != nil {
+				logger.PrintFatal(err, nil)
+			}
+			return
+		}
+
+		if cfg.db.autoMigrate {
+			if err := migrator.Up(context.Background()); err != nil {
+				logger.PrintFatal(err, nil)
+			}
+		}
+
+		if err := app.checkSchema(context.Background()); err != nil {
+			logger.PrintFatal(err, nil)
+		}
+
+		if app.replica != nil {
+			app.watchReplica()
+		}
+
+		if cfg.storage == "postgres" {
+			app.maintainPartitions()
+		}
+	}
+
+	app.setupCache()
+	app.maintainRollups()
+
+	app.publishMetrics()
+
+	shutdownTracing, err := app.setupTracing(context.Background())
+	if err != nil {
+		logger.PrintFatal(err, nil)
+	}
+	// flush the spans of the last requests once the server has stopped
+	defer func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		if err := shutdownTracing(ctx); err != nil {
+			logger.PrintError(err, nil)
+		}
+	}()
 
 	if err := app.serve(); err != nil {
 		logger.PrintFatal(err, nil)
 	}
 }
 
-// openDB will open the designated db based on the dsn
-// it will then ping the db and return it if no errors occurred
+// newMailTransport returns the transport selected by the mail-transport setting
+func newMailTransport(cfg config, logger *jsonlog.Logger) (mailer.Transport, error) {
+	switch cfg.mail.transport {
+	case "file":
+		return mailer.NewMaildir(cfg.mail.dir)
+	case "log":
+		return mailer.NewLog(logger), nil
+	case "capture":
+		return mailer.NewCapture(), nil
+	default:
+		return mailer.NewSMTP(mailer.SMTPConfig{
+			Host:     cfg.smtp.host,
+			Port:     cfg.smtp.port,
+			Username: cfg.smtp.username,
+			Password: cfg.smtp.password,
+			Security: cfg.smtp.security,
+		})
+	}
+}
+
+// openDB will open the designated db based on the dsn, or the SQLite file when using
+// SQLite storage. it will then ping the db and return it if no errors occurred
 func openDB(cfg config) (*sql.DB, error) {
-	db, err := sql.Open("postgres", cfg.db.dsn)
-	if err != nil {
-		return nil, err
+	driver, dsn := "postgres", cfg.db.dsn
+	if cfg.storage == "sqlite" {
+		driver, dsn = "sqlite", sqliteDSN(cfg.db.sqliteFile)
 	}
 
-	db.SetMaxOpenConns(cfg.db.maxOpenConns)
-	db.SetMaxIdleConns(cfg.db.maxIdleConns)
-	duration, err := time.ParseDuration(cfg.db.maxIdleTime)
+	db, err := openPool(driver, dsn, cfg.db.maxOpenConns, cfg.db.maxIdleConns, cfg.db.maxIdleTime)
 	if err != nil {
 		return nil, err
 	}
-	db.SetConnMaxIdleTime(duration)
 
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
@@ -129,3 +313,31 @@ func openDB(cfg config) (*sql.DB, error) {
 
 	return db, nil
 }
+
+// openPool opens a connection pool with the given limits, without connecting to the
+// database yet
+func openPool(driver, dsn string, maxOpenConns, maxIdleConns int, maxIdleTime string) (*sql.DB, error) {
+	db, err := sql.Open(driver, dsn)
+	if err != nil {
+		return nil, err
+	}
+
+	db.SetMaxOpenConns(maxOpenConns)
+	db.SetMaxIdleConns(maxIdleConns)
+	duration, err := time.ParseDuration(maxIdleTime)
+	if err != nil {
+		return nil, err
+	}
+	db.SetConnMaxIdleTime(duration)
+
+	return db, nil
+}
+
+// sqliteDSN returns the connection string for the SQLite database in file. Every connection
+// enforces foreign keys, which SQLite leaves off by default, and waits up to five seconds
+// for a lock instead of failing at once. Write-ahead logging lets readers carry on while a
+// write is in progress.
+func sqliteDSN(file string) string {
+	pragmas := url.Values{"_pragma": {"foreign_keys(1)", "journal_mode(WAL)", "busy_timeout(5000)"}}
+	return "file:" + file + "?" + pragmas.Encode()
+}
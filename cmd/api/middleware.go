@@ -1,7 +1,9 @@
 package main
 
 import (
+	"context"
 	"fmt"
+	"github.com/sparkycj328/JobAIO-API/internal/validator"
 	"golang.org/x/time/rate"
 	"net"
 	"net/http"
@@ -48,29 +50,29 @@ func (app *application) rateLimit(next http.Handler) http.Handler {
 		clients = make(map[string]*client)
 	)
 
-	// launch a go routine that will remove old entries from the clients IP map once every minute
-	go func() {
-		for {
-			time.Sleep(time.Minute)
+	// run a supervised worker that will remove old entries from the clients IP map once
+	// every minute, stopping when the server shuts down
+	app.tasks.Every("limiter-cleanup", time.Minute, func(ctx context.Context) error {
+		// Lock the mutex to prevent concurrent access to the clients map
+		mu.Lock()
+		defer mu.Unlock()
 
-			// Lock the mutex to prevent concurrent access to the clients map
-			mu.Lock()
-
-			// loop through the client IPs and remove any entries for clients
-			// that have not been seen within the last three minutes
-			for ip, client := range clients {
-				if time.Since(client.lastSeen) > 3*time.Minute {
-					delete(clients, ip)
-				}
+		// loop through the client IPs and remove any entries for clients
+		// that have not been seen within the last three minutes
+		for ip, client := range clients {
+			if time.Since(client.lastSeen) > 3*time.Minute {
+				delete(clients, ip)
 			}
-			// unlock the mutex once cleanup is complete
-			mu.Unlock()
 		}
-	}()
+		return nil
+	})
 	// return an http handler which wraps around each request through the http client
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// read the limiter settings once per request, as they can be changed by a reload
+		limiter := app.currentConfig().limiter
+
 		// only carry out the check if rate limiting is enabled
-		if app.config.limiter.enabled {
+		if limiter.enabled {
 			// grab the ip address completing the request
 			// return a server error if unable to read the IP address
 			ip, _, err := net.SplitHostPort(r.RemoteAddr)
@@ -85,7 +87,15 @@ func (app *application) rateLimit(next http.Handler) http.Handler {
 			// Check if the ip address already exists in the map
 			// if it does not exist, add a new limiter to the clients map
 			if _, found := clients[ip]; !found {
-				clients[ip] = &client{limiter: rate.NewLimiter(rate.Limit(app.config.limiter.rps), app.config.limiter.burst)}
+				clients[ip] = &client{limiter: rate.NewLimiter(rate.Limit(limiter.rps), limiter.burst)}
+			}
+
+			// bring existing limiters in line with any reloaded settings
+			if clients[ip].limiter.Limit() != rate.Limit(limiter.rps) {
+				clients[ip].limiter.SetLimit(rate.Limit(limiter.rps))
+			}
+			if clients[ip].limiter.Burst() != limiter.burst {
+				clients[ip].limiter.SetBurst(limiter.burst)
 			}
 
 			// Update the last seen time for the client.
@@ -107,3 +117,30 @@ func (app *application) rateLimit(next http.Handler) http.Handler {
 	})
 
 }
+
+// enableCORS allows browsers on the trusted origins to make cross-origin requests to the API,
+// including preflighted requests which use methods other than GET and POST or send
+// conditional request headers
+func (app *application) enableCORS(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// the response depends on the Origin header, so caches must key on it
+		w.Header().Add("Vary", "Origin")
+		w.Header().Add("Vary", "Access-Control-Request-Method")
+
+		origin := r.Header.Get("Origin")
+		if origin != "" && validator.PermittedValue(origin, app.currentConfig().cors.trustedOrigins...) {
+			w.Header().Set("Access-Control-Allow-Origin", origin)
+			w.Header().Set("Access-Control-Expose-Headers", "ETag, Location")
+
+			// answer preflight requests directly, without passing them to the router
+			if r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != "" {
+				w.Header().Set("Access-Control-Allow-Methods", "OPTIONS, PUT, PATCH, DELETE")
+				w.Header().Set("Access-Control-Allow-Headers", "Authorization, Content-Type, If-Match, If-None-Match")
+				w.WriteHeader(http.StatusOK)
+				return
+			}
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
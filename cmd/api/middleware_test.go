@@ -0,0 +1,204 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestRateLimit(t *testing.T) {
+	app := newTestApplication(t)
+	app.config.limiter.enabled = true
+	app.config.limiter.rps = 0.001
+	app.config.limiter.burst = 3
+	ts := newTestServer(t, app.routes())
+
+	for i := 1; i <= 5; i++ {
+		want := http.StatusOK
+		if i > app.config.limiter.burst {
+			want = http.StatusTooManyRequests
+		}
+
+		if res := ts.get(t, "/v1/healthcheck"); res.status != want {
+			t.Fatalf("request %d: got status %d, want %d: %s", i, res.status, want, res.body)
+		}
+	}
+
+	// the probes are never rate limited, so a busy client can't make them fail
+	for _, path := range []string{"/livez", "/readyz"} {
+		if res := ts.get(t, path); res.status != http.StatusOK {
+			t.Errorf("got status %d for %s, want %d: %s", res.status, path, http.StatusOK, res.body)
+		}
+	}
+}
+
+func TestRateLimitDisabled(t *testing.T) {
+	app := newTestApplication(t)
+	app.config.limiter.rps = 0.001
+	app.config.limiter.burst = 1
+	ts := newTestServer(t, app.routes())
+
+	for i := 1; i <= 5; i++ {
+		if res := ts.get(t, "/v1/healthcheck"); res.status != http.StatusOK {
+			t.Fatalf("request %d: got status %d, want %d: %s", i, res.status, http.StatusOK, res.body)
+		}
+	}
+}
+
+func TestRecoverPanic(t *testing.T) {
+	app := newTestApplication(t)
+	app.models.Vendors = panickingVendors{app.models.Vendors}
+	ts := newTestServer(t, app.routes())
+
+	res := ts.get(t, "/v1/companies")
+	if res.status != http.StatusInternalServerError {
+		t.Fatalf("got status %d, want %d: %s", res.status, http.StatusInternalServerError, res.body)
+	}
+	if !res.close {
+		t.Error("got an open connection, want it closed after the panic")
+	}
+	if strings.Contains(string(res.body), "panicking store") {
+		t.Errorf("got body %s, which leaks the panic value", res.body)
+	}
+
+	// the server keeps serving other requests
+	if res := ts.get(t, "/livez"); res.status != http.StatusOK {
+		t.Fatalf("after the panic: got status %d, want %d", res.status, http.StatusOK)
+	}
+}
+
+func TestRouting(t *testing.T) {
+	app := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+
+	tests := []struct {
+		name       string
+		method     string
+		path       string
+		wantStatus int
+		wantAllow  string
+	}{
+		{name: "unknown path", method: http.MethodGet, path: "/v1/unknown", wantStatus: http.StatusNotFound},
+		{name: "unversioned path", method: http.MethodGet, path: "/companies", wantStatus: http.StatusNotFound},
+		{name: "method not allowed", method: http.MethodDelete, path: "/v1/healthcheck", wantStatus: http.StatusMethodNotAllowed, wantAllow: "GET"},
+		{name: "post to a record", method: http.MethodPost, path: "/v1/record/1", wantStatus: http.StatusMethodNotAllowed},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			res := ts.request(t, tt.method, tt.path, "", nil)
+			if res.status != tt.wantStatus {
+				t.Fatalf("got status %d, want %d: %s", res.status, tt.wantStatus, res.body)
+			}
+			if tt.wantAllow != "" && !strings.Contains(res.header.Get("Allow"), tt.wantAllow) {
+				t.Errorf("got Allow %q, want it to contain %q", res.header.Get("Allow"), tt.wantAllow)
+			}
+		})
+	}
+}
+
+func TestCORS(t *testing.T) {
+	app := newTestApplication(t)
+	app.config.cors.trustedOrigins = []string{"https://jobs.example"}
+	ts := newTestServer(t, app.routes())
+
+	tests := []struct {
+		name        string
+		method      string
+		header      http.Header
+		wantStatus  int
+		wantOrigin  string
+		wantMethods bool
+	}{
+		{name: "trusted origin", method: http.MethodGet, header: headers("Origin", "https://jobs.example"), wantStatus: http.StatusOK, wantOrigin: "https://jobs.example"},
+		{name: "untrusted origin", method: http.MethodGet, header: headers("Origin", "https://evil.example"), wantStatus: http.StatusOK},
+		{name: "preflight", method: http.MethodOptions, header: headers("Origin", "https://jobs.example", "Access-Control-Request-Method", "PATCH"), wantStatus: http.StatusOK, wantOrigin: "https://jobs.example", wantMethods: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			res := ts.request(t, tt.method, "/v1/healthcheck", "", tt.header)
+			if res.status != tt.wantStatus {
+				t.Fatalf("got status %d, want %d: %s", res.status, tt.wantStatus, res.body)
+			}
+			if got := res.header.Get("Access-Control-Allow-Origin"); got != tt.wantOrigin {
+				t.Errorf("got Access-Control-Allow-Origin %q, want %q", got, tt.wantOrigin)
+			}
+			if got := res.header.Get("Access-Control-Allow-Methods") != ""; got != tt.wantMethods {
+				t.Errorf("got Access-Control-Allow-Methods %q", res.header.Get("Access-Control-Allow-Methods"))
+			}
+		})
+	}
+}
+
+func TestProblemDetails(t *testing.T) {
+	app := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+
+	res := ts.request(t, http.MethodGet, "/v1/companies?page=0", "", headers("Accept", problemMediaType))
+	if res.status != http.StatusUnprocessableEntity {
+		t.Fatalf("got status %d, want %d: %s", res.status, http.StatusUnprocessableEntity, res.body)
+	}
+	if got := res.header.Get("Content-Type"); got != problemMediaType {
+		t.Errorf("got Content-Type %q, want %q", got, problemMediaType)
+	}
+
+	var problem struct {
+		Type     string         `json:"type"`
+		Status   int            `json:"status"`
+		Instance string         `json:"instance"`
+		Errors   []problemError `json:"errors"`
+	}
+	res.decode(t, &problem)
+	if problem.Type != problemFailedValidation || problem.Status != http.StatusUnprocessableEntity || problem.Instance != "/v1/companies" {
+		t.Errorf("got problem %+v", problem)
+	}
+	if len(problem.Errors) != 1 || problem.Errors[0].Field != "page" {
+		t.Errorf("got errors %+v, want one for page", problem.Errors)
+	}
+}
+
+func TestCompression(t *testing.T) {
+	app := newTestApplication(t)
+	app.config.compression.minSize = 1
+	ts := newTestServer(t, app.routes())
+
+	res := ts.request(t, http.MethodGet, "/v1/healthcheck", "", headers("Accept-Encoding", "gzip"))
+	if res.status != http.StatusOK {
+		t.Fatalf("got status %d, want %d: %s", res.status, http.StatusOK, res.body)
+	}
+	if got := res.header.Get("Content-Encoding"); got != "gzip" {
+		t.Errorf("got Content-Encoding %q, want gzip", got)
+	}
+}
+
+func TestCompressedETag(t *testing.T) {
+	app := newTestApplication(t)
+	app.config.compression.minSize = 1
+	ts := newTestServer(t, app.routes())
+	insertCompany(t, app, "Acme", "US", 12)
+
+	// the compressed representation is tagged apart from the uncompressed one
+	res := ts.request(t, http.MethodGet, "/v1/record/1", "", headers("Accept-Encoding", "gzip"))
+	if got, want := res.header.Get("ETag"), `"1-1-gzip"`; got != want {
+		t.Fatalf("got ETag %q, want %q", got, want)
+	}
+	if got, want := ts.get(t, "/v1/record/1").header.Get("ETag"), `"1-1"`; got != want {
+		t.Errorf("got uncompressed ETag %q, want %q", got, want)
+	}
+
+	res = ts.request(t, http.MethodGet, "/v1/record/1", "", headers("Accept-Encoding", "gzip", "If-None-Match", `"1-1-gzip"`))
+	if res.status != http.StatusNotModified {
+		t.Fatalf("got status %d, want %d", res.status, http.StatusNotModified)
+	}
+	if got, want := res.header.Get("ETag"), `"1-1-gzip"`; got != want {
+		t.Errorf("got ETag %q, want %q", got, want)
+	}
+
+	// the tag of a compressed response still satisfies If-Match
+	body := `{"company": "Acme", "country": "CA", "total": 1, "url": "https://acme.example"}`
+	res = ts.request(t, http.MethodPut, "/v1/companies/1", body, headers("If-Match", `"1-1-gzip"`))
+	if res.status != http.StatusOK {
+		t.Fatalf("got status %d, want %d: %s", res.status, http.StatusOK, res.body)
+	}
+}
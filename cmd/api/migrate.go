@@ -0,0 +1,86 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/sparkycj328/JobAIO-API/internal/migrate"
+)
+
+// parseMigrateCommand splits the value of the -migrate setting into its action (up, down
+// or to) and its number, which is the target version for "to N" and the number of
+// migrations to revert for "down N"
+func parseMigrateCommand(command string) (string, int64, error) {
+	fields := strings.Fields(command)
+	switch {
+	case len(fields) == 1 && (fields[0] == "up" || fields[0] == "down"):
+		return fields[0], 0, nil
+	case len(fields) == 2 && (fields[0] == "to" || fields[0] == "down"):
+		n, err := strconv.ParseInt(fields[1], 10, 64)
+		if err != nil || n < 0 {
+			return "", 0, fmt.Errorf("invalid number %q", fields[1])
+		}
+		return fields[0], n, nil
+	default:
+		return "", 0, errors.New(`must be "up", "down", "down N" or "to N"`)
+	}
+}
+
+// runMigrateCommand carries out the migration requested with the -migrate setting
+func (app *application) runMigrateCommand(ctx context.Context, command string) error {
+	action, n, err := parseMigrateCommand(command)
+	if err != nil {
+		return err
+	}
+
+	switch action {
+	case "up":
+		err = app.migrator.Up(ctx)
+	case "down":
+		if n > 0 {
+			err = app.migrator.Rollback(ctx, int(n))
+		} else {
+			err = app.migrator.Down(ctx)
+		}
+	default:
+		err = app.migrator.To(ctx, n)
+	}
+	if err != nil {
+		return err
+	}
+
+	current, _, err := app.migrator.Version(ctx)
+	if err != nil {
+		return err
+	}
+	app.logger.PrintInfo("migrations complete", map[string]string{
+		"schema_version": strconv.FormatInt(current, 10),
+	})
+	return nil
+}
+
+// checkSchema refuses to let the server start against a schema which is dirty or older than
+// the newest migration embedded in the binary, as the queries would fail at runtime
+func (app *application) checkSchema(ctx context.Context) error {
+	current, dirty, err := app.migrator.Version(ctx)
+	if err != nil {
+		return err
+	}
+
+	latest := app.migrator.Latest()
+	switch {
+	case dirty:
+		return fmt.Errorf("%w at version %d, repair it before starting the server", migrate.ErrDirty, current)
+	case current < latest:
+		return fmt.Errorf("database schema is at version %d but version %d is required, run with -migrate=up or -auto-migrate", current, latest)
+	case current > latest:
+		app.logger.PrintInfo("database schema is newer than this binary", map[string]string{
+			"schema_version":   strconv.FormatInt(current, 10),
+			"expected_version": strconv.FormatInt(latest, 10),
+		})
+	}
+	return nil
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/sparkycj328/JobAIO-API/internal/data"
+)
+
+// maintainPartitions creates the upcoming partitions of the jobs table and removes those
+// past the retention period, once at startup and then every partition-check-interval. A
+// failed run is logged and retried on the next tick; snapshots written before their month's
+// partition exists go to the default partition, so nothing is lost meanwhile.
+func (app *application) maintainPartitions() {
+	if err := app.runPartitionMaintenance(context.Background()); err != nil {
+		app.logger.PrintError(err, nil)
+	}
+
+	app.tasks.Every("partition-maintenance", app.config.db.partitionCheckInterval, app.runPartitionMaintenance)
+}
+
+// runPartitionMaintenance does a single round of partition creation and retention
+func (app *application) runPartitionMaintenance(ctx context.Context) error {
+	ctx, cancel := context.WithTimeout(ctx, app.config.db.partitionCheckInterval)
+	defer cancel()
+
+	partitions := data.PartitionModel{DB: app.db}
+	now := time.Now()
+
+	created, err := partitions.CreateAhead(ctx, now, app.config.db.partitionAhead)
+	if len(created) > 0 {
+		app.logger.PrintInfo("created jobs table partitions", map[string]string{
+			"partitions": strings.Join(created, ","),
+		})
+	}
+	if err != nil {
+		return fmt.Errorf("creating jobs table partitions: %w", err)
+	}
+
+	if app.config.db.retentionMonths == 0 {
+		return nil
+	}
+
+	// partitions are only removed once the whole month is older than the retention period,
+	// and has been rolled up
+	cutoff := time.Date(now.UTC().Year(), now.UTC().Month(), 1, 0, 0, 0, 0, time.UTC).AddDate(0, -app.config.db.retentionMonths, 0)
+	detachOnly := app.config.db.retentionMode == "detach"
+
+	removed, err := partitions.RemoveBefore(ctx, cutoff, detachOnly)
+	if len(removed) > 0 {
+		app.logger.PrintInfo("removed jobs table partitions", map[string]string{
+			"partitions": strings.Join(removed, ","),
+			"mode":       app.config.db.retentionMode,
+			"cutoff":     cutoff.Format("2006-01"),
+		})
+	}
+	if err != nil {
+		return fmt.Errorf("removing jobs table partitions: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"reflect"
+	"strings"
+
+	"github.com/sparkycj328/JobAIO-API/internal/jsonlog"
+)
+
+// currentConfig returns the configuration with the most recently reloaded settings applied.
+// Settings which can change at runtime must be read through it rather than app.config.
+func (app *application) currentConfig() *config {
+	if cfg := app.live.Load(); cfg != nil {
+		return cfg
+	}
+	return &app.config
+}
+
+// reloadConfig re-reads the configuration from the same sources used at startup and applies
+// the settings which can be changed without a restart: the rate limiter, the minimum log
+// level, the trusted CORS origins and the SMTP sender. An invalid configuration is rejected
+// as a whole and the running configuration is left untouched.
+func (app *application) reloadConfig() {
+	next, _, err := loadConfig(os.Args[1:])
+	if err != nil {
+		app.logger.PrintError(fmt.Errorf("rejected configuration reload: %w", err), nil)
+		return
+	}
+
+	current := app.currentConfig()
+	updated := *current
+	changes := make(map[string]string)
+
+	// record a change to a reloadable setting and copy the new value onto updated
+	if current.limiter != next.limiter {
+		changes["limiter"] = fmt.Sprintf("%+v -> %+v", current.limiter, next.limiter)
+		updated.limiter = next.limiter
+	}
+	if current.logLevel != next.logLevel {
+		changes["log-level"] = current.logLevel + " -> " + next.logLevel
+		updated.logLevel = next.logLevel
+	}
+	if !reflect.DeepEqual(current.cors.trustedOrigins, next.cors.trustedOrigins) {
+		changes["cors-trusted-origins"] = fmt.Sprintf("%s -> %s",
+			strings.Join(current.cors.trustedOrigins, " "), strings.Join(next.cors.trustedOrigins, " "))
+		updated.cors = next.cors
+	}
+	if current.smtp.sender != next.smtp.sender {
+		changes["smtp-sender"] = current.smtp.sender + " -> " + next.smtp.sender
+		updated.smtp.sender = next.smtp.sender
+	}
+
+	// anything else that differs only takes effect after a restart, so say so in the log
+	// instead of silently ignoring it
+	pending := next
+	pending.limiter = updated.limiter
+	pending.logLevel = updated.logLevel
+	pending.cors = updated.cors
+	pending.smtp.sender = updated.smtp.sender
+	if !reflect.DeepEqual(pending, updated) {
+		changes["restart_required"] = "some changed settings only take effect after a restart"
+	}
+
+	app.live.Store(&updated)
+
+	// the level has already been validated by loadConfig
+	level, _ := jsonlog.ParseLevel(updated.logLevel)
+	app.logger.SetMinLevel(level)
+	app.mailer.SetSender(updated.smtp.sender)
+
+	if len(changes) == 0 {
+		app.logger.PrintInfo("configuration reloaded without changes", nil)
+		return
+	}
+	app.logger.PrintInfo("configuration reloaded", changes)
+}
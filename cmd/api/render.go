@@ -0,0 +1,396 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/xml"
+	"fmt"
+	"mime"
+	"net/http"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/vmihailenco/msgpack/v5"
+)
+
+// format describes a response representation which the renderer is able to produce
+type format struct {
+	name        string   // value accepted by the format= query parameter
+	contentType string   // Content-Type header sent with the response
+	mediaTypes  []string // media types in the Accept header which select this format
+	encode      func(data envelope) ([]byte, error)
+}
+
+// formats lists every supported representation. The first entry is used whenever the
+// client does not express a preference.
+var formats = []format{
+	{
+		name:        "json",
+		contentType: "application/json",
+		mediaTypes:  []string{"application/json"},
+		encode:      marshalJSON,
+	},
+	{
+		name:        "csv",
+		contentType: "text/csv; charset=utf-8",
+		mediaTypes:  []string{"text/csv"},
+		encode:      marshalCSV,
+	},
+	{
+		name:        "xml",
+		contentType: "application/xml; charset=utf-8",
+		mediaTypes:  []string{"application/xml", "text/xml"},
+		encode:      marshalXML,
+	},
+	{
+		name:        "msgpack",
+		contentType: "application/msgpack",
+		mediaTypes:  []string{"application/msgpack", "application/x-msgpack", "application/vnd.msgpack"},
+		encode:      marshalMsgpack,
+	},
+}
+
+// negotiateFormat selects the response format for a request. A format= query parameter
+// takes precedence over the Accept header. The boolean result is false if none of the
+// formats is acceptable to the client.
+func negotiateFormat(r *http.Request) (format, bool) {
+	if name := r.URL.Query().Get("format"); name != "" {
+		for _, f := range formats {
+			if strings.EqualFold(f.name, name) {
+				return f, true
+			}
+		}
+		return format{}, false
+	}
+
+	accept := r.Header.Get("Accept")
+	if accept == "" {
+		return formats[0], true
+	}
+
+	type mediaRange struct {
+		mediaType string
+		q         float64
+	}
+
+	// a media type given q=0 is refused outright, even when a wildcard in the same header
+	// would otherwise select it, so those exclusions are collected first
+	var ranges []mediaRange
+	excluded := make(map[string]bool)
+	for _, part := range strings.Split(accept, ",") {
+		mediaType, params, err := mime.ParseMediaType(strings.TrimSpace(part))
+		if err != nil {
+			continue
+		}
+
+		// the quality value defaults to 1 when omitted
+		q := 1.0
+		if value, ok := params["q"]; ok {
+			if q, err = strconv.ParseFloat(value, 64); err != nil {
+				continue
+			}
+		}
+
+		if q <= 0 && !strings.HasSuffix(mediaType, "/*") {
+			if f, ok := matchFormat(mediaType, nil); ok {
+				excluded[f.name] = true
+			}
+			continue
+		}
+		ranges = append(ranges, mediaRange{mediaType, q})
+	}
+
+	best, bestQ, found := formats[0], 0.0, false
+	for _, rng := range ranges {
+		if rng.q <= bestQ {
+			continue
+		}
+		if f, ok := matchFormat(rng.mediaType, excluded); ok {
+			best, bestQ, found = f, rng.q, true
+		}
+	}
+
+	return best, found
+}
+
+// matchFormat returns the first format satisfying a single media range from the Accept
+// header, which may be a wildcard such as */* or text/*, skipping the excluded formats
+func matchFormat(mediaRange string, excluded map[string]bool) (format, bool) {
+	for _, f := range formats {
+		if excluded[f.name] {
+			continue
+		}
+		for _, mediaType := range f.mediaTypes {
+			switch {
+			case mediaRange == "*/*", mediaRange == mediaType:
+				return f, true
+			case strings.HasSuffix(mediaRange, "/*") && strings.HasPrefix(mediaType, strings.TrimSuffix(mediaRange, "*")):
+				return f, true
+			}
+		}
+	}
+	return format{}, false
+}
+
+// representationETag returns the ETag of the representation of a resource in format f.
+// A strong ETag names a byte-for-byte identical body, so every format other than the
+// default one gets its own tag. Weak ETags are already derived from the encoded body.
+func representationETag(etag string, f format) string {
+	if f.name == formats[0].name || strings.HasPrefix(etag, "W/") {
+		return etag
+	}
+	return suffixETag(etag, f.name)
+}
+
+// render writes the envelope in the format negotiated with the client, or sends a 406 Not
+// Acceptable response if no supported format is acceptable. The response is tagged with
+// an ETag (the one given in headers for the negotiated format, otherwise a weak ETag of the
+// encoded body) and a 304
+// Not Modified is sent instead when it matches the request's If-None-Match header.
+func (app *application) render(w http.ResponseWriter, r *http.Request, status int, data envelope, headers http.Header) error {
+	w.Header().Add("Vary", "Accept")
+
+	f, ok := negotiateFormat(r)
+	if !ok {
+		app.notAcceptableResponse(w, r)
+		return nil
+	}
+
+	body, err := f.encode(data)
+	if err != nil {
+		return err
+	}
+
+	for key, value := range headers {
+		w.Header()[key] = value
+	}
+
+	etag := representationETag(w.Header().Get("ETag"), f)
+	if etag == "" {
+		etag = bodyETag(body)
+	}
+	w.Header().Set("ETag", etag)
+
+	// the client's cached copy is still current, so skip sending the body
+	if etagMatches(r.Header.Get("If-None-Match"), etag, true) {
+		w.WriteHeader(http.StatusNotModified)
+		return nil
+	}
+
+	w.Header().Set("Content-Type", f.contentType)
+	w.WriteHeader(status)
+	w.Write(body)
+
+	return nil
+}
+
+// marshalMsgpack encodes the envelope as MessagePack, reusing the JSON field names
+func marshalMsgpack(data envelope) ([]byte, error) {
+	var buf bytes.Buffer
+	enc := msgpack.NewEncoder(&buf)
+	enc.SetCustomStructTag("json")
+	enc.SetSortMapKeys(true)
+
+	if err := enc.Encode(map[string]any(data)); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// marshalCSV encodes the tabular part of the envelope as CSV. A slice of structs becomes
+// one row per element, while a single struct becomes one row, with a header row built from
+// the JSON field names. Other values, such as pagination metadata, have no place in a
+// table and are left out.
+func marshalCSV(data envelope) ([]byte, error) {
+	var table reflect.Value
+	for _, key := range sortedKeys(data) {
+		v := reflect.Indirect(reflect.ValueOf(data[key]))
+		if v.Kind() == reflect.Slice {
+			table = v
+			break
+		}
+		if v.Kind() == reflect.Struct && !table.IsValid() {
+			table = v
+		}
+	}
+
+	var buf bytes.Buffer
+	cw := csv.NewWriter(&buf)
+
+	if table.IsValid() {
+		rows := []reflect.Value{table}
+		if table.Kind() == reflect.Slice {
+			rows = make([]reflect.Value, table.Len())
+			for i := range rows {
+				rows[i] = table.Index(i)
+			}
+		}
+
+		header, indexes := csvColumns(table.Type())
+		if err := cw.Write(header); err != nil {
+			return nil, err
+		}
+
+		for _, row := range rows {
+			row = reflect.Indirect(row)
+			record := make([]string, len(indexes))
+			for i, index := range indexes {
+				if row.IsValid() {
+					record[i] = csvValue(row.Field(index))
+				}
+			}
+			if err := cw.Write(record); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	cw.Flush()
+	return buf.Bytes(), cw.Error()
+}
+
+// csvColumns returns the column names and field indexes of the struct type held by t,
+// which may be a struct, a pointer to one or a slice of either
+func csvColumns(t reflect.Type) ([]string, []int) {
+	for t.Kind() == reflect.Slice || t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+
+	var (
+		names   []string
+		indexes []int
+	)
+	if t.Kind() != reflect.Struct {
+		return names, indexes
+	}
+
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		name, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+		if !field.IsExported() || name == "-" {
+			continue
+		}
+		if name == "" {
+			name = field.Name
+		}
+		names = append(names, name)
+		indexes = append(indexes, i)
+	}
+	return names, indexes
+}
+
+// csvValue formats a single struct field as a CSV cell
+func csvValue(v reflect.Value) string {
+	if v.Kind() == reflect.Pointer {
+		if v.IsNil() {
+			return ""
+		}
+		v = v.Elem()
+	}
+
+	if t, ok := v.Interface().(time.Time); ok {
+		return t.Format(time.RFC3339)
+	}
+	return fmt.Sprint(v.Interface())
+}
+
+// marshalXML encodes the envelope as an XML document with a <response> root element and
+// one child element per envelope key
+func marshalXML(data envelope) ([]byte, error) {
+	var buf bytes.Buffer
+	buf.WriteString(xml.Header)
+
+	enc := xml.NewEncoder(&buf)
+	enc.Indent("", "\t")
+
+	root := xml.StartElement{Name: xml.Name{Local: "response"}}
+	if err := enc.EncodeToken(root); err != nil {
+		return nil, err
+	}
+	for _, key := range sortedKeys(data) {
+		if err := encodeXMLValue(enc, key, reflect.ValueOf(data[key])); err != nil {
+			return nil, err
+		}
+	}
+	if err := enc.EncodeToken(root.End()); err != nil {
+		return nil, err
+	}
+	if err := enc.Flush(); err != nil {
+		return nil, err
+	}
+
+	buf.WriteByte('\n')
+	return buf.Bytes(), nil
+}
+
+// encodeXMLValue writes v as an element called name. encoding/xml cannot handle maps, and
+// would flatten slices into repeated elements, so both are expanded here into a wrapper
+// element with one child per map entry or slice element.
+func encodeXMLValue(enc *xml.Encoder, name string, v reflect.Value) error {
+	start := xml.StartElement{Name: xml.Name{Local: name}}
+
+	for v.Kind() == reflect.Interface || v.Kind() == reflect.Pointer {
+		if v.IsNil() {
+			return enc.EncodeElement("", start)
+		}
+		v = v.Elem()
+	}
+
+	switch v.Kind() {
+	case reflect.Map:
+		if err := enc.EncodeToken(start); err != nil {
+			return err
+		}
+		keys := v.MapKeys()
+		sort.Slice(keys, func(i, j int) bool {
+			return fmt.Sprint(keys[i].Interface()) < fmt.Sprint(keys[j].Interface())
+		})
+		for _, key := range keys {
+			if err := encodeXMLValue(enc, fmt.Sprint(key.Interface()), v.MapIndex(key)); err != nil {
+				return err
+			}
+		}
+		return enc.EncodeToken(start.End())
+	case reflect.Slice, reflect.Array:
+		if v.Type().Elem().Kind() == reflect.Uint8 {
+			return enc.EncodeElement(v.Interface(), start)
+		}
+		if err := enc.EncodeToken(start); err != nil {
+			return err
+		}
+		for i := 0; i < v.Len(); i++ {
+			if err := encodeXMLValue(enc, xmlItemName(v.Type().Elem()), v.Index(i)); err != nil {
+				return err
+			}
+		}
+		return enc.EncodeToken(start.End())
+	default:
+		return enc.EncodeElement(v.Interface(), start)
+	}
+}
+
+// xmlItemName returns the element name used for each member of a slice, which is the
+// lower-cased type name for named types such as data.Company and "item" otherwise
+func xmlItemName(t reflect.Type) string {
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	if t.Name() == "" {
+		return "item"
+	}
+	return strings.ToLower(t.Name())
+}
+
+// sortedKeys returns the keys of the envelope in alphabetical order so that encoders
+// which don't sort maps themselves still produce deterministic output
+func sortedKeys(data envelope) []string {
+	keys := make([]string, 0, len(data))
+	for key := range data {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// watchReplica checks the read replica once, then again every replica-check-interval until
+// shutdown. An unreachable replica isn't fatal, reads simply stay on the primary until a
+// check finds it healthy.
+func (app *application) watchReplica() {
+	app.checkReplica(context.Background())
+
+	app.tasks.Every("replica-check", app.config.db.replica.checkInterval, func(ctx context.Context) error {
+		app.checkReplica(ctx)
+		return nil
+	})
+}
+
+// checkReplica measures the replica's lag, logging whenever reads move between the replica
+// and the primary rather than on every check
+func (app *application) checkReplica(ctx context.Context) {
+	previous := app.replica.Status()
+
+	ctx, cancel := context.WithTimeout(ctx, app.config.db.replica.checkInterval)
+	defer cancel()
+	status, err := app.replica.Check(ctx)
+
+	if !previous.CheckedAt.IsZero() && previous.Healthy == status.Healthy {
+		return
+	}
+
+	properties := map[string]string{
+		"lag":     status.Lag.String(),
+		"max_lag": app.replica.MaxLag.String(),
+	}
+	switch {
+	case status.Healthy:
+		app.logger.PrintInfo("read replica healthy, serving reads from it", properties)
+	case err != nil:
+		app.logger.PrintError(fmt.Errorf("read replica unavailable, serving reads from the primary: %w", err), nil)
+	default:
+		app.logger.PrintInfo("read replica lagging, serving reads from the primary", properties)
+	}
+}
+
+// replicaHealth describes the replica and its last check for the health output
+func (app *application) replicaHealth() map[string]string {
+	status := app.replica.Status()
+
+	state := "healthy"
+	switch {
+	case status.CheckedAt.IsZero():
+		state = "unchecked"
+	case status.Err != nil:
+		state = "unavailable"
+	case !status.Healthy:
+		state = "lagging"
+	}
+
+	health := map[string]string{
+		"state":   state,
+		"lag":     status.Lag.String(),
+		"max_lag": app.replica.MaxLag.String(),
+	}
+	if status.Err != nil {
+		health["error"] = status.Err.Error()
+	}
+	if !status.CheckedAt.IsZero() {
+		health["checked_at"] = status.CheckedAt.UTC().Format(time.RFC3339)
+	}
+	return health
+}
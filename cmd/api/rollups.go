@@ -0,0 +1,63 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/sparkycj328/JobAIO-API/internal/data"
+)
+
+// maintainRollups rolls up old snapshots and purges the raw ones past raw-retention-months,
+// every rollup-interval. The first run starts straight away, in the background, since
+// rolling up a large table for the first time can take a while.
+func (app *application) maintainRollups() {
+	app.tasks.Worker("rollups-initial", app.runRollups)
+	app.tasks.Every("rollups", app.config.rollups.interval, app.runRollups)
+}
+
+// runRollups refreshes the rollups, then purges the raw snapshots which are old enough.
+// Both work through a batch of days per transaction, so that progress is kept when the run
+// fails or runs out of time, and the next run picks up where it stopped.
+func (app *application) runRollups(ctx context.Context) error {
+	ctx, cancel := context.WithTimeout(ctx, app.config.rollups.interval)
+	defer cancel()
+
+	now := time.Now()
+	var stored, purged int64
+
+	for more := true; more; {
+		err := app.models.WithTx(ctx, func(tx data.Models) error {
+			n, ok, err := tx.Rollups.Refresh(ctx, now)
+			stored, more = stored+n, ok
+			return err
+		})
+		if err != nil {
+			return fmt.Errorf("rolling up snapshots: %w", err)
+		}
+	}
+
+	if months := app.config.rollups.rawRetentionMonths; months > 0 {
+		for more := true; more; {
+			err := app.models.WithTx(ctx, func(tx data.Models) error {
+				n, ok, err := tx.Rollups.Purge(ctx, now.AddDate(0, -months, 0))
+				purged, more = purged+n, ok
+				return err
+			})
+			if err != nil {
+				return fmt.Errorf("purging rolled up snapshots: %w", err)
+			}
+		}
+	}
+
+	if stored > 0 || purged > 0 {
+		// rollups change the listings of purged days and the history of every vendor
+		app.clearCache()
+		app.logger.PrintInfo("rolled up snapshots", map[string]string{
+			"rollups": strconv.FormatInt(stored, 10),
+			"purged":  strconv.FormatInt(purged, 10),
+		})
+	}
+	return nil
+}
@@ -0,0 +1,225 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/sparkycj328/JobAIO-API/internal/data"
+)
+
+// insertSnapshot stores a company snapshot taken at created, failing the test if it can't
+func insertSnapshot(t *testing.T, app *application, name, country string, total int, created time.Time) *data.Company {
+	t.Helper()
+
+	c := &data.Company{Name: name, Country: country, Total: total, URL: "https://acme.example/jobs/" + country, CreatedAt: &created}
+	if err := app.models.Vendors.Insert(context.Background(), c); err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+// january returns noon UTC on the given day of January 2023, whose 2nd and 9th are Mondays
+func january(d int) time.Time {
+	return time.Date(2023, time.January, d, 12, 0, 0, 0, time.UTC)
+}
+
+// wantStats checks the statistics of a rollup
+func wantStats(t *testing.T, got data.RollupStats, start time.Time, min, max int, avg float64, last, samples int) {
+	t.Helper()
+
+	if !got.Start.Equal(start) || got.Min != min || got.Max != max || got.Avg != avg || got.Last != last || got.Samples != samples {
+		t.Errorf("got rollup %+v, want start %s, min %d, max %d, avg %g, last %d and %d samples",
+			got, start.Format(time.DateOnly), min, max, avg, last, samples)
+	}
+}
+
+func TestRollups(t *testing.T) {
+	app := newTestApplication(t)
+	app.config.rollups.rawRetentionMonths = 12
+	ts := newTestServer(t, app.routes())
+
+	twoWeeksAgo := time.Now().UTC().AddDate(0, 0, -14)
+	rolled := insertSnapshot(t, app, "Acme", "DE", 10, twoWeeksAgo)
+	old := insertSnapshot(t, app, "Acme", "US", 10, january(2))
+	insertSnapshot(t, app, "Acme", "US", 30, january(4))
+	insertSnapshot(t, app, "Acme", "US", 20, january(4).Add(time.Hour))
+	insertSnapshot(t, app, "Acme", "US", 5, january(10))
+	insertSnapshot(t, app, "Acme", "CA", 7, january(3))
+	insertSnapshot(t, app, "Globex", "US", 1, january(3))
+	recent := insertCompany(t, app, "Acme", "US", 40)
+
+	if err := app.runRollups(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	t.Run("raw snapshots purged", func(t *testing.T) {
+		if res := ts.get(t, fmt.Sprintf("/v1/record/%d", old.ID)); res.status != http.StatusNotFound {
+			t.Errorf("got status %d for snapshot %d, want %d", res.status, old.ID, http.StatusNotFound)
+		}
+		if res := ts.get(t, fmt.Sprintf("/v1/record/%d", recent.ID)); res.status != http.StatusOK {
+			t.Errorf("got status %d for snapshot %d, want %d", res.status, recent.ID, http.StatusOK)
+		}
+	})
+
+	t.Run("refresh is incremental", func(t *testing.T) {
+		var stored int64
+		err := app.models.WithTx(context.Background(), func(tx data.Models) (err error) {
+			stored, _, err = tx.Rollups.Refresh(context.Background(), time.Now())
+			return err
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if stored != 0 {
+			t.Errorf("got %d rollups stored again, want 0", stored)
+		}
+	})
+
+	t.Run("listing a purged day", func(t *testing.T) {
+		res := ts.get(t, "/v1/companies?date=2023-Jan-04&vendor=acme&sort=country")
+		if res.status != http.StatusOK {
+			t.Fatalf("got status %d, want %d: %s", res.status, http.StatusOK, res.body)
+		}
+
+		var env struct {
+			Jobs []data.Company `json:"jobs"`
+		}
+		res.decode(t, &env)
+		if len(env.Jobs) != 2 {
+			t.Fatalf("got jobs %+v, want the weekly rollups of Acme in CA and US", env.Jobs)
+		}
+
+		ca, us := env.Jobs[0], env.Jobs[1]
+		if ca.Country != "CA" || us.Country != "US" || us.Rollup == nil || ca.Rollup == nil {
+			t.Fatalf("got jobs %+v, want the weekly rollups of Acme in CA and US", env.Jobs)
+		}
+		if us.ID != 0 || us.Total != 20 || !us.CreatedAt.Equal(january(2).Truncate(24*time.Hour)) {
+			t.Errorf("got company %+v, want no id, total 20 and created at the start of the week", us)
+		}
+		wantStats(t, *us.Rollup, january(2).Truncate(24*time.Hour), 10, 30, 20, 20, 3)
+		wantStats(t, *ca.Rollup, january(2).Truncate(24*time.Hour), 7, 7, 7, 7, 1)
+	})
+
+	t.Run("weekly history", func(t *testing.T) {
+		res := ts.get(t, "/v1/companies/Acme/history?period=week&country=US&from=2023-Jan-01&to=2023-Jan-15")
+		if res.status != http.StatusOK {
+			t.Fatalf("got status %d, want %d: %s", res.status, http.StatusOK, res.body)
+		}
+
+		var env struct {
+			History []data.Rollup `json:"history"`
+		}
+		res.decode(t, &env)
+		if len(env.History) != 2 {
+			t.Fatalf("got history %+v, want two weeks", env.History)
+		}
+		wantStats(t, env.History[0].RollupStats, january(2).Truncate(24*time.Hour), 10, 30, 20, 20, 3)
+		wantStats(t, env.History[1].RollupStats, january(9).Truncate(24*time.Hour), 5, 5, 5, 5, 1)
+		if got, want := env.History[1].End, january(16).Truncate(24*time.Hour); !got.Equal(want) {
+			t.Errorf("got end %s, want %s", got, want)
+		}
+	})
+
+	t.Run("monthly history", func(t *testing.T) {
+		res := ts.get(t, "/v1/companies/Acme/history?period=month&from=2023-Jan-20&to=2023-Jan-20")
+		if res.status != http.StatusOK {
+			t.Fatalf("got status %d, want %d: %s", res.status, http.StatusOK, res.body)
+		}
+
+		var env struct {
+			History []data.Rollup `json:"history"`
+		}
+		res.decode(t, &env)
+		if len(env.History) != 2 || env.History[0].Country != "CA" || env.History[1].Country != "US" {
+			t.Fatalf("got history %+v, want January in CA and US", env.History)
+		}
+		wantStats(t, env.History[1].RollupStats, january(1).Truncate(24*time.Hour), 5, 30, 16.25, 5, 4)
+	})
+
+	t.Run("history of the current week", func(t *testing.T) {
+		res := ts.get(t, "/v1/companies/Acme/history?country=US")
+		if res.status != http.StatusOK {
+			t.Fatalf("got status %d, want %d: %s", res.status, http.StatusOK, res.body)
+		}
+
+		var env struct {
+			History []data.Rollup `json:"history"`
+		}
+		res.decode(t, &env)
+		if len(env.History) != 1 || env.History[0].Samples != 1 || env.History[0].Last != 40 {
+			t.Errorf("got history %+v, want the current week summarised from the raw snapshot", env.History)
+		}
+	})
+
+	t.Run("backfill into a purged week", func(t *testing.T) {
+		backfill := insertSnapshot(t, app, "Acme", "US", 100, january(5))
+		if err := app.runRollups(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+
+		if res := ts.get(t, fmt.Sprintf("/v1/record/%d", backfill.ID)); res.status != http.StatusNotFound {
+			t.Errorf("got status %d for the backfilled snapshot, want it purged once rolled up", res.status)
+		}
+
+		var env struct {
+			History []data.Rollup `json:"history"`
+		}
+		ts.get(t, "/v1/companies/Acme/history?country=US&from=2023-Jan-01&to=2023-Jan-08").decode(t, &env)
+		if len(env.History) != 1 {
+			t.Fatalf("got history %+v, want one week", env.History)
+		}
+		wantStats(t, env.History[0].RollupStats, january(2).Truncate(24*time.Hour), 10, 100, 40, 100, 4)
+
+		ts.get(t, "/v1/companies/Acme/history?period=month&country=US&from=2023-Jan-01&to=2023-Jan-31").decode(t, &env)
+		if len(env.History) != 1 {
+			t.Fatalf("got history %+v, want one month", env.History)
+		}
+		wantStats(t, env.History[0].RollupStats, january(1).Truncate(24*time.Hour), 5, 100, 33, 5, 5)
+	})
+
+	t.Run("update of a rolled up day", func(t *testing.T) {
+		rolled.Total = 50
+		if err := app.models.Vendors.Update(context.Background(), rolled); err != nil {
+			t.Fatal(err)
+		}
+		if err := app.runRollups(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+
+		var env struct {
+			History []data.Rollup `json:"history"`
+		}
+		day := twoWeeksAgo.Format("2006-Jan-02")
+		ts.get(t, "/v1/companies/Acme/history?country=DE&from="+day+"&to="+day).decode(t, &env)
+		if len(env.History) != 1 {
+			t.Fatalf("got history %+v, want one week", env.History)
+		}
+		if got := env.History[0]; got.Min != 50 || got.Max != 50 || got.Last != 50 || got.Samples != 1 {
+			t.Errorf("got rollup %+v, want the updated amount", got.RollupStats)
+		}
+	})
+
+	t.Run("invalid parameters", func(t *testing.T) {
+		tests := []struct {
+			query     string
+			wantField string
+		}{
+			{query: "?period=day", wantField: "period"},
+			{query: "?from=2023-Jan-10&to=2023-Jan-01", wantField: "from"},
+			{query: "?to=yesterday", wantField: "to"},
+		}
+		for _, tt := range tests {
+			res := ts.get(t, "/v1/companies/Acme/history"+tt.query)
+			if res.status != http.StatusUnprocessableEntity {
+				t.Errorf("%s: got status %d, want %d", tt.query, res.status, http.StatusUnprocessableEntity)
+				continue
+			}
+			if _, ok := res.errorFields(t)[tt.wantField]; !ok {
+				t.Errorf("%s: got errors %s, want an error for %q", tt.query, res.body, tt.wantField)
+			}
+		}
+	})
+}
@@ -15,17 +15,42 @@ func (app *application) routes() http.Handler {
 	router.NotFound = http.HandlerFunc(app.notFoundResponse)
 	router.MethodNotAllowed = http.HandlerFunc(app.methodNotAllowedResponse)
 
+	// register a handler for a method and URL pattern, naming the request's trace span
+	// after the pattern
+	handle := func(method, pattern string, handler http.HandlerFunc) {
+		router.HandlerFunc(method, pattern, app.traceRoute(pattern, handler))
+	}
+
 	// register the appropriate methods, URL patterns and handler functions for our
-	// endpoints using the HandlerFunc() method.
-	router.HandlerFunc(http.MethodGet, "/v1/healthcheck", app.healthcheckHandler)
-
-	router.HandlerFunc(http.MethodGet, "/v1/companies", app.listCompanyHandler)
-	router.HandlerFunc(http.MethodPost, "/v1/companies", app.createCompanyHandler)
-	router.HandlerFunc(http.MethodGet, "/v1/companies/:name", app.showCompanyHandler)
-	router.HandlerFunc(http.MethodPut, "/v1/companies/:id", app.updateCompanyHandler)
-	router.HandlerFunc(http.MethodDelete, "/v1/companies/:id", app.deleteCompanyHandler)
-	router.HandlerFunc(http.MethodGet, "/v1/record/:id", app.showRecordHandler)
-	router.HandlerFunc(http.MethodPost, "/v1/users", app.registerUserHandler)
-
-	return app.recoverPanic(app.rateLimit(router))
+	// endpoints using the handle() helper.
+	handle(http.MethodGet, "/v1/healthcheck", app.healthcheckHandler)
+
+	handle(http.MethodGet, "/v1/companies", app.cacheResponse(app.listCompanyHandler))
+	handle(http.MethodPost, "/v1/companies", app.createCompanyHandler)
+	handle(http.MethodGet, "/v1/companies/:name", app.cacheResponse(app.showCompanyHandler))
+	handle(http.MethodGet, "/v1/companies/:name/history", app.cacheResponse(app.showCompanyHistoryHandler))
+	handle(http.MethodPut, "/v1/companies/:id", app.updateCompanyHandler)
+	handle(http.MethodDelete, "/v1/companies/:id", app.deleteCompanyHandler)
+	handle(http.MethodGet, "/v1/record/:id", app.showRecordHandler)
+	handle(http.MethodPatch, "/v1/record/:id", app.patchRecordHandler)
+	handle(http.MethodPost, "/v1/users", app.registerUserHandler)
+
+	// the probes get a router of their own which skips CORS, client identification and the
+	// rate limiter, so a probe sharing its IP address with busy clients never gets a 429
+	probes := httprouter.New()
+	probes.MethodNotAllowed = http.HandlerFunc(app.methodNotAllowedResponse)
+	probes.HandlerFunc(http.MethodGet, "/livez", app.traceRoute("/livez", app.livenessHandler))
+	probes.HandlerFunc(http.MethodGet, "/readyz", app.traceRoute("/readyz", app.readinessHandler))
+
+	api := app.enableCORS(app.identifyClient(app.rateLimit(router)))
+	dispatch := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/livez", "/readyz":
+			probes.ServeHTTP(w, r)
+		default:
+			api.ServeHTTP(w, r)
+		}
+	})
+
+	return app.traceRequests(app.metrics(app.compress(app.recoverPanic(dispatch))))
 }
@@ -7,6 +7,7 @@ import (
 	"net/http"
 	"os"
 	"os/signal"
+	"strconv"
 	"syscall"
 	"time"
 )
@@ -23,6 +24,57 @@ func (app *application) serve() error {
 		WriteTimeout: 30 * time.Second,
 	}
 
+	// Serve HTTPS (and with it HTTP/2) if a certificate and key have been configured
+	useTLS := app.config.tls.certFile != "" || app.config.tls.keyFile != ""
+	if useTLS {
+		if app.config.tls.certFile == "" || app.config.tls.keyFile == "" {
+			return errors.New("both -tls-cert and -tls-key must be provided to enable TLS")
+		}
+
+		tlsConfig, err := app.tlsConfig()
+		if err != nil {
+			return err
+		}
+		srv.TLSConfig = tlsConfig
+	}
+
+	// Start the admin listener, if configured, on its own address. It is plain HTTP and is
+	// meant to be bound to a private interface, with the admin token as a second line of
+	// defence.
+	var adminSrv *http.Server
+	if app.config.admin.addr != "" {
+		adminSrv = &http.Server{
+			Addr:        app.config.admin.addr,
+			Handler:     app.adminRoutes(),
+			IdleTimeout: time.Minute,
+			ReadTimeout: 10 * time.Second,
+			// CPU profiles and execution traces stream for as long as the client asks,
+			// 30 seconds by default
+			WriteTimeout: 2 * time.Minute,
+		}
+
+		go func() {
+			app.logger.PrintInfo("starting admin server", map[string]string{
+				"addr": adminSrv.Addr,
+			})
+			if err := adminSrv.ListenAndServe(); !errors.Is(err, http.ErrServerClosed) {
+				app.logger.PrintError(err, map[string]string{"addr": adminSrv.Addr})
+			}
+		}()
+	}
+
+	// Reload the configuration whenever a SIGHUP signal is received. The listener keeps
+	// running throughout, so no connections are dropped.
+	go func() {
+		hangup := make(chan os.Signal, 1)
+		signal.Notify(hangup, syscall.SIGHUP)
+
+		for range hangup {
+			app.logger.PrintInfo("reloading configuration", nil)
+			app.reloadConfig()
+		}
+	}()
+
 	// Use a shutdown error channel to receive any errors returned by the shutdown function.
 	shutdownError := make(chan error)
 
@@ -44,29 +96,53 @@ func (app *application) serve() error {
 			"signal": s.String(),
 		})
 
-		// Create a context with a 20-second timeout
-		ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
+		// report not-ready from now on so load balancers stop sending new traffic, and keep
+		// serving requests until the probes have had time to notice. A second signal skips
+		// the rest of the wait.
+		app.shuttingDown.Store(true)
+		if drain := app.config.shutdown.drain; drain > 0 {
+			app.logger.PrintInfo("draining before shutdown", map[string]string{
+				"drain": drain.String(),
+			})
+			select {
+			case <-time.After(drain):
+			case <-quit:
+			}
+		}
+
+		// Create a context with the shutdown deadline, which covers both in-flight requests
+		// and background tasks
+		ctx, cancel := context.WithTimeout(context.Background(), app.config.shutdown.timeout)
 		defer cancel()
 
+		// the admin server is closed straight away rather than drained, so a long-running
+		// profile download can't hold up the shutdown of the API itself
+		if adminSrv != nil {
+			adminSrv.Close()
+		}
+
 		// call the shutdown function, passing it the context to initiate graceful shutdown
 		// if the error returns nil then graceful shutdown was successful, otherwise
-		// the server had issues closing open connections, or it exceeded the 20-second timeout
+		// the server had issues closing open connections, or it exceeded the shutdown deadline
 		err := srv.Shutdown(ctx)
-		if err != nil {
-			shutdownError <- err
-		}
 
+		// no new background tasks can be started once the server has stopped handling
+		// requests, so wait for the remaining ones until the same deadline. Workers such
+		// as the limiter cleanup are cancelled straight away.
 		app.logger.PrintInfo("completing background tasks", map[string]string{
 			"addr": srv.Addr,
 		})
-		app.wg.Wait()
-		shutdownError <- nil
+		if tasksErr := app.tasks.Shutdown(ctx); err == nil {
+			err = tasksErr
+		}
+		shutdownError <- err
 	}()
 
 	// Log a startup message and start the server as normal
 	app.logger.PrintInfo("starting server", map[string]string{
 		"addr": srv.Addr,
 		"env":  app.config.env,
+		"tls":  strconv.FormatBool(useTLS),
 	})
 
 	// Start the local server and return an error
@@ -74,7 +150,13 @@ func (app *application) serve() error {
 	// return a http.ErrServerClosed error. So if we see this error, it is actually a
 	// good thing and an indication that the graceful shutdown has started. will only return an
 	// error if it is NOT http.ErrServerClosed()
-	err := srv.ListenAndServe()
+	var err error
+	if useTLS {
+		// the certificate is supplied by the TLS config, so no file names are needed here
+		err = srv.ListenAndServeTLS("", "")
+	} else {
+		err = srv.ListenAndServe()
+	}
 	if !errors.Is(err, http.ErrServerClosed) {
 		return err
 	}
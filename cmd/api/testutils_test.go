@@ -0,0 +1,269 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sparkycj328/JobAIO-API/internal/data"
+	"github.com/sparkycj328/JobAIO-API/internal/jsonlog"
+	"github.com/sparkycj328/JobAIO-API/internal/mailer"
+	"github.com/sparkycj328/JobAIO-API/internal/migrate"
+	"github.com/sparkycj328/JobAIO-API/internal/supervisor"
+	"github.com/sparkycj328/JobAIO-API/migrations"
+)
+
+// newTestApplication returns an application backed by an empty store and a mailer whose
+// capture transport keeps emails instead of delivering them. Its configuration holds the flag defaults,
+// except that rate limiting is off and nothing is logged. The store is in memory unless the
+// JOBAIO_TEST_STORAGE environment variable is set to sqlite, in which case a disposable
+// SQLite database is created and migrated in a temporary directory.
+func newTestApplication(t *testing.T) *application {
+	t.Helper()
+
+	var cfg config
+	newFlagSet(&cfg)
+	cfg.storage = "memory"
+	cfg.limiter.enabled = false
+	cfg.logLevel = "off"
+
+	logger := jsonlog.New(io.Discard, jsonlog.LevelOff)
+	app := &application{
+		config: cfg,
+		logger: logger,
+		mailer: mailer.New(mailer.NewCapture(), cfg.smtp.sender),
+		tasks:  supervisor.New(logger, 5*time.Second),
+	}
+
+	switch storage := os.Getenv("JOBAIO_TEST_STORAGE"); storage {
+	case "", "memory":
+		app.models = data.NewMemoryModels()
+	case "sqlite":
+		app.config.storage = "sqlite"
+		app.config.db.sqliteFile = filepath.Join(t.TempDir(), "test.db")
+
+		db, err := openDB(app.config)
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { db.Close() })
+
+		migrator, err := migrate.NewDialect(db, migrate.SQLite, migrations.SQLite)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := migrator.Up(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+
+		app.db = db
+		app.migrator = migrator
+		app.models = data.NewSQLiteModels(db, data.Timeouts{})
+	default:
+		t.Fatalf("unsupported JOBAIO_TEST_STORAGE %q", storage)
+	}
+
+	app.setupCache()
+
+	// stop the workers started by the middleware once the test is over
+	t.Cleanup(func() { waitForTasks(t, app) })
+
+	return app
+}
+
+// waitForTasks shuts down the application's supervisor, returning once every background
+// task, such as sending an email, has finished
+func waitForTasks(t *testing.T, app *application) {
+	t.Helper()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := app.tasks.Shutdown(ctx); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// sentEmails returns the emails delivered so far by the application's capture transport
+func sentEmails(t *testing.T, app *application) []mailer.Message {
+	t.Helper()
+
+	capture, ok := app.mailer.Transport().(*mailer.Capture)
+	if !ok {
+		t.Fatalf("got mail transport %T, want *mailer.Capture", app.mailer.Transport())
+	}
+	return capture.Messages()
+}
+
+// unreachableTransport is a mail transport which can never deliver anything, used to check
+// how a broken mail server is reported
+type unreachableTransport struct {
+	err error
+}
+
+func (u unreachableTransport) Deliver(ctx context.Context, msg *mailer.Message) error {
+	return u.err
+}
+
+func (u unreachableTransport) Ping(ctx context.Context) error {
+	return u.err
+}
+
+// testServer serves the application's routes, including all of the middleware, over a
+// real HTTP connection
+type testServer struct {
+	*httptest.Server
+}
+
+// newTestServer starts a server for the handler which is closed when the test ends
+func newTestServer(t *testing.T, h http.Handler) *testServer {
+	ts := httptest.NewServer(h)
+	t.Cleanup(ts.Close)
+	return &testServer{ts}
+}
+
+// testResponse holds everything a test may want to check about a response
+type testResponse struct {
+	status int
+	header http.Header
+	body   []byte
+	close  bool // the server closed the connection, which strips any Connection header
+}
+
+// request sends a request with the given body and headers to the server. Redirects are not
+// followed and bodies are not transparently decompressed, so that tests see exactly what
+// the server sent.
+func (ts *testServer) request(t *testing.T, method, path, body string, header http.Header) testResponse {
+	t.Helper()
+
+	var reader io.Reader
+	if body != "" {
+		reader = strings.NewReader(body)
+	}
+
+	req, err := http.NewRequest(method, ts.URL+path, reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for key, values := range header {
+		req.Header[key] = values
+	}
+	if req.Header.Get("Accept-Encoding") == "" {
+		req.Header.Set("Accept-Encoding", "identity")
+	}
+
+	client := ts.Client()
+	client.CheckRedirect = func(*http.Request, []*http.Request) error {
+		return http.ErrUseLastResponse
+	}
+
+	res, err := client.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+
+	resBody, err := io.ReadAll(res.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return testResponse{status: res.StatusCode, header: res.Header, body: bytes.TrimSpace(resBody), close: res.Close}
+}
+
+// get sends a GET request without a body
+func (ts *testServer) get(t *testing.T, path string) testResponse {
+	t.Helper()
+	return ts.request(t, http.MethodGet, path, "", nil)
+}
+
+// decode unmarshals the JSON response body into dst, failing the test if it can't
+func (r testResponse) decode(t *testing.T, dst any) {
+	t.Helper()
+
+	if err := json.Unmarshal(r.body, dst); err != nil {
+		t.Fatalf("decoding response body %q: %v", r.body, err)
+	}
+}
+
+// errorFields returns the fields of a failed validation response, failing the test if the
+// body isn't one
+func (r testResponse) errorFields(t *testing.T) map[string]string {
+	t.Helper()
+
+	var env struct {
+		Error map[string]string `json:"error"`
+	}
+	r.decode(t, &env)
+	if env.Error == nil {
+		t.Fatalf("response body %q has no validation errors", r.body)
+	}
+	return env.Error
+}
+
+// errorMessage returns the message of an error response, failing the test if the body
+// isn't one
+func (r testResponse) errorMessage(t *testing.T) string {
+	t.Helper()
+
+	var env struct {
+		Error string `json:"error"`
+	}
+	r.decode(t, &env)
+	if env.Error == "" {
+		t.Fatalf("response body %q has no error message", r.body)
+	}
+	return env.Error
+}
+
+// headers builds an http.Header from alternating keys and values
+func headers(keysAndValues ...string) http.Header {
+	h := make(http.Header)
+	for i := 0; i+1 < len(keysAndValues); i += 2 {
+		h.Set(keysAndValues[i], keysAndValues[i+1])
+	}
+	return h
+}
+
+// insertCompany stores a company snapshot created now, failing the test if it can't
+func insertCompany(t *testing.T, app *application, name, country string, total int) *data.Company {
+	t.Helper()
+
+	c := &data.Company{Name: name, Country: country, Total: total, URL: "https://" + strings.ToLower(name) + ".example/jobs"}
+	if err := app.models.Vendors.Insert(context.Background(), c); err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+// panickingVendors is a VendorStore whose every read panics, used to check that panics in
+// handlers are recovered
+type panickingVendors struct {
+	data.VendorStore
+}
+
+func (panickingVendors) GetAllRows(ctx context.Context, vendor string, total int, created time.Time, filters data.Filters) ([]*data.Company, data.Metadata, error) {
+	panic("panicking store")
+}
+
+// failingVendors is a VendorStore whose reads fail with err, used to check how errors
+// from the store are reported
+type failingVendors struct {
+	data.VendorStore
+	err error
+}
+
+func (v failingVendors) GetRecord(ctx context.Context, id int64) (*data.Company, error) {
+	return nil, v.err
+}
+
+// errStoreFailed stands in for an unexpected store failure
+var errStoreFailed = errors.New("store failed")
@@ -0,0 +1,223 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// certReloader serves the certificate and key held in two files on disk, reloading them
+// whenever either file changes so that renewed certificates are picked up without a restart.
+// The files are checked at most once every checkInterval, during a TLS handshake.
+type certReloader struct {
+	certFile      string
+	keyFile       string
+	checkInterval time.Duration
+	onReload      func(err error)
+
+	mu        sync.Mutex
+	cert      *tls.Certificate
+	modTime   time.Time
+	lastCheck time.Time
+}
+
+// newCertReloader loads the initial certificate, returning an error if it can't be read
+func newCertReloader(certFile, keyFile string, onReload func(err error)) (*certReloader, error) {
+	cr := &certReloader{
+		certFile:      certFile,
+		keyFile:       keyFile,
+		checkInterval: 10 * time.Second,
+		onReload:      onReload,
+	}
+
+	modTime, err := cr.latestModTime()
+	if err != nil {
+		return nil, err
+	}
+	if err := cr.load(modTime); err != nil {
+		return nil, err
+	}
+	return cr, nil
+}
+
+// latestModTime returns the most recent modification time of the certificate and key files
+func (cr *certReloader) latestModTime() (time.Time, error) {
+	var latest time.Time
+	for _, file := range []string{cr.certFile, cr.keyFile} {
+		info, err := os.Stat(file)
+		if err != nil {
+			return time.Time{}, err
+		}
+		if info.ModTime().After(latest) {
+			latest = info.ModTime()
+		}
+	}
+	return latest, nil
+}
+
+// load reads the key pair from disk. The caller must hold the mutex, or be the constructor.
+func (cr *certReloader) load(modTime time.Time) error {
+	cert, err := tls.LoadX509KeyPair(cr.certFile, cr.keyFile)
+	if err != nil {
+		return err
+	}
+	cr.cert = &cert
+	cr.modTime = modTime
+	return nil
+}
+
+// GetCertificate implements the tls.Config callback of the same name. If the files have
+// changed since they were last loaded, the new certificate is loaded first. A failed reload
+// (for example when only one of the two files has been replaced so far) keeps the previous
+// certificate in service.
+func (cr *certReloader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	cr.mu.Lock()
+	defer cr.mu.Unlock()
+
+	if time.Since(cr.lastCheck) >= cr.checkInterval {
+		cr.lastCheck = time.Now()
+
+		modTime, err := cr.latestModTime()
+		if err == nil && modTime.After(cr.modTime) {
+			err = cr.load(modTime)
+			if cr.onReload != nil {
+				cr.onReload(err)
+			}
+		}
+	}
+
+	return cr.cert, nil
+}
+
+// tlsConfig builds the TLS configuration for the API server from the application config.
+// It only offers TLS 1.2 and above with forward-secret AEAD cipher suites, advertises
+// HTTP/2 through ALPN and, if a client CA is configured, verifies client certificates.
+func (app *application) tlsConfig() (*tls.Config, error) {
+	reloader, err := newCertReloader(app.config.tls.certFile, app.config.tls.keyFile, func(err error) {
+		if err != nil {
+			app.logger.PrintError(fmt.Errorf("reloading TLS certificate: %w", err), nil)
+			return
+		}
+		app.logger.PrintInfo("reloaded TLS certificate", map[string]string{
+			"cert": app.config.tls.certFile,
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	tlsConfig := &tls.Config{
+		GetCertificate: reloader.GetCertificate,
+		MinVersion:     tls.VersionTLS12,
+		// Cipher suites only apply to TLS 1.2, as TLS 1.3 suites are not configurable
+		CipherSuites: []uint16{
+			tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256,
+			tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256,
+			tls.TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384,
+			tls.TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384,
+			tls.TLS_ECDHE_ECDSA_WITH_CHACHA20_POLY1305_SHA256,
+			tls.TLS_ECDHE_RSA_WITH_CHACHA20_POLY1305_SHA256,
+		},
+		CurvePreferences: []tls.CurveID{tls.X25519, tls.CurveP256},
+		NextProtos:       []string{"h2", "http/1.1"},
+	}
+
+	switch app.config.tls.clientAuth {
+	case "", "none":
+		return tlsConfig, nil
+	case "optional":
+		tlsConfig.ClientAuth = tls.VerifyClientCertIfGiven
+	case "require":
+		tlsConfig.ClientAuth = tls.RequireAndVerifyClientCert
+	default:
+		return nil, fmt.Errorf("invalid TLS client auth mode %q", app.config.tls.clientAuth)
+	}
+
+	if app.config.tls.clientCAFile == "" {
+		return nil, errors.New("a client CA file must be provided to verify client certificates")
+	}
+
+	pem, err := os.ReadFile(app.config.tls.clientCAFile)
+	if err != nil {
+		return nil, err
+	}
+
+	tlsConfig.ClientCAs = x509.NewCertPool()
+	if !tlsConfig.ClientCAs.AppendCertsFromPEM(pem) {
+		return nil, fmt.Errorf("no certificates found in client CA file %s", app.config.tls.clientCAFile)
+	}
+
+	return tlsConfig, nil
+}
+
+// clientIdentity maps a verified client certificate to the service identity configured for
+// it. The certificate's DNS, URI and email SANs are checked first, followed by its common
+// name. The boolean result is false if the certificate doesn't match any configured subject.
+func (app *application) clientIdentity(cert *x509.Certificate) (string, bool) {
+	subjects := append([]string{}, cert.DNSNames...)
+	for _, uri := range cert.URIs {
+		subjects = append(subjects, uri.String())
+	}
+	subjects = append(subjects, cert.EmailAddresses...)
+	subjects = append(subjects, cert.Subject.CommonName)
+
+	for _, subject := range subjects {
+		if identity, ok := app.config.tls.clientIdentities[subject]; ok && subject != "" {
+			return identity, true
+		}
+	}
+	return "", false
+}
+
+// identifyClient resolves the service identity of requests made with a verified client
+// certificate and stores it in the request context. Requests presenting a certificate which
+// isn't mapped to any identity are refused.
+func (app *application) identifyClient(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.TLS == nil || len(r.TLS.VerifiedChains) == 0 {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		identity, ok := app.clientIdentity(r.TLS.VerifiedChains[0][0])
+		if !ok {
+			app.unknownClientCertificateResponse(w, r)
+			return
+		}
+
+		next.ServeHTTP(w, app.contextSetClientIdentity(r, identity))
+	})
+}
+
+// identityFlag implements flag.Value for the repeatable -tls-client-identity flag, which
+// takes values of the form subject=identity
+type identityFlag map[string]string
+
+func (f identityFlag) String() string {
+	pairs := make([]string, 0, len(f))
+	for subject, identity := range f {
+		pairs = append(pairs, subject+"="+identity)
+	}
+	return strings.Join(pairs, ",")
+}
+
+func (f identityFlag) reset() {
+	for subject := range f {
+		delete(f, subject)
+	}
+}
+
+func (f identityFlag) Set(value string) error {
+	subject, identity, ok := strings.Cut(value, "=")
+	if !ok || subject == "" || identity == "" {
+		return errors.New("must be in the format subject=identity")
+	}
+	f[subject] = identity
+	return nil
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// serviceName identifies the API in the traces it exports
+const serviceName = "jobaio-api"
+
+// tracer creates the server span of every request and the spans of background tasks
+var tracer = otel.Tracer("github.com/sparkycj328/JobAIO-API/cmd/api")
+
+// setupTracing installs the W3C trace context propagator and, if an OTLP endpoint is
+// configured, a tracer provider which batches spans to it. The returned function flushes
+// any buffered spans and must be called before the application exits. Without an endpoint
+// spans are not recorded, but incoming traceparent headers are still honoured so that log
+// entries carry the caller's trace ID.
+func (app *application) setupTracing(ctx context.Context) (func(context.Context) error, error) {
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(
+		propagation.TraceContext{},
+		propagation.Baggage{},
+	))
+
+	if app.config.otel.endpoint == "" {
+		return func(context.Context) error { return nil }, nil
+	}
+
+	exporter, err := otlptracehttp.New(ctx, otlptracehttp.WithEndpointURL(app.config.otel.endpoint))
+	if err != nil {
+		return nil, fmt.Errorf("creating OTLP exporter: %w", err)
+	}
+
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(app.config.otel.sampleRatio))),
+		sdktrace.WithResource(resource.NewSchemaless(
+			attribute.String("service.name", serviceName),
+			attribute.String("service.version", version),
+			attribute.String("deployment.environment", app.config.env),
+		)),
+	)
+	otel.SetTracerProvider(provider)
+
+	app.logger.PrintInfo("exporting traces", map[string]string{
+		"endpoint":     app.config.otel.endpoint,
+		"sample_ratio": fmt.Sprint(app.config.otel.sampleRatio),
+	})
+
+	return provider.Shutdown, nil
+}
+
+// traceRequests starts a server span for every request, continuing the trace given in the
+// traceparent header if there is one. The span is named after the method until the router
+// has matched a route, at which point traceRoute renames it.
+func (app *application) traceRequests(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := otel.GetTextMapPropagator().Extract(r.Context(), propagation.HeaderCarrier(r.Header))
+		ctx, span := tracer.Start(ctx, r.Method,
+			trace.WithSpanKind(trace.SpanKindServer),
+			trace.WithAttributes(
+				attribute.String("http.request.method", r.Method),
+				attribute.String("url.path", r.URL.Path),
+				attribute.String("client.address", r.RemoteAddr),
+				attribute.String("user_agent.original", r.UserAgent()),
+			),
+		)
+		defer span.End()
+
+		mw := &metricsResponseWriter{ResponseWriter: w, statusCode: http.StatusOK}
+		next.ServeHTTP(mw, r.WithContext(ctx))
+
+		span.SetAttributes(attribute.Int("http.response.status_code", mw.statusCode))
+		if mw.statusCode >= http.StatusInternalServerError {
+			span.SetStatus(codes.Error, http.StatusText(mw.statusCode))
+		}
+	})
+}
+
+// traceRoute names the request's server span after the matched route pattern, such as
+// "GET /v1/record/:id", so that spans for the same endpoint can be grouped together
+func (app *application) traceRoute(pattern string, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		span := trace.SpanFromContext(r.Context())
+		span.SetName(r.Method + " " + pattern)
+		span.SetAttributes(attribute.String("http.route", pattern))
+
+		next(w, r)
+	}
+}
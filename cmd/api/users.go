@@ -1,9 +1,11 @@
 package main
 
 import (
+	"context"
 	"errors"
 	"github.com/sparkycj328/JobAIO-API/internal/data"
 	"github.com/sparkycj328/JobAIO-API/internal/validator"
+	"golang.org/x/crypto/bcrypt"
 	"net/http"
 )
 
@@ -32,9 +34,10 @@ func (app *application) registerUserHandler(w http.ResponseWriter, r *http.Reque
 	}
 
 	// Use the Password.Set() method to generate and store the hashed and plaintext
-	// passwords.
+	// passwords. A password which is too long for bcrypt is reported by ValidateUser
+	// below as a validation error rather than a server error.
 	err = user.Password.Set(input.Password)
-	if err != nil {
+	if err != nil && !errors.Is(err, bcrypt.ErrPasswordTooLong) {
 		app.serverErrorResponse(w, r, err)
 		return
 	}
@@ -48,8 +51,15 @@ func (app *application) registerUserHandler(w http.ResponseWriter, r *http.Reque
 		return
 	}
 
-	// Insert the user data into the database.
-	err = app.models.Users.Insert(user)
+	// Insert the user data into the database and grant the user read access to the
+	// companies in a single transaction, so that a failed grant never leaves behind a
+	// user without permissions.
+	err = app.models.WithTx(r.Context(), func(tx data.Models) error {
+		if err := tx.Users.Insert(r.Context(), user); err != nil {
+			return err
+		}
+		return tx.Permissions.AddForUser(r.Context(), user.ID, data.PermissionCompaniesRead)
+	})
 	if err != nil {
 		switch {
 		// If we get a ErrDuplicateEmail error, use the v.AddError() method to manually
@@ -66,11 +76,8 @@ func (app *application) registerUserHandler(w http.ResponseWriter, r *http.Reque
 
 	// Call the send method on our Mailer, passing in the user's email address,
 	// name of the template file, and the User struct containing the new user's data.
-	app.background(func() {
-		err = app.mailer.Send(user.Email, "user_welcome.tmpl", user)
-		if err != nil {
-			app.logger.PrintError(err, nil)
-		}
+	app.background(r.Context(), "welcome-email", func(ctx context.Context) error {
+		return app.mailer.Send(ctx, user.Email, "user_welcome.tmpl", user)
 	})
 
 	err = app.writeJSON(w, http.StatusAccepted, envelope{"user": user}, nil)
@@ -0,0 +1,261 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/sparkycj328/JobAIO-API/internal/data"
+)
+
+func TestRegisterUser(t *testing.T) {
+	tests := []struct {
+		name       string
+		body       string
+		wantStatus int
+		wantFields []string
+	}{
+		{
+			name:       "valid",
+			body:       `{"name": "Alice", "email": "alice@example.com", "password": "pa55word1234"}`,
+			wantStatus: http.StatusAccepted,
+		},
+		{
+			name:       "duplicate email",
+			body:       `{"name": "Alice", "email": "bob@example.com", "password": "pa55word1234"}`,
+			wantStatus: http.StatusUnprocessableEntity,
+			wantFields: []string{"email"},
+		},
+		{
+			name:       "duplicate email in another case",
+			body:       `{"name": "Alice", "email": "Bob@Example.com", "password": "pa55word1234"}`,
+			wantStatus: http.StatusUnprocessableEntity,
+			wantFields: []string{"email"},
+		},
+		{
+			name:       "missing fields",
+			body:       `{}`,
+			wantStatus: http.StatusUnprocessableEntity,
+			wantFields: []string{"name", "email", "password"},
+		},
+		{
+			name:       "invalid email",
+			body:       `{"name": "Alice", "email": "alice", "password": "pa55word1234"}`,
+			wantStatus: http.StatusUnprocessableEntity,
+			wantFields: []string{"email"},
+		},
+		{
+			name:       "short password",
+			body:       `{"name": "Alice", "email": "alice@example.com", "password": "pa55"}`,
+			wantStatus: http.StatusUnprocessableEntity,
+			wantFields: []string{"password"},
+		},
+		{
+			name:       "long password",
+			body:       `{"name": "Alice", "email": "alice@example.com", "password": "` + strings.Repeat("p", 73) + `"}`,
+			wantStatus: http.StatusUnprocessableEntity,
+			wantFields: []string{"password"},
+		},
+		{
+			name:       "unknown key",
+			body:       `{"name": "Alice", "email": "alice@example.com", "password": "pa55word1234", "admin": true}`,
+			wantStatus: http.StatusBadRequest,
+		},
+		{
+			name:       "badly-formed JSON",
+			body:       `{"name": "Alice"`,
+			wantStatus: http.StatusBadRequest,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app := newTestApplication(t)
+			ts := newTestServer(t, app.routes())
+
+			// register the user whose email address the duplicate cases reuse
+			res := ts.request(t, http.MethodPost, "/v1/users", `{"name": "Bob", "email": "bob@example.com", "password": "pa55word1234"}`, nil)
+			if res.status != http.StatusAccepted {
+				t.Fatalf("registering the first user: got status %d: %s", res.status, res.body)
+			}
+
+			res = ts.request(t, http.MethodPost, "/v1/users", tt.body, nil)
+			if res.status != tt.wantStatus {
+				t.Fatalf("got status %d, want %d: %s", res.status, tt.wantStatus, res.body)
+			}
+
+			if tt.wantFields != nil {
+				fields := res.errorFields(t)
+				for _, field := range tt.wantFields {
+					if _, ok := fields[field]; !ok {
+						t.Errorf("got errors %v, want an error for %q", fields, field)
+					}
+				}
+			}
+
+			// a welcome email is sent to every user who registered
+			waitForTasks(t, app)
+			wantEmails := 1
+			if tt.wantStatus == http.StatusAccepted {
+				wantEmails = 2
+			}
+			if emails := sentEmails(t, app); len(emails) != wantEmails {
+				t.Errorf("got %d emails sent, want %d", len(emails), wantEmails)
+			}
+		})
+	}
+}
+
+func TestRegisterUserResponse(t *testing.T) {
+	app := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+
+	res := ts.request(t, http.MethodPost, "/v1/users", `{"name": "Alice", "email": "alice@example.com", "password": "pa55word1234"}`, nil)
+	if res.status != http.StatusAccepted {
+		t.Fatalf("got status %d, want %d: %s", res.status, http.StatusAccepted, res.body)
+	}
+
+	var env struct {
+		User map[string]any `json:"user"`
+	}
+	res.decode(t, &env)
+	if env.User["email"] != "alice@example.com" || env.User["activated"] != false {
+		t.Errorf("got user %v, want an inactive user with the email address", env.User)
+	}
+	for _, secret := range []string{"password", "password_hash", "version"} {
+		if _, ok := env.User[secret]; ok {
+			t.Errorf("got user %v, which includes %q", env.User, secret)
+		}
+	}
+
+	waitForTasks(t, app)
+	emails := sentEmails(t, app)
+	if len(emails) != 1 {
+		t.Fatalf("got %d emails sent, want 1", len(emails))
+	}
+	email := emails[0]
+	if email.To != "alice@example.com" || email.From != app.config.smtp.sender || email.Template != "user_welcome.tmpl" {
+		t.Errorf("got email %+v, want the welcome email sent to alice@example.com", email)
+	}
+	if email.Subject != "Welcome to RestrictedJobs!" {
+		t.Errorf("got subject %q", email.Subject)
+	}
+	wantID := fmt.Sprintf("your user ID number is %v", env.User["id"])
+	if !strings.Contains(email.PlainBody, wantID) || !strings.Contains(email.HTMLBody, wantID) {
+		t.Errorf("got bodies %q and %q, want them to contain %q", email.PlainBody, email.HTMLBody, wantID)
+	}
+}
+
+func TestRegisterUserPermissions(t *testing.T) {
+	app := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+
+	res := ts.request(t, http.MethodPost, "/v1/users", `{"name": "Alice", "email": "alice@example.com", "password": "pa55word1234"}`, nil)
+	if res.status != http.StatusAccepted {
+		t.Fatalf("got status %d, want %d: %s", res.status, http.StatusAccepted, res.body)
+	}
+
+	user, err := app.models.Users.GetByEmail(context.Background(), "alice@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	permissions, err := app.models.Permissions.GetAllForUser(context.Background(), user.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(permissions, data.Permissions{data.PermissionCompaniesRead}) {
+		t.Errorf("got permissions %v, want only %s", permissions, data.PermissionCompaniesRead)
+	}
+}
+
+func TestWithTx(t *testing.T) {
+	errRollback := errors.New("roll back")
+
+	tests := []struct {
+		name     string
+		fn       func(tx data.Models, user *data.User) error
+		wantErr  error
+		panics   bool
+		wantUser bool
+	}{
+		{
+			name: "commit",
+			fn: func(tx data.Models, user *data.User) error {
+				if err := tx.Users.Insert(context.Background(), user); err != nil {
+					return err
+				}
+				return tx.Permissions.AddForUser(context.Background(), user.ID, data.PermissionCompaniesRead)
+			},
+			wantUser: true,
+		},
+		{
+			name: "nested units of work join the transaction",
+			fn: func(tx data.Models, user *data.User) error {
+				return tx.WithTx(context.Background(), func(tx data.Models) error {
+					return tx.Users.Insert(context.Background(), user)
+				})
+			},
+			wantUser: true,
+		},
+		{
+			name: "error",
+			fn: func(tx data.Models, user *data.User) error {
+				if err := tx.Users.Insert(context.Background(), user); err != nil {
+					return err
+				}
+				return errRollback
+			},
+			wantErr: errRollback,
+		},
+		{
+			name: "panic",
+			fn: func(tx data.Models, user *data.User) error {
+				if err := tx.Users.Insert(context.Background(), user); err != nil {
+					return err
+				}
+				panic("unit of work failed")
+			},
+			panics: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app := newTestApplication(t)
+
+			user := &data.User{Name: "Alice", Email: "alice@example.com"}
+			if err := user.Password.Set("pa55word1234"); err != nil {
+				t.Fatal(err)
+			}
+
+			var err error
+			func() {
+				defer func() {
+					if p := recover(); (p != nil) != tt.panics {
+						t.Errorf("got panic %v, want a panic to be %t", p, tt.panics)
+					}
+				}()
+				err = app.models.WithTx(context.Background(), func(tx data.Models) error {
+					return tt.fn(tx, user)
+				})
+			}()
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("got error %v, want %v", err, tt.wantErr)
+			}
+
+			// the store must still be usable, and only hold the user if the unit of work
+			// was committed
+			_, err = app.models.Users.GetByEmail(context.Background(), user.Email)
+			switch {
+			case tt.wantUser && err != nil:
+				t.Errorf("got error %v, want the user to have been committed", err)
+			case !tt.wantUser && !errors.Is(err, data.ErrRecordNotFound):
+				t.Errorf("got error %v, want the user to have been rolled back", err)
+			}
+		})
+	}
+}
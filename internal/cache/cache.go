@@ -0,0 +1,152 @@
+package cache
+
+import (
+	"container/list"
+	"sync"
+	"time"
+)
+
+// Stats holds the counters of a cache since it was created
+type Stats struct {
+	Entries       int   `json:"entries"`
+	Hits          int64 `json:"hits"`
+	Misses        int64 `json:"misses"`
+	Evictions     int64 `json:"evictions"`     // entries dropped to make room for new ones
+	Expirations   int64 `json:"expirations"`   // entries dropped because they outlived the TTL
+	Invalidations int64 `json:"invalidations"` // entries dropped by DeleteFunc or Clear
+}
+
+// entry is an element of the recency list
+type entry[K comparable, V any] struct {
+	key     K
+	value   V
+	expires time.Time
+}
+
+// Cache holds at most a fixed number of entries, each for no longer than a TTL. When it is
+// full, storing an entry evicts the least recently used one. It is safe for concurrent use.
+type Cache[K comparable, V any] struct {
+	capacity int
+	ttl      time.Duration
+
+	mu    sync.Mutex
+	items map[K]*list.Element
+	order *list.List // most recently used at the front
+	stats Stats
+}
+
+// New returns an empty cache holding up to capacity entries for ttl each
+func New[K comparable, V any](capacity int, ttl time.Duration) *Cache[K, V] {
+	return &Cache[K, V]{
+		capacity: capacity,
+		ttl:      ttl,
+		items:    make(map[K]*list.Element, capacity),
+		order:    list.New(),
+	}
+}
+
+// Get returns the value stored for key, unless there is none or it has expired
+func (c *Cache[K, V]) Get(key K) (V, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if el, ok := c.items[key]; ok {
+		e := el.Value.(*entry[K, V])
+		if time.Now().Before(e.expires) {
+			c.order.MoveToFront(el)
+			c.stats.Hits++
+			return e.value, true
+		}
+		c.remove(el)
+		c.stats.Expirations++
+	}
+
+	c.stats.Misses++
+	var zero V
+	return zero, false
+}
+
+// Set stores value for key for the cache's TTL, replacing any value already stored
+func (c *Cache[K, V]) Set(key K, value V) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	expires := time.Now().Add(c.ttl)
+	if el, ok := c.items[key]; ok {
+		e := el.Value.(*entry[K, V])
+		e.value, e.expires = value, expires
+		c.order.MoveToFront(el)
+		return
+	}
+
+	c.items[key] = c.order.PushFront(&entry[K, V]{key: key, value: value, expires: expires})
+	for c.order.Len() > c.capacity {
+		c.remove(c.order.Back())
+		c.stats.Evictions++
+	}
+}
+
+// DeleteFunc removes every entry for which del returns true, returning how many were
+// removed. del is called with the cache locked, so it must not use the cache.
+func (c *Cache[K, V]) DeleteFunc(del func(key K, value V) bool) int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	removed := 0
+	for el := c.order.Front(); el != nil; {
+		next := el.Next()
+		if e := el.Value.(*entry[K, V]); del(e.key, e.value) {
+			c.remove(el)
+			removed++
+		}
+		el = next
+	}
+	c.stats.Invalidations += int64(removed)
+	return removed
+}
+
+// Clear removes every entry
+func (c *Cache[K, V]) Clear() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.stats.Invalidations += int64(c.order.Len())
+	c.items = make(map[K]*list.Element, c.capacity)
+	c.order.Init()
+}
+
+// Prune removes the expired entries, which would otherwise only be removed once looked up
+// or evicted, returning how many were removed
+func (c *Cache[K, V]) Prune() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := time.Now()
+	removed := 0
+	for el := c.order.Front(); el != nil; {
+		next := el.Next()
+		if e := el.Value.(*entry[K, V]); !now.Before(e.expires) {
+			c.remove(el)
+			removed++
+		}
+		el = next
+	}
+	c.stats.Expirations += int64(removed)
+	return removed
+}
+
+// Stats returns a copy of the cache's counters
+func (c *Cache[K, V]) Stats() Stats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	stats := c.stats
+	stats.Entries = c.order.Len()
+	return stats
+}
+
+// remove drops an element from both the list and the map. The caller must hold the mutex.
+func (c *Cache[K, V]) remove(el *list.Element) {
+	c.order.Remove(el)
+	delete(c.items, el.Value.(*entry[K, V]).key)
+}
@@ -0,0 +1,153 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"io"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// maxReportedRejects caps the row errors kept by a load, so that a file which is entirely
+// wrong doesn't use up memory. Every rejected row is still counted.
+const maxReportedRejects = 100
+
+// LoadResult counts what happened to the rows of a bulk load
+type LoadResult struct {
+	Inserted   int64       `json:"inserted"`   // new snapshots
+	Updated    int64       `json:"updated"`    // snapshots already stored with a different total or URL
+	Unchanged  int64       `json:"unchanged"`  // snapshots already stored as they are
+	Duplicates int64       `json:"duplicates"` // rows superseded by a later row for the same snapshot
+	Rejected   int64       `json:"rejected"`   // rows which could not be parsed or were invalid
+	Errors     []*RowError `json:"-"`          // the first rejected rows, in order
+}
+
+// SnapshotLoader bulk loads job snapshots into PostgreSQL, for backfills too large to go
+// through VendorModel.Insert one row at a time. A snapshot is identified by its vendor,
+// country and day of creation, like the daily snapshots taken by the scrapers.
+type SnapshotLoader struct {
+	DB *sql.DB
+}
+
+// Load streams every valid snapshot from sr into a temporary staging table with COPY, then
+// merges the staging table into jobs with a single statement. Within the input the last
+// row for a snapshot wins. Snapshots which are already stored are updated, bumping their
+// version, if their total or URL changed. Invalid rows are counted and skipped.
+//
+// Everything happens in one transaction, so nothing is loaded if the input can't be read
+// to the end or ctx is cancelled. Rows without a creation time are stamped with the time
+// the load began. Snapshots are matched on their UTC day, like the rollups, rather than
+// on the day in the session's time zone.
+func (l SnapshotLoader) Load(ctx context.Context, sr *SnapshotReader) (result LoadResult, err error) {
+	query := `
+		WITH staged AS (
+			SELECT DISTINCT ON (vendor, country, day) vendor, country, amount, url, created_at, day
+			FROM (SELECT *, (created_at AT TIME ZONE 'UTC')::date AS day FROM jobs_staging) rows
+			ORDER BY vendor, country, day, line DESC
+		), updated AS (
+			UPDATE jobs j
+			SET amount = s.amount, url = s.url, version = j.version + 1
+			FROM staged s
+			WHERE j.vendor = s.vendor AND j.country = s.country
+			AND j.created_at >= s.day::timestamp AT TIME ZONE 'UTC' AND j.created_at < (s.day + 1)::timestamp AT TIME ZONE 'UTC'
+			AND (j.amount <> s.amount OR j.url <> s.url)
+			RETURNING j.id
+		), inserted AS (
+			INSERT INTO jobs (vendor, country, amount, url, created_at)
+			SELECT s.vendor, s.country, s.amount, s.url, s.created_at
+			FROM staged s
+			WHERE NOT EXISTS (
+				SELECT 1 FROM jobs j
+				WHERE j.vendor = s.vendor AND j.country = s.country
+				AND j.created_at >= s.day::timestamp AT TIME ZONE 'UTC' AND j.created_at < (s.day + 1)::timestamp AT TIME ZONE 'UTC'
+			)
+			RETURNING id
+		)
+		SELECT (SELECT count(*) FROM jobs_staging), (SELECT count(*) FROM staged),
+			(SELECT count(*) FROM updated), (SELECT count(*) FROM inserted)`
+
+	ctx, span := startSpan(ctx, "SnapshotLoader.Load", query)
+	defer span.End()
+
+	err = runTx(ctx, l.DB, "postgresql", func(tx *sql.Tx) error {
+		_, err := tx.ExecContext(ctx, `
+			CREATE TEMPORARY TABLE jobs_staging (
+				line bigint NOT NULL,
+				vendor text NOT NULL,
+				country text NOT NULL,
+				amount integer NOT NULL,
+				url text NOT NULL,
+				created_at timestamp(0) with time zone NOT NULL
+			) ON COMMIT DROP`)
+		if err != nil {
+			return err
+		}
+
+		if err := l.copyRows(ctx, tx, sr, &result); err != nil {
+			return err
+		}
+
+		// the planner knows nothing about a freshly filled temporary table
+		if _, err := tx.ExecContext(ctx, `ANALYZE jobs_staging`); err != nil {
+			return err
+		}
+
+		var staging, staged int64
+		if err := tx.QueryRowContext(ctx, query).Scan(&staging, &staged, &result.Updated, &result.Inserted); err != nil {
+			return err
+		}
+		result.Duplicates = staging - staged
+		result.Unchanged = staged - result.Updated - result.Inserted
+		return nil
+	})
+	if err != nil {
+		return LoadResult{Rejected: result.Rejected, Errors: result.Errors}, spanError(ctx, span, err)
+	}
+
+	spanRows(span, result.Inserted+result.Updated)
+	return result, nil
+}
+
+// copyRows sends the valid rows of sr to the staging table, recording the rejected ones
+func (l SnapshotLoader) copyRows(ctx context.Context, tx *sql.Tx, sr *SnapshotReader, result *LoadResult) error {
+	stmt, err := tx.PrepareContext(ctx, pq.CopyIn("jobs_staging", "line", "vendor", "country", "amount", "url", "created_at"))
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+
+	now := time.Now()
+	for {
+		c, err := sr.Read()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		var rowErr *RowError
+		if errors.As(err, &rowErr) {
+			result.Rejected++
+			if len(result.Errors) < maxReportedRejects {
+				result.Errors = append(result.Errors, rowErr)
+			}
+			continue
+		}
+		if err != nil {
+			return err
+		}
+
+		created := now
+		if c.CreatedAt != nil {
+			created = *c.CreatedAt
+		}
+		if _, err := stmt.ExecContext(ctx, sr.Line(), c.Name, c.Country, c.Total, c.URL, created); err != nil {
+			return err
+		}
+	}
+
+	// an Exec without arguments flushes the buffered rows and ends the COPY
+	if _, err := stmt.ExecContext(ctx); err != nil {
+		return err
+	}
+	return stmt.Close()
+}
@@ -6,17 +6,22 @@ import (
 	"errors"
 	"fmt"
 	"github.com/sparkycj328/JobAIO-API/internal/validator"
+	"go.opentelemetry.io/otel/trace"
 	"time"
 )
 
 type Company struct {
-	ID        int64      `json:"id"`                // Unique integer id for the company
-	Name      string     `json:"company"`           // company name
-	Country   string     `json:"country"`           // Country name
-	Total     int        `json:"total"`             // total amount of job available
-	URL       string     `json:"url"`               // URL location where resource is located
-	Version   int32      `json:"version"`           // updated each time a record is updated
-	CreatedAt *time.Time `json:"created,omitempty"` // created timestamp for the data
+	ID        int64      `json:"id" xml:"id"`                               // Unique integer id for the company
+	Name      string     `json:"company" xml:"company"`                     // company name
+	Country   string     `json:"country" xml:"country"`                     // Country name
+	Total     int        `json:"total" xml:"total"`                         // total amount of job available
+	URL       string     `json:"url" xml:"url"`                             // URL location where resource is located
+	Version   int32      `json:"version" xml:"version"`                     // updated each time a record is updated
+	CreatedAt *time.Time `json:"created,omitempty" xml:"created,omitempty"` // created timestamp for the data
+	// Rollup is only set when listing a day whose snapshots have been purged, in which case
+	// the company stands for the rollup of that week: it has no id, CreatedAt is the start
+	// of the week and Total the amount of its latest snapshot
+	Rollup *RollupStats `json:"rollup,omitempty" xml:"rollup,omitempty"`
 }
 
 // ValidateCompany will perform validation checks on each field of the given Company struct
@@ -30,30 +35,41 @@ func ValidateCompany(v *validator.Validator, c *Company) {
 	v.Check(len(c.URL) <= 100, "url", "must not be more than 200 bytes long")
 }
 
-// VendorModel wraps the sql.DB connection pool in a struct
+// VendorModel wraps the sql.DB connection pool in a struct. GetRecord, GetAllRows and
+// GetRows run on the Replica, if there is one, while it is healthy.
 type VendorModel struct {
-	DB *sql.DB
+	DB       Queryer
+	Replica  *Replica
+	Timeouts Timeouts
 }
 
 // Insert will take the company struct and insert the data into our database
-// acts as our POST endpoint
-func (m *VendorModel) Insert(c *Company) error {
+// acts as our POST endpoint. If CreatedAt is set it is kept, which allows historical
+// snapshots to be imported, otherwise the current time is used.
+func (m *VendorModel) Insert(ctx context.Context, c *Company) error {
 	query := `
-			INSERT INTO jobs (vendor, country, amount, url)
-			VALUES ($1, $2, $3, $4)
+			INSERT INTO jobs (vendor, country, amount, url, created_at)
+			VALUES ($1, $2, $3, $4, COALESCE($5, NOW()))
 			RETURNING id, created_at, version`
-	args := []any{c.Name, c.Country, c.Total, c.URL}
+	args := []any{c.Name, c.Country, c.Total, c.URL, c.CreatedAt}
 
-	// Create a context with a 3-second timeout.
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	ctx, span := startSpan(ctx, "VendorModel.Insert", query)
+	defer span.End()
+
+	// Create a context with the timeout configured for this operation.
+	ctx, cancel := context.WithTimeout(ctx, m.Timeouts.For("VendorModel.Insert"))
 	defer cancel()
 
-	return m.DB.QueryRowContext(ctx, query, args...).Scan(&c.ID, &c.CreatedAt, &c.Version)
+	if err := m.DB.QueryRowContext(ctx, query, args...).Scan(&c.ID, &c.CreatedAt, &c.Version); err != nil {
+		return spanError(ctx, span, err)
+	}
+	spanRows(span, 1)
+	return nil
 }
 
 // GetRecord queries our jobs table for an individual row
 // this row is called using the id parameter from the URL request
-func (m *VendorModel) GetRecord(id int64) (*Company, error) {
+func (m *VendorModel) GetRecord(ctx context.Context, id int64) (*Company, error) {
 
 	// one last validation check
 	if id < 1 {
@@ -62,26 +78,32 @@ func (m *VendorModel) GetRecord(id int64) (*Company, error) {
 
 	// build the single query
 	query := `
-			SELECT id, created_at, vendor, country, amount, url
+			SELECT id, created_at, vendor, country, amount, url, version
 			FROM jobs
 			WHERE id = $1`
 
 	var record Company
 
-	// Create a context with a 3-second timeout.
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	ctx, span := startSpan(ctx, "VendorModel.GetRecord", query)
+	defer span.End()
+
+	// Create a context with the timeout configured for this operation.
+	ctx, cancel := context.WithTimeout(ctx, m.Timeouts.For("VendorModel.GetRecord"))
 	defer cancel()
 
 	// query for the matching id and based on type of error
 	// return our ErrRecordNotFound error or return other error
-	if err := m.DB.QueryRowContext(ctx, query, id).Scan(
+	db := m.Replica.reader(ctx, span, m.DB)
+	if err := db.QueryRowContext(ctx, query, id).Scan(
 		&record.ID,
 		&record.CreatedAt,
 		&record.Name,
 		&record.Country,
 		&record.Total,
 		&record.URL,
+		&record.Version,
 	); err != nil {
+		err = spanError(ctx, span, err)
 		switch {
 		case errors.Is(err, sql.ErrNoRows):
 			return nil, ErrRecordNotFound
@@ -89,11 +111,15 @@ func (m *VendorModel) GetRecord(id int64) (*Company, error) {
 			return nil, err
 		}
 	}
+	spanRows(span, 1)
 	return &record, nil
 }
 
-// GetAllRows will be used to grab all rows from the jobs table
-func (m *VendorModel) GetAllRows(vendor string, total int, created time.Time, filters Filters) ([]*Company, Metadata, error) {
+// GetAllRows will be used to grab all rows from the jobs table. The day is matched as a
+// range of created_at, rather than by casting created_at to a date, so that only the
+// partition holding that day is scanned. Days whose snapshots have been purged are answered
+// with the rollups of their week instead.
+func (m *VendorModel) GetAllRows(ctx context.Context, vendor string, total int, created time.Time, filters Filters) ([]*Company, Metadata, error) {
 	// define a slice of company struct which will
 	// be used to store the rows queried and a nil value for totalRecords
 	totalRecords := 0
@@ -101,23 +127,38 @@ func (m *VendorModel) GetAllRows(vendor string, total int, created time.Time, fi
 
 	// define the SQL statement
 	query := fmt.Sprintf(`
-		SELECT count(*) OVER(), id, created_at, vendor, country, amount, url, version
-		FROM jobs
+		SELECT count(*) OVER(), id, created_at, vendor, country, amount, url, version,
+			period, min_amount, max_amount, avg_amount, samples
+		FROM (
+			SELECT id, created_at, vendor, country, amount, url, version,
+				NULL AS period, NULL::integer AS min_amount, NULL::integer AS max_amount,
+				NULL::double precision AS avg_amount, NULL::integer AS samples
+			FROM jobs
+			WHERE created_at >= $3::date AND created_at < $3::date + 1
+			UNION ALL
+			SELECT 0, period_start::timestamp AT TIME ZONE 'UTC', vendor, country, last_amount, url, 0,
+				'week', min_amount, max_amount, avg_amount, samples
+			FROM jobs_rollup_weekly
+			WHERE period_start = date_trunc('week', $3::date::timestamp)::date
+			AND $3::date < (SELECT purged_before FROM jobs_rollup_state)
+		) AS j
 		WHERE (to_tsvector('simple', vendor) @@ plainto_tsquery('simple', $1) OR $1 = '')
 		AND (amount > $2)
-		AND created_at::date = $3
 		ORDER BY %s %s, id ASC
 		LIMIT $4 OFFSET $5`, filters.sortColumn(), filters.sortDirection())
 
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	ctx, span := startSpan(ctx, "VendorModel.GetAllRows", query)
+	defer span.End()
+
+	ctx, cancel := context.WithTimeout(ctx, m.Timeouts.For("VendorModel.GetAllRows"))
 	defer cancel()
 
 	// place arguments into a slice as they amount is increasing
 	args := []any{vendor, total, created, filters.limit(), filters.offset()}
 
-	rows, err := m.DB.QueryContext(ctx, query, args...)
+	rows, err := m.Replica.reader(ctx, span, m.DB).QueryContext(ctx, query, args...)
 	if err != nil {
-		return nil, Metadata{}, err
+		return nil, Metadata{}, spanError(ctx, span, err)
 	}
 	defer rows.Close()
 
@@ -125,10 +166,11 @@ func (m *VendorModel) GetAllRows(vendor string, total int, created time.Time, fi
 	for rows.Next() {
 		// declare a local instance of our company struct
 		var country Company
+		var rollup listedRollup
 
 		// scan the individual record values for the current row into our local struct
 		// based on type of error, return different error messages
-		if err := rows.Scan(
+		dest := []any{
 			&totalRecords,
 			&country.ID,
 			&country.CreatedAt,
@@ -137,16 +179,19 @@ func (m *VendorModel) GetAllRows(vendor string, total int, created time.Time, fi
 			&country.Total,
 			&country.URL,
 			&country.Version,
-		); err != nil {
-			return nil, Metadata{}, err
 		}
+		if err := rows.Scan(append(dest, rollup.dest()...)...); err != nil {
+			return nil, Metadata{}, spanError(ctx, span, err)
+		}
+		country.Rollup = rollup.stats(&country)
 		// append the filled struct to our slice of rows queried.
 		jobs = append(jobs, &country)
 	}
 
 	if err = rows.Err(); err != nil {
-		return nil, Metadata{}, err
+		return nil, Metadata{}, spanError(ctx, span, err)
 	}
+	spanRows(span, int64(len(jobs)))
 
 	// Generate the metadata struct
 	metadata := calculateMetadata(totalRecords, filters.Page, filters.PageSize)
@@ -154,7 +199,7 @@ func (m *VendorModel) GetAllRows(vendor string, total int, created time.Time, fi
 }
 
 // GetRows will for fetching specific records from the jobs table
-func (m *VendorModel) GetRows(vendor string) ([]*Company, error) {
+func (m *VendorModel) GetRows(ctx context.Context, vendor string) ([]*Company, error) {
 	// if vendor string is empty return an error
 	if vendor == "" {
 		return nil, ErrRecordNotFound
@@ -166,15 +211,19 @@ func (m *VendorModel) GetRows(vendor string) ([]*Company, error) {
 	// define the SQL statement
 	query := `SELECT id, created_at, country, amount, url, version
 		  		FROM jobs
-				WHERE vendor = $1 AND created_at::date = CURRENT_DATE AND amount > 0 ORDER BY country`
+				WHERE vendor = $1 AND created_at >= CURRENT_DATE AND created_at < CURRENT_DATE + 1
+				AND amount > 0 ORDER BY country`
+
+	ctx, span := startSpan(ctx, "VendorModel.GetRows", query)
+	defer span.End()
 
 	//
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	ctx, cancel := context.WithTimeout(ctx, m.Timeouts.For("VendorModel.GetRows"))
 	defer cancel()
 
-	rows, err := m.DB.QueryContext(ctx, query, vendor)
+	rows, err := m.Replica.reader(ctx, span, m.DB).QueryContext(ctx, query, vendor)
 	if err != nil {
-		return nil, err
+		return nil, spanError(ctx, span, err)
 	}
 	defer rows.Close()
 
@@ -193,15 +242,16 @@ func (m *VendorModel) GetRows(vendor string) ([]*Company, error) {
 			&country.URL,
 			&country.Version,
 		); err != nil {
-			return nil, err
+			return nil, spanError(ctx, span, err)
 		}
 		// append the filled struct to our slice of rows queried.
 		countries = append(countries, &country)
 	}
 
 	if err = rows.Err(); err != nil {
-		return nil, err
+		return nil, spanError(ctx, span, err)
 	}
+	spanRows(span, int64(len(countries)))
 
 	if len(countries) == 0 {
 		return nil, ErrRecordNotFound
@@ -210,7 +260,7 @@ func (m *VendorModel) GetRows(vendor string) ([]*Company, error) {
 }
 
 // Update will update the specified records in the job table
-func (m *VendorModel) Update(c *Company) error {
+func (m *VendorModel) Update(ctx context.Context, c *Company) error {
 	// create the prepared statement
 	query := `
 			UPDATE jobs
@@ -227,12 +277,16 @@ func (m *VendorModel) Update(c *Company) error {
 		c.Version,
 	}
 
-	// Create a context with a 3-second timeout.
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	ctx, span := startSpan(ctx, "VendorModel.Update", query)
+	defer span.End()
+
+	// Create a context with the timeout configured for this operation.
+	ctx, cancel := context.WithTimeout(ctx, m.Timeouts.For("VendorModel.Update"))
 	defer cancel()
 
 	// execute the query in our jobs table
 	if err := m.DB.QueryRowContext(ctx, query, args...).Scan(&c.Version); err != nil {
+		err = spanError(ctx, span, err)
 		switch {
 		case errors.Is(err, sql.ErrNoRows):
 			return ErrEditConflict
@@ -240,36 +294,57 @@ func (m *VendorModel) Update(c *Company) error {
 			return err
 		}
 	}
+	spanRows(span, 1)
 	return nil
 }
 
 // Delete will delete a record from our jobs table
-// if the matching record exists
-func (m *VendorModel) Delete(id int64) error {
+// if the matching record exists at the given version
+func (m *VendorModel) Delete(ctx context.Context, id int64, version int32) error {
 	if id < 1 {
 		return ErrRecordNotFound
 	}
 	// Create the prepared statement
 	query := `
 			DELETE FROM jobs
-			WHERE id  = $1
+			WHERE id  = $1 AND version = $2
 `
-	// Create a context with a 3-second timeout.
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	ctx, span := startSpan(ctx, "VendorModel.Delete", query)
+	defer span.End()
+
+	// Create a context with the timeout configured for this operation.
+	ctx, cancel := context.WithTimeout(ctx, m.Timeouts.For("VendorModel.Delete"))
 	defer cancel()
 
 	// we are using DB.EXEC due to not wanting any rows returned
-	result, err := m.DB.ExecContext(ctx, query, id)
+	result, err := m.DB.ExecContext(ctx, query, id, version)
 	if err != nil {
-		return err
+		return spanError(ctx, span, err)
 	}
 	rows, err := result.RowsAffected()
 	if err != nil {
-		return err
+		return spanError(ctx, span, err)
 	}
+	spanRows(span, rows)
 	if rows == 0 {
-		return ErrRecordNotFound
+		return missingOrStale(ctx, span, m.DB, id)
 	}
 
 	return nil
 }
+
+// missingOrStale tells why a delete of the given version of a record affected no rows:
+// ErrRecordNotFound if the record has been deleted, or ErrEditConflict if it has been
+// updated since it was read. It works on both Postgres and SQLite.
+func missingOrStale(ctx context.Context, span trace.Span, db Queryer, id int64) error {
+	var exists bool
+	err := db.QueryRowContext(ctx, `SELECT EXISTS (SELECT 1 FROM jobs WHERE id = $1)`, id).Scan(&exists)
+	switch {
+	case err != nil:
+		return spanError(ctx, span, err)
+	case !exists:
+		return ErrRecordNotFound
+	default:
+		return ErrEditConflict
+	}
+}
@@ -57,11 +57,11 @@ func ValidateFilters(v *validator.Validator, f Filters) {
 
 // Define a new Metadata struct for holding the pagination metadata.
 type Metadata struct {
-	CurrentPage  int `json:"current_page,omitempty"`
-	PageSize     int `json:"page_size,omitempty"`
-	FirstPage    int `json:"first_page,omitempty"`
-	LastPage     int `json:"last_page,omitempty"`
-	TotalRecords int `json:"total_records,omitempty"`
+	CurrentPage  int `json:"current_page,omitempty" xml:"current_page,omitempty"`
+	PageSize     int `json:"page_size,omitempty" xml:"page_size,omitempty"`
+	FirstPage    int `json:"first_page,omitempty" xml:"first_page,omitempty"`
+	LastPage     int `json:"last_page,omitempty" xml:"last_page,omitempty"`
+	TotalRecords int `json:"total_records,omitempty" xml:"total_records,omitempty"`
 }
 
 // The calculateMetadata() function calculates the appropriate pagination metadata
@@ -83,3 +83,10 @@ func calculateMetadata(totalRecords, page, pageSize int) Metadata {
 		TotalRecords: totalRecords,
 	}
 }
+
+// MatchesSearch reports whether the vendor search of GetAllRows finds the named vendor,
+// which it does when the name contains every word of the search. An empty search finds
+// every vendor.
+func MatchesSearch(search, vendor string) bool {
+	return matchesTerms(vendor, searchTerms(search))
+}
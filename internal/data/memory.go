@@ -0,0 +1,893 @@
+package data
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+)
+
+// memoryStore holds every record of the in-memory stores behind a single mutex, so that
+// operations spanning several kinds of record (such as checking a token's user exists)
+// see a consistent state
+type memoryStore struct {
+	mu sync.RWMutex
+
+	jobs      map[int64]Company
+	lastJobID int64
+
+	users      map[int64]User
+	lastUserID int64
+
+	tokens      []Token
+	permissions Permissions
+	granted     map[int64]map[string]bool
+
+	// rollups holds the rollups of each period, which reach up to rolledThrough, and the
+	// raw snapshots taken before purgedBefore have been deleted, as in jobs_rollup_state.
+	// The daily rollups the others are made from are kept apart, along with the days which
+	// changed since they were rolled up.
+	rollups       map[string]map[rollupKey]Rollup
+	daily         map[rollupKey]rollupSummary
+	dirty         map[time.Time]bool
+	rolledThrough map[string]time.Time
+	purgedBefore  time.Time
+}
+
+// rollupKey identifies the rollup of a vendor and country over the period starting at start
+type rollupKey struct {
+	vendor, country string
+	start           time.Time
+}
+
+// NewMemoryModels returns Models backed by thread-safe in-memory stores. They behave like
+// the PostgreSQL models, including version conflicts, duplicate emails, filtering, sorting
+// and pagination, but lose everything when the process exits. They are meant for local
+// development and tests.
+func NewMemoryModels() Models {
+	s := &memoryStore{
+		jobs:    make(map[int64]Company),
+		users:   make(map[int64]User),
+		granted: make(map[int64]map[string]bool),
+		// the same permissions are seeded by the permissions migration
+		permissions: Permissions{PermissionAdmin, PermissionCompaniesRead, PermissionCompaniesWrite},
+		rollups: map[string]map[rollupKey]Rollup{
+			PeriodWeek:  make(map[rollupKey]Rollup),
+			PeriodMonth: make(map[rollupKey]Rollup),
+		},
+		daily:         make(map[rollupKey]rollupSummary),
+		dirty:         make(map[time.Time]bool),
+		rolledThrough: make(map[string]time.Time),
+	}
+
+	m := memoryModels(s)
+	m.withTx = s.withTx
+	return m
+}
+
+// memoryModels returns the stores viewing s
+func memoryModels(s *memoryStore) Models {
+	return Models{
+		Vendors:     memoryVendors{s},
+		Users:       memoryUsers{s},
+		Tokens:      memoryTokens{s},
+		Permissions: memoryPermissions{s},
+		Rollups:     memoryRollups{s},
+	}
+}
+
+// withTx implements WithTx. fn works on a copy of the store, which replaces the store's
+// records if fn succeeds and is simply dropped otherwise. The write lock is held throughout,
+// so no other call can change the store in the meantime and be lost when the copy is
+// committed.
+func (s *memoryStore) withTx(ctx context.Context, fn func(tx Models) error) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	tx := s.clone()
+	if err := fn(joinTx(memoryModels(tx))); err != nil {
+		return err
+	}
+
+	s.jobs, s.lastJobID = tx.jobs, tx.lastJobID
+	s.users, s.lastUserID = tx.users, tx.lastUserID
+	s.tokens, s.permissions, s.granted = tx.tokens, tx.permissions, tx.granted
+	s.rollups, s.daily, s.dirty = tx.rollups, tx.daily, tx.dirty
+	s.rolledThrough, s.purgedBefore = tx.rolledThrough, tx.purgedBefore
+	return nil
+}
+
+// clone returns a copy of the store's records which can be changed without affecting the
+// store. Stored records are never modified in place, only replaced, so they can be shared.
+func (s *memoryStore) clone() *memoryStore {
+	c := &memoryStore{
+		jobs:        make(map[int64]Company, len(s.jobs)),
+		lastJobID:   s.lastJobID,
+		users:       make(map[int64]User, len(s.users)),
+		lastUserID:  s.lastUserID,
+		tokens:      append([]Token(nil), s.tokens...),
+		permissions: append(Permissions(nil), s.permissions...),
+		granted:     make(map[int64]map[string]bool, len(s.granted)),
+
+		rollups:       make(map[string]map[rollupKey]Rollup, len(s.rollups)),
+		daily:         make(map[rollupKey]rollupSummary, len(s.daily)),
+		dirty:         make(map[time.Time]bool, len(s.dirty)),
+		rolledThrough: make(map[string]time.Time, len(s.rolledThrough)),
+		purgedBefore:  s.purgedBefore,
+	}
+	for id, job := range s.jobs {
+		c.jobs[id] = job
+	}
+	for id, user := range s.users {
+		c.users[id] = user
+	}
+	for period, rollups := range s.rollups {
+		c.rollups[period] = make(map[rollupKey]Rollup, len(rollups))
+		for key, r := range rollups {
+			c.rollups[period][key] = r
+		}
+	}
+	for key, r := range s.daily {
+		c.daily[key] = r
+	}
+	for day := range s.dirty {
+		c.dirty[day] = true
+	}
+	for period, through := range s.rolledThrough {
+		c.rolledThrough[period] = through
+	}
+	for userID, codes := range s.granted {
+		c.granted[userID] = make(map[string]bool, len(codes))
+		for code := range codes {
+			c.granted[userID][code] = true
+		}
+	}
+	return c
+}
+
+// markDirty records that a snapshot created at created was written, as the
+// jobs_rollup_dirty triggers do: its day must be rolled up again if it already was. Deleting
+// a snapshot of a purged day leaves the day as it is. The caller must hold the mutex.
+func (s *memoryStore) markDirty(created time.Time, deleted bool) {
+	day := dayStart(created)
+	if !day.Before(s.rolledThrough[periodDay]) || deleted && day.Before(s.purgedBefore) {
+		return
+	}
+	s.dirty[day] = true
+}
+
+// timestamp rounds t to the whole seconds stored by the timestamp(0) columns, as Postgres does
+func timestamp(t time.Time) time.Time {
+	return t.Round(time.Second)
+}
+
+// memoryVendors implements VendorStore
+type memoryVendors struct {
+	s *memoryStore
+}
+
+func (m memoryVendors) Insert(ctx context.Context, c *Company) error {
+	m.s.mu.Lock()
+	defer m.s.mu.Unlock()
+
+	created := time.Now()
+	if c.CreatedAt != nil {
+		created = *c.CreatedAt
+	}
+	created = timestamp(created)
+
+	m.s.lastJobID++
+	c.ID = m.s.lastJobID
+	c.CreatedAt = &created
+	c.Version = 1
+
+	m.s.jobs[c.ID] = copyCompany(c)
+	m.s.markDirty(created, false)
+	return nil
+}
+
+func (m memoryVendors) GetRecord(ctx context.Context, id int64) (*Company, error) {
+	m.s.mu.RLock()
+	defer m.s.mu.RUnlock()
+
+	record, ok := m.s.jobs[id]
+	if !ok {
+		return nil, ErrRecordNotFound
+	}
+	c := copyCompany(&record)
+	return &c, nil
+}
+
+func (m memoryVendors) GetAllRows(ctx context.Context, vendor string, total int, created time.Time, filters Filters) ([]*Company, Metadata, error) {
+	m.s.mu.RLock()
+	defer m.s.mu.RUnlock()
+
+	// sortColumn panics on an unsafe sort value, just as it does for the SQL query
+	column, descending := filters.sortColumn(), filters.sortDirection() == "DESC"
+	day := created.Format("2006-01-02")
+	terms := searchTerms(vendor)
+
+	matches := []*Company{}
+	for _, record := range m.s.jobs {
+		if !matchesTerms(record.Name, terms) || record.Total <= total ||
+			record.CreatedAt.In(created.Location()).Format("2006-01-02") != day {
+			continue
+		}
+		c := copyCompany(&record)
+		matches = append(matches, &c)
+	}
+
+	// like the SQL query, a purged day is answered with the rollups of its week
+	if created.Before(m.s.purgedBefore) {
+		week := periodStart(PeriodWeek, created)
+		for key, r := range m.s.rollups[PeriodWeek] {
+			if key.start.Equal(week) && matchesTerms(r.Vendor, terms) && r.Last > total {
+				matches = append(matches, r.company())
+			}
+		}
+	}
+
+	sort.Slice(matches, func(i, j int) bool {
+		if cmp := compareColumn(matches[i], matches[j], column); cmp != 0 {
+			return (cmp < 0) != descending
+		}
+		return matches[i].ID < matches[j].ID
+	})
+
+	// like count(*) OVER() in the SQL query, the total is only known when the requested
+	// page contains at least one row
+	totalRecords := 0
+	start, end := filters.offset(), filters.offset()+filters.limit()
+	if start < len(matches) {
+		totalRecords = len(matches)
+	} else {
+		start = len(matches)
+	}
+	if end > len(matches) {
+		end = len(matches)
+	}
+
+	return matches[start:end], calculateMetadata(totalRecords, filters.Page, filters.PageSize), nil
+}
+
+func (m memoryVendors) GetRows(ctx context.Context, vendor string) ([]*Company, error) {
+	if vendor == "" {
+		return nil, ErrRecordNotFound
+	}
+
+	m.s.mu.RLock()
+	defer m.s.mu.RUnlock()
+
+	today := time.Now().Format("2006-01-02")
+	countries := make([]*Company, 0)
+	for _, record := range m.s.jobs {
+		if record.Name != vendor || record.Total <= 0 || record.CreatedAt.Local().Format("2006-01-02") != today {
+			continue
+		}
+		// the SQL query doesn't select the vendor name, so leave it out here too
+		c := copyCompany(&record)
+		c.Name = ""
+		countries = append(countries, &c)
+	}
+
+	if len(countries) == 0 {
+		return nil, ErrRecordNotFound
+	}
+
+	sort.SliceStable(countries, func(i, j int) bool {
+		return countries[i].Country < countries[j].Country
+	})
+	return countries, nil
+}
+
+func (m memoryVendors) Update(ctx context.Context, c *Company) error {
+	m.s.mu.Lock()
+	defer m.s.mu.Unlock()
+
+	record, ok := m.s.jobs[c.ID]
+	if !ok || record.Version != c.Version {
+		return ErrEditConflict
+	}
+
+	record.Name, record.Country, record.Total, record.URL = c.Name, c.Country, c.Total, c.URL
+	record.Version++
+	m.s.jobs[c.ID] = record
+	m.s.markDirty(*record.CreatedAt, false)
+
+	c.Version = record.Version
+	return nil
+}
+
+func (m memoryVendors) Delete(ctx context.Context, id int64, version int32) error {
+	m.s.mu.Lock()
+	defer m.s.mu.Unlock()
+
+	record, ok := m.s.jobs[id]
+	if !ok {
+		return ErrRecordNotFound
+	}
+	if record.Version != version {
+		return ErrEditConflict
+	}
+	delete(m.s.jobs, id)
+	m.s.markDirty(*record.CreatedAt, true)
+	return nil
+}
+
+// copyCompany returns a copy of c which shares no memory with it
+func copyCompany(c *Company) Company {
+	copied := *c
+	if c.CreatedAt != nil {
+		created := *c.CreatedAt
+		copied.CreatedAt = &created
+	}
+	return copied
+}
+
+// searchTerms splits a search into the lower-case words it contains, which is how
+// plainto_tsquery with the simple configuration parses it
+func searchTerms(search string) []string {
+	return strings.FieldsFunc(strings.ToLower(search), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+}
+
+// matchesTerms reports whether name contains every one of the search terms as a whole word.
+// An empty search matches every name.
+func matchesTerms(name string, terms []string) bool {
+	words := make(map[string]bool)
+	for _, word := range searchTerms(name) {
+		words[word] = true
+	}
+	for _, term := range terms {
+		if !words[term] {
+			return false
+		}
+	}
+	return true
+}
+
+// compareColumn compares two companies on one of the sortable columns, returning a negative
+// number, zero or a positive number like strings.Compare
+func compareColumn(a, b *Company, column string) int {
+	switch column {
+	case "id":
+		return compareInts(a.ID, b.ID)
+	case "vendor":
+		return strings.Compare(a.Name, b.Name)
+	case "country":
+		return strings.Compare(a.Country, b.Country)
+	case "amount":
+		return compareInts(int64(a.Total), int64(b.Total))
+	case "created_at":
+		return a.CreatedAt.Compare(*b.CreatedAt)
+	}
+	return 0
+}
+
+func compareInts(a, b int64) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+	return 0
+}
+
+// memoryUsers implements UserStore. Email addresses are compared case-insensitively, like
+// the citext column in PostgreSQL.
+type memoryUsers struct {
+	s *memoryStore
+}
+
+// emailTaken reports whether another user already has the email address. The caller must
+// hold the mutex.
+func (m memoryUsers) emailTaken(email string, exceptID int64) bool {
+	for id, user := range m.s.users {
+		if id != exceptID && strings.EqualFold(user.Email, email) {
+			return true
+		}
+	}
+	return false
+}
+
+func (m memoryUsers) Insert(ctx context.Context, user *User) error {
+	m.s.mu.Lock()
+	defer m.s.mu.Unlock()
+
+	if m.emailTaken(user.Email, 0) {
+		return ErrDuplicateEmail
+	}
+
+	m.s.lastUserID++
+	user.ID = m.s.lastUserID
+	user.CreatedAt = timestamp(time.Now())
+	user.Version = 1
+
+	m.s.users[user.ID] = copyUser(user)
+	return nil
+}
+
+func (m memoryUsers) GetByEmail(ctx context.Context, email string) (*User, error) {
+	m.s.mu.RLock()
+	defer m.s.mu.RUnlock()
+
+	for _, user := range m.s.users {
+		if strings.EqualFold(user.Email, email) {
+			u := copyUser(&user)
+			return &u, nil
+		}
+	}
+	return nil, ErrRecordNotFound
+}
+
+func (m memoryUsers) Update(ctx context.Context, user *User) error {
+	m.s.mu.Lock()
+	defer m.s.mu.Unlock()
+
+	if m.emailTaken(user.Email, user.ID) {
+		return ErrDuplicateEmail
+	}
+
+	record, ok := m.s.users[user.ID]
+	if !ok || record.Version != user.Version {
+		return ErrEditConflict
+	}
+
+	user.Version++
+	record = copyUser(user)
+	m.s.users[user.ID] = record
+	return nil
+}
+
+func (m memoryUsers) GetAllInactive(ctx context.Context) ([]*User, error) {
+	m.s.mu.RLock()
+	defer m.s.mu.RUnlock()
+
+	users := []*User{}
+	for _, user := range m.s.users {
+		if !user.Activated {
+			u := copyUser(&user)
+			users = append(users, &u)
+		}
+	}
+	sort.Slice(users, func(i, j int) bool {
+		return users[i].ID < users[j].ID
+	})
+	return users, nil
+}
+
+// copyUser returns a copy of user which shares no memory with it. The plaintext password
+// is dropped, as it is never stored in the database either.
+func copyUser(user *User) User {
+	copied := *user
+	copied.Password = password{hash: bytes.Clone(user.Password.hash)}
+	return copied
+}
+
+// memoryTokens implements TokenStore
+type memoryTokens struct {
+	s *memoryStore
+}
+
+func (m memoryTokens) New(ctx context.Context, userID int64, ttl time.Duration, scope string) (*Token, error) {
+	token, err := generateToken(userID, ttl, scope)
+	if err != nil {
+		return nil, err
+	}
+
+	err = m.Insert(ctx, token)
+	return token, err
+}
+
+func (m memoryTokens) Insert(ctx context.Context, token *Token) error {
+	m.s.mu.Lock()
+	defer m.s.mu.Unlock()
+
+	// mirror the foreign key and primary key constraints of the tokens table
+	if _, ok := m.s.users[token.UserID]; !ok {
+		return fmt.Errorf("token user %d does not exist", token.UserID)
+	}
+	for _, existing := range m.s.tokens {
+		if bytes.Equal(existing.Hash, token.Hash) {
+			return fmt.Errorf("duplicate token hash")
+		}
+	}
+
+	stored := *token
+	stored.Plaintext = ""
+	stored.Hash = bytes.Clone(token.Hash)
+	stored.Expiry = timestamp(token.Expiry)
+	m.s.tokens = append(m.s.tokens, stored)
+	return nil
+}
+
+// deleteTokens removes every token for which remove returns true and returns how many were
+// removed. The caller must hold the mutex.
+func (m memoryTokens) deleteTokens(remove func(t Token) bool) int64 {
+	kept := m.s.tokens[:0]
+	for _, t := range m.s.tokens {
+		if !remove(t) {
+			kept = append(kept, t)
+		}
+	}
+	removed := int64(len(m.s.tokens) - len(kept))
+	m.s.tokens = kept
+	return removed
+}
+
+func (m memoryTokens) DeleteAllForUser(ctx context.Context, scope string, userID int64) error {
+	m.s.mu.Lock()
+	defer m.s.mu.Unlock()
+
+	m.deleteTokens(func(t Token) bool {
+		return t.Scope == scope && t.UserID == userID
+	})
+	return nil
+}
+
+func (m memoryTokens) DeleteExpired(ctx context.Context) (int64, error) {
+	m.s.mu.Lock()
+	defer m.s.mu.Unlock()
+
+	now := time.Now()
+	return m.deleteTokens(func(t Token) bool {
+		return t.Expiry.Before(now)
+	}), nil
+}
+
+func (m memoryTokens) CountExpired(ctx context.Context) (int64, error) {
+	m.s.mu.RLock()
+	defer m.s.mu.RUnlock()
+
+	var count int64
+	now := time.Now()
+	for _, t := range m.s.tokens {
+		if t.Expiry.Before(now) {
+			count++
+		}
+	}
+	return count, nil
+}
+
+// memoryPermissions implements PermissionStore
+type memoryPermissions struct {
+	s *memoryStore
+}
+
+func (m memoryPermissions) GetAll(ctx context.Context) (Permissions, error) {
+	m.s.mu.RLock()
+	defer m.s.mu.RUnlock()
+
+	return append(Permissions(nil), m.s.permissions...), nil
+}
+
+func (m memoryPermissions) GetAllForUser(ctx context.Context, userID int64) (Permissions, error) {
+	m.s.mu.RLock()
+	defer m.s.mu.RUnlock()
+
+	var permissions Permissions
+	for _, code := range m.s.permissions {
+		if m.s.granted[userID][code] {
+			permissions = append(permissions, code)
+		}
+	}
+	return permissions, nil
+}
+
+func (m memoryPermissions) AddForUser(ctx context.Context, userID int64, codes ...string) error {
+	m.s.mu.Lock()
+	defer m.s.mu.Unlock()
+
+	if _, ok := m.s.users[userID]; !ok {
+		return fmt.Errorf("permission user %d does not exist", userID)
+	}
+
+	// unknown codes are skipped, as the SQL only inserts codes found in permissions
+	for _, code := range codes {
+		if !m.s.permissions.Include(code) {
+			continue
+		}
+		if m.s.granted[userID] == nil {
+			m.s.granted[userID] = make(map[string]bool)
+		}
+		m.s.granted[userID][code] = true
+	}
+	return nil
+}
+
+// memoryRollups implements RollupStore
+type memoryRollups struct {
+	s *memoryStore
+}
+
+func (m memoryRollups) Refresh(ctx context.Context, now time.Time) (int64, bool, error) {
+	m.s.mu.Lock()
+	defer m.s.mu.Unlock()
+
+	days, through := m.refreshDays(now)
+	if len(days) == 0 {
+		return 0, false, nil
+	}
+
+	var stored int64
+	for _, day := range days {
+		stored += m.rollupDay(day, day.Before(m.s.purgedBefore))
+	}
+	for _, p := range rollupPeriods {
+		for _, start := range periodStarts(p.name, days, through) {
+			stored += m.rollupPeriod(p.name, start)
+		}
+	}
+
+	m.s.rolledThrough[periodDay] = through
+	m.s.rolledThrough[PeriodWeek] = periodStart(PeriodWeek, through)
+	m.s.rolledThrough[PeriodMonth] = periodStart(PeriodMonth, through)
+	return stored, true, nil
+}
+
+// refreshDays returns the next batch of days to roll up, and the day before which every
+// day is rolled up once they are, like rollupMaintainer.refreshDays. The caller must hold
+// the mutex.
+func (m memoryRollups) refreshDays(now time.Time) ([]time.Time, time.Time) {
+	through := m.s.rolledThrough[periodDay]
+
+	var days []time.Time
+	for day := range m.s.dirty {
+		days = append(days, day)
+	}
+	if len(days) > 0 {
+		slices.SortFunc(days, time.Time.Compare)
+		return days[:min(len(days), rollupBatchDays)], through
+	}
+
+	from := through
+	if from.IsZero() {
+		for _, record := range m.s.jobs {
+			if day := dayStart(*record.CreatedAt); from.IsZero() || day.Before(from) {
+				from = day
+			}
+		}
+		if from.IsZero() {
+			return nil, through
+		}
+	}
+
+	for day := from; day.Before(dayStart(now)) && len(days) < rollupBatchDays; day = day.AddDate(0, 0, 1) {
+		days = append(days, day)
+	}
+	if len(days) == 0 {
+		return nil, through
+	}
+	return days, periodEnd(periodDay, days[len(days)-1])
+}
+
+// rollupDay stores the daily rollups of a day and clears its dirty mark, merging the
+// snapshots of a purged day into its rollups and purging them, like
+// rollupMaintainer.rollupDay. The caller must hold the mutex.
+func (m memoryRollups) rollupDay(day time.Time, purged bool) int64 {
+	end := periodEnd(periodDay, day)
+	inDay := func(c Company) bool {
+		return !c.CreatedAt.Before(day) && c.CreatedAt.Before(end)
+	}
+
+	if !purged {
+		for key := range m.s.daily {
+			if key.start.Equal(day) {
+				delete(m.s.daily, key)
+			}
+		}
+	}
+
+	var stored int64
+	for key, r := range m.summarise(periodDay, inDay) {
+		if existing, ok := m.s.daily[key]; ok {
+			existing.merge(r)
+			r = &existing
+		}
+		m.s.daily[key] = *r
+		stored++
+	}
+
+	if purged {
+		for id, record := range m.s.jobs {
+			if inDay(record) {
+				delete(m.s.jobs, id)
+			}
+		}
+	}
+	delete(m.s.dirty, day)
+	return stored
+}
+
+// rollupPeriod replaces the rollups of the period starting at start with the summary of
+// its daily rollups. The caller must hold the mutex.
+func (m memoryRollups) rollupPeriod(period string, start time.Time) int64 {
+	end := periodEnd(period, start)
+	for key := range m.s.rollups[period] {
+		if key.start.Equal(start) {
+			delete(m.s.rollups[period], key)
+		}
+	}
+
+	summaries := make(map[rollupKey]*rollupSummary)
+	for key, r := range m.s.daily {
+		if key.start.Before(start) || !key.start.Before(end) {
+			continue
+		}
+		key.start = start
+		if s, ok := summaries[key]; ok {
+			s.merge(&r)
+			continue
+		}
+		r.Period, r.Start, r.End = period, start, end
+		summaries[key] = &r
+	}
+
+	for key, s := range summaries {
+		m.s.rollups[period][key] = s.Rollup
+	}
+	return int64(len(summaries))
+}
+
+func (m memoryRollups) Purge(ctx context.Context, before time.Time) (int64, bool, error) {
+	m.s.mu.Lock()
+	defer m.s.mu.Unlock()
+
+	var dirty, oldest time.Time
+	for day := range m.s.dirty {
+		if !day.Before(m.s.purgedBefore) && (dirty.IsZero() || day.Before(dirty)) {
+			dirty = day
+		}
+	}
+	cutoff, ok := purgeCutoff(before, m.s.rolledThrough[PeriodWeek], m.s.rolledThrough[PeriodMonth], dirty, m.s.purgedBefore)
+	if !ok {
+		return 0, false, nil
+	}
+	for _, record := range m.s.jobs {
+		if oldest.IsZero() || record.CreatedAt.Before(oldest) {
+			oldest = *record.CreatedAt
+		}
+	}
+	start, end := purgeBatch(m.s.purgedBefore, oldest, cutoff)
+
+	var purged int64
+	for id, record := range m.s.jobs {
+		if !record.CreatedAt.Before(start) && record.CreatedAt.Before(end) {
+			delete(m.s.jobs, id)
+			purged++
+		}
+	}
+	m.s.purgedBefore = end
+	return purged, end.Before(cutoff), nil
+}
+
+func (m memoryRollups) History(ctx context.Context, vendor, country, period string, from, to time.Time) ([]*Rollup, error) {
+	if !ValidPeriod(period) {
+		return nil, fmt.Errorf("data: unknown rollup period %q", period)
+	}
+	from, to = historyRange(period, from, to)
+
+	m.s.mu.RLock()
+	defer m.s.mu.RUnlock()
+
+	matches := func(v, c string) bool {
+		return v == vendor && (country == "" || c == country)
+	}
+
+	// rolled up periods come from the stored rollups, the rest from the raw snapshots
+	through := m.s.rolledThrough[period]
+	rollups := []*Rollup{}
+	for key, r := range m.s.rollups[period] {
+		if matches(key.vendor, key.country) && !key.start.Before(from) && key.start.Before(to) && key.start.Before(through) {
+			rollups = append(rollups, &r)
+		}
+	}
+
+	rawFrom := from
+	if through.After(rawFrom) {
+		rawFrom = through
+	}
+	for _, r := range m.summarise(period, func(c Company) bool {
+		return matches(c.Name, c.Country) && !c.CreatedAt.Before(rawFrom) && c.CreatedAt.Before(to)
+	}) {
+		rollups = append(rollups, &r.Rollup)
+	}
+
+	sort.Slice(rollups, func(i, j int) bool {
+		if rollups[i].Country != rollups[j].Country {
+			return rollups[i].Country < rollups[j].Country
+		}
+		return rollups[i].Start.Before(rollups[j].Start)
+	})
+	return rollups, nil
+}
+
+// rollupSummary is a rollup along with what merging further snapshots into it needs, like
+// the rows of jobs_rollup_daily
+type rollupSummary struct {
+	Rollup
+	sum    int
+	lastAt time.Time
+}
+
+// merge adds the snapshots summarised by o to r, like dailyMerge
+func (r *rollupSummary) merge(o *rollupSummary) {
+	r.Min, r.Max = min(r.Min, o.Min), max(r.Max, o.Max)
+	r.sum += o.sum
+	r.Samples += o.Samples
+	r.Avg = float64(r.sum) / float64(r.Samples)
+	if !o.lastAt.Before(r.lastAt) {
+		r.Last, r.URL, r.lastAt = o.Last, o.URL, o.lastAt
+	}
+}
+
+// summarise rolls up the snapshots for which keep returns true over the named period, like
+// rollupSelect. The caller must hold the mutex.
+func (m memoryRollups) summarise(period string, keep func(c Company) bool) map[rollupKey]*rollupSummary {
+	type summary struct {
+		rollup *Rollup
+		sum    int
+		latest Company
+	}
+
+	summaries := make(map[rollupKey]*summary)
+	for _, record := range m.s.jobs {
+		if !keep(record) {
+			continue
+		}
+
+		key := rollupKey{record.Name, record.Country, periodStart(period, *record.CreatedAt)}
+		s, ok := summaries[key]
+		if !ok {
+			s = &summary{rollup: &Rollup{
+				Vendor:  record.Name,
+				Country: record.Country,
+				RollupStats: RollupStats{
+					Period: period,
+					Start:  key.start,
+					End:    periodEnd(period, key.start),
+					Min:    record.Total,
+					Max:    record.Total,
+				},
+			}, latest: record}
+			summaries[key] = s
+		}
+
+		r := s.rollup
+		r.Min, r.Max = min(r.Min, record.Total), max(r.Max, record.Total)
+		r.Samples++
+		s.sum += record.Total
+		if cmp := record.CreatedAt.Compare(*s.latest.CreatedAt); cmp > 0 || cmp == 0 && record.ID > s.latest.ID {
+			s.latest = record
+		}
+	}
+
+	rollups := make(map[rollupKey]*rollupSummary, len(summaries))
+	for key, s := range summaries {
+		s.rollup.Avg = float64(s.sum) / float64(s.rollup.Samples)
+		s.rollup.Last, s.rollup.URL = s.latest.Total, s.latest.URL
+		rollups[key] = &rollupSummary{Rollup: *s.rollup, sum: s.sum, lastAt: *s.latest.CreatedAt}
+	}
+	return rollups
+}
+
+// company returns the rollup as listed by GetAllRows for a purged day of its period
+func (r Rollup) company() *Company {
+	start := r.Start
+	stats := r.RollupStats
+	return &Company{
+		Name:      r.Vendor,
+		Country:   r.Country,
+		Total:     r.Last,
+		URL:       r.URL,
+		CreatedAt: &start,
+		Rollup:    &stats,
+	}
+}
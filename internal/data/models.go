@@ -1,8 +1,10 @@
 package data
 
 import (
+	"context"
 	"database/sql"
 	"errors"
+	"time"
 )
 
 // ErrRecordNotFound will be used by our Get function if an error occurs
@@ -12,18 +14,91 @@ var (
 	ErrEditConflict   = errors.New("edit conflict")
 )
 
-// Models wraps the VendorModel struct and will wrap other necessary structs in the future
+// VendorStore stores the job snapshots taken for each vendor
+type VendorStore interface {
+	Insert(ctx context.Context, c *Company) error
+	GetRecord(ctx context.Context, id int64) (*Company, error)
+	GetAllRows(ctx context.Context, vendor string, total int, created time.Time, filters Filters) ([]*Company, Metadata, error)
+	GetRows(ctx context.Context, vendor string) ([]*Company, error)
+	Update(ctx context.Context, c *Company) error
+	Delete(ctx context.Context, id int64, version int32) error
+}
+
+// UserStore stores user accounts
+type UserStore interface {
+	Insert(ctx context.Context, user *User) error
+	GetByEmail(ctx context.Context, email string) (*User, error)
+	Update(ctx context.Context, user *User) error
+	GetAllInactive(ctx context.Context) ([]*User, error)
+}
+
+// TokenStore stores the tokens issued to users
+type TokenStore interface {
+	New(ctx context.Context, userID int64, ttl time.Duration, scope string) (*Token, error)
+	Insert(ctx context.Context, token *Token) error
+	DeleteAllForUser(ctx context.Context, scope string, userID int64) error
+	DeleteExpired(ctx context.Context) (int64, error)
+	CountExpired(ctx context.Context) (int64, error)
+}
+
+// PermissionStore stores the permissions granted to users
+type PermissionStore interface {
+	GetAll(ctx context.Context) (Permissions, error)
+	GetAllForUser(ctx context.Context, userID int64) (Permissions, error)
+	AddForUser(ctx context.Context, userID int64, codes ...string) error
+}
+
+// RollupStore summarises old snapshots into weekly and monthly rollups, so that the raw
+// snapshots can be purged. Refresh and Purge each work through a batch of days, reporting
+// whether they should be called again, and should run through Models.WithTx so that each
+// batch commits on its own.
+type RollupStore interface {
+	Refresh(ctx context.Context, now time.Time) (int64, bool, error)
+	Purge(ctx context.Context, before time.Time) (int64, bool, error)
+	History(ctx context.Context, vendor, country, period string, from, to time.Time) ([]*Rollup, error)
+}
+
+// Models holds a store for each kind of record. Handlers only depend on the store
+// interfaces, so the PostgreSQL models can be swapped for the in-memory ones.
 type Models struct {
-	Vendors VendorModel
-	Users   UserModel
-	Tokens  TokenModel
+	Vendors     VendorStore
+	Users       UserStore
+	Tokens      TokenStore
+	Permissions PermissionStore
+	Rollups     RollupStore
+
+	// withTx implements WithTx for the storage backend the models were created for
+	withTx func(ctx context.Context, fn func(tx Models) error) error
+}
+
+// NewModel returns a Models struct containing the initialized PostgreSQL models, each of
+// which cancels its queries after the timeouts given
+func NewModel(db *sql.DB, timeouts Timeouts) Models {
+	return NewReplicatedModel(db, nil, timeouts)
+}
+
+// NewReplicatedModel returns the PostgreSQL models like NewModel, except that the read-only
+// vendor queries go to the replica while it is healthy. A nil replica sends everything to
+// db.
+func NewReplicatedModel(db *sql.DB, replica *Replica, timeouts Timeouts) Models {
+	m := postgresModels(db, replica, timeouts)
+	m.withTx = func(ctx context.Context, fn func(tx Models) error) error {
+		return runTx(ctx, db, "postgresql", func(tx *sql.Tx) error {
+			// reads inside the transaction must see its own writes, so they never go
+			// to the replica
+			return fn(joinTx(postgresModels(tx, nil, timeouts)))
+		})
+	}
+	return m
 }
 
-// NewModel returns a Models struct containing the initialized VendorModel and UsersModel
-func NewModel(db *sql.DB) Models {
+// postgresModels returns the PostgreSQL models running their queries on q
+func postgresModels(q Queryer, replica *Replica, timeouts Timeouts) Models {
 	return Models{
-		Vendors: VendorModel{DB: db},
-		Users:   UserModel{DB: db},
-		Tokens:  TokenModel{DB: db},
+		Vendors:     &VendorModel{DB: q, Replica: replica, Timeouts: timeouts},
+		Users:       UserModel{DB: q, Timeouts: timeouts},
+		Tokens:      TokenModel{DB: q, Timeouts: timeouts},
+		Permissions: PermissionModel{DB: q, Timeouts: timeouts},
+		Rollups:     RollupModel{DB: q, Replica: replica, Timeouts: timeouts},
 	}
 }
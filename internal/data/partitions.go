@@ -0,0 +1,232 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// partitionPrefix starts the name of every monthly partition of the jobs table, followed
+// by the year and month, as in jobs_p202403
+const partitionPrefix = "jobs_p"
+
+// Partition is a monthly partition of the PostgreSQL jobs table, holding the snapshots
+// created from From up to, but not including, To. Months are UTC calendar months.
+type Partition struct {
+	Name string
+	From time.Time
+	To   time.Time
+}
+
+// PartitionModel creates and removes the monthly partitions of the jobs table. Partitioning
+// only exists in PostgreSQL, so there is no equivalent for the other stores.
+type PartitionModel struct {
+	DB *sql.DB
+}
+
+// monthStart returns the first instant of the UTC month containing t
+func monthStart(t time.Time) time.Time {
+	t = t.UTC()
+	return time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, time.UTC)
+}
+
+// Create creates the partition for the month containing t, reporting whether it had to be
+// created. Snapshots of that month held by the default partition are moved into it.
+func (m PartitionModel) Create(ctx context.Context, t time.Time) (bool, error) {
+	query := `SELECT jobs_create_partition($1::date)`
+
+	ctx, span := startSpan(ctx, "PartitionModel.Create", query)
+	defer span.End()
+
+	var created bool
+	if err := m.DB.QueryRowContext(ctx, query, monthStart(t).Format("2006-01-02")).Scan(&created); err != nil {
+		return false, spanError(ctx, span, err)
+	}
+	return created, nil
+}
+
+// CreateAhead makes sure the partitions for the month containing now and the following
+// months exist, returning the names of the partitions it created
+func (m PartitionModel) CreateAhead(ctx context.Context, now time.Time, months int) ([]string, error) {
+	var created []string
+	for i := 0; i <= months; i++ {
+		month := monthStart(now).AddDate(0, i, 0)
+		ok, err := m.Create(ctx, month)
+		if err != nil {
+			return created, err
+		}
+		if ok {
+			created = append(created, partitionName(month))
+		}
+	}
+	return created, nil
+}
+
+// List returns the monthly partitions attached to the jobs table, oldest first. The default
+// partition is left out.
+func (m PartitionModel) List(ctx context.Context) ([]Partition, error) {
+	query := `
+		SELECT c.relname
+		FROM pg_inherits i
+		JOIN pg_class c ON c.oid = i.inhrelid
+		WHERE i.inhparent = 'jobs'::regclass`
+
+	ctx, span := startSpan(ctx, "PartitionModel.List", query)
+	defer span.End()
+
+	rows, err := m.DB.QueryContext(ctx, query)
+	if err != nil {
+		return nil, spanError(ctx, span, err)
+	}
+	defer rows.Close()
+
+	var partitions []Partition
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return nil, spanError(ctx, span, err)
+		}
+
+		// partitions are named after their month by jobs_create_partition
+		month, err := time.Parse("200601", strings.TrimPrefix(name, partitionPrefix))
+		if !strings.HasPrefix(name, partitionPrefix) || err != nil {
+			continue
+		}
+		partitions = append(partitions, Partition{Name: name, From: month, To: month.AddDate(0, 1, 0)})
+	}
+	if err := rows.Err(); err != nil {
+		return nil, spanError(ctx, span, err)
+	}
+	spanRows(span, int64(len(partitions)))
+
+	sort.Slice(partitions, func(i, j int) bool { return partitions[i].From.Before(partitions[j].From) })
+	return partitions, nil
+}
+
+// RemoveBefore detaches every partition whose snapshots are all older than cutoff, and
+// drops it too unless detachOnly is set, in which case it is left behind as a standalone
+// table which can be archived. When dropping, old snapshots held by the default partition
+// are deleted as well. Only snapshots included in the weekly and monthly rollups are
+// removed, so cutoff is held back to what has been rolled up. It returns the names of the
+// partitions removed.
+func (m PartitionModel) RemoveBefore(ctx context.Context, cutoff time.Time, detachOnly bool) ([]string, error) {
+	partitions, err := m.List(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var removed []string
+	for _, p := range partitions {
+		if p.To.After(cutoff) {
+			break
+		}
+		ok, err := m.remove(ctx, p, detachOnly)
+		if err != nil {
+			return removed, err
+		}
+		if !ok {
+			break
+		}
+		removed = append(removed, p.Name)
+	}
+
+	if !detachOnly {
+		query := `DELETE FROM jobs_default WHERE created_at < $1`
+
+		ctx, span := startSpan(ctx, "PartitionModel.RemoveBefore", query)
+		defer span.End()
+
+		err := runTx(ctx, m.DB, "postgresql", func(tx *sql.Tx) error {
+			limit, err := rolledUpBefore(ctx, tx, cutoff)
+			if err != nil || limit.IsZero() {
+				return err
+			}
+
+			result, err := tx.ExecContext(ctx, query, limit)
+			if err != nil {
+				return err
+			}
+			if rows, err := result.RowsAffected(); err == nil {
+				spanRows(span, rows)
+			}
+			return nil
+		})
+		if err != nil {
+			return removed, spanError(ctx, span, err)
+		}
+	}
+
+	return removed, nil
+}
+
+// remove detaches a partition and drops it unless detachOnly is set, reporting whether it
+// was removed. Both happen in one transaction, so a partition is never left detached when
+// it should have been dropped. A partition holding snapshots which haven't been rolled up
+// is kept.
+func (m PartitionModel) remove(ctx context.Context, p Partition, detachOnly bool) (bool, error) {
+	name := pq.QuoteIdentifier(p.Name)
+	query := fmt.Sprintf(`ALTER TABLE jobs DETACH PARTITION %s`, name)
+
+	ctx, span := startSpan(ctx, "PartitionModel.Remove", query)
+	defer span.End()
+
+	removed := false
+	err := runTx(ctx, m.DB, "postgresql", func(tx *sql.Tx) error {
+		// detaching locks the jobs table anyway. Taking that lock before the one on
+		// jobs_rollup_state, as writes do, keeps the two from deadlocking.
+		if _, err := tx.ExecContext(ctx, `LOCK TABLE jobs IN ACCESS EXCLUSIVE MODE`); err != nil {
+			return err
+		}
+		limit, err := rolledUpBefore(ctx, tx, p.To)
+		if err != nil || limit.Before(p.To) {
+			return err
+		}
+
+		if _, err := tx.ExecContext(ctx, query); err != nil {
+			return err
+		}
+		if !detachOnly {
+			if _, err := tx.ExecContext(ctx, fmt.Sprintf(`DROP TABLE %s`, name)); err != nil {
+				return err
+			}
+		}
+		removed = true
+		return nil
+	})
+	if err != nil {
+		return false, spanError(ctx, span, err)
+	}
+	return removed, nil
+}
+
+// rolledUpBefore returns how far up to cutoff snapshots can be removed without losing any
+// which the rollups don't include yet: up to what has been rolled up into both weeks and
+// months, and before the first day whose rollups are missing some of its snapshots. The
+// zero time means nothing has been rolled up. jobs_rollup_state is locked until the
+// transaction ends, which holds back the writes to the jobs table meanwhile.
+func rolledUpBefore(ctx context.Context, tx *sql.Tx, cutoff time.Time) (time.Time, error) {
+	var weekly, monthly, dirty nullDate
+	err := tx.QueryRowContext(ctx, `
+		SELECT weekly_through, monthly_through, (SELECT min(day) FROM jobs_rollup_dirty)
+		FROM jobs_rollup_state
+		FOR UPDATE`).Scan(&weekly, &monthly, &dirty)
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	limit, ok := purgeCutoff(cutoff, weekly.Time, monthly.Time, dirty.Time, time.Time{})
+	if !ok {
+		return time.Time{}, nil
+	}
+	return limit, nil
+}
+
+// partitionName returns the name of the partition holding the month starting at month
+func partitionName(month time.Time) string {
+	return partitionPrefix + month.Format("200601")
+}
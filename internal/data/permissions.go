@@ -0,0 +1,129 @@
+package data
+
+import (
+	"context"
+
+	"github.com/lib/pq"
+)
+
+// Permission codes which can be granted to users
+const (
+	PermissionCompaniesRead  = "companies:read"
+	PermissionCompaniesWrite = "companies:write"
+	PermissionAdmin          = "admin"
+)
+
+// Permissions holds the permission codes for a single user
+type Permissions []string
+
+// Include checks whether the Permissions slice contains a specific permission code
+func (p Permissions) Include(code string) bool {
+	for i := range p {
+		if code == p[i] {
+			return true
+		}
+	}
+	return false
+}
+
+// PermissionModel wraps the connection pool
+type PermissionModel struct {
+	DB       Queryer
+	Timeouts Timeouts
+}
+
+// GetAll returns every permission code which exists and can be granted
+func (m PermissionModel) GetAll(ctx context.Context) (Permissions, error) {
+	query := `
+		SELECT code
+		FROM permissions
+		ORDER BY code`
+
+	ctx, span := startSpan(ctx, "PermissionModel.GetAll", query)
+	defer span.End()
+
+	ctx, cancel := context.WithTimeout(ctx, m.Timeouts.For("PermissionModel.GetAll"))
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query)
+	if err != nil {
+		return nil, spanError(ctx, span, err)
+	}
+	defer rows.Close()
+
+	var permissions Permissions
+	for rows.Next() {
+		var code string
+		if err := rows.Scan(&code); err != nil {
+			return nil, spanError(ctx, span, err)
+		}
+		permissions = append(permissions, code)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, spanError(ctx, span, err)
+	}
+	spanRows(span, int64(len(permissions)))
+	return permissions, nil
+}
+
+// GetAllForUser returns all permission codes granted to a specific user
+func (m PermissionModel) GetAllForUser(ctx context.Context, userID int64) (Permissions, error) {
+	query := `
+		SELECT permissions.code
+		FROM permissions
+		INNER JOIN users_permissions ON users_permissions.permission_id = permissions.id
+		WHERE users_permissions.user_id = $1
+		ORDER BY permissions.code`
+
+	ctx, span := startSpan(ctx, "PermissionModel.GetAllForUser", query)
+	defer span.End()
+
+	ctx, cancel := context.WithTimeout(ctx, m.Timeouts.For("PermissionModel.GetAllForUser"))
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, userID)
+	if err != nil {
+		return nil, spanError(ctx, span, err)
+	}
+	defer rows.Close()
+
+	var permissions Permissions
+	for rows.Next() {
+		var code string
+		if err := rows.Scan(&code); err != nil {
+			return nil, spanError(ctx, span, err)
+		}
+		permissions = append(permissions, code)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, spanError(ctx, span, err)
+	}
+	spanRows(span, int64(len(permissions)))
+	return permissions, nil
+}
+
+// AddForUser grants the given permission codes to a user. Codes the user already holds are
+// left as they are.
+func (m PermissionModel) AddForUser(ctx context.Context, userID int64, codes ...string) error {
+	query := `
+		INSERT INTO users_permissions
+		SELECT $1, permissions.id FROM permissions WHERE permissions.code = ANY($2)
+		ON CONFLICT DO NOTHING`
+
+	ctx, span := startSpan(ctx, "PermissionModel.AddForUser", query)
+	defer span.End()
+
+	ctx, cancel := context.WithTimeout(ctx, m.Timeouts.For("PermissionModel.AddForUser"))
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, userID, pq.Array(codes))
+	if err != nil {
+		return spanError(ctx, span, err)
+	}
+	if rows, err := result.RowsAffected(); err == nil {
+		spanRows(span, rows)
+	}
+	return nil
+}
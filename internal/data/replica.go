@@ -0,0 +1,102 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"sync"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Replica routes the queries of read-only model methods to a PostgreSQL read replica, so
+// that listings don't compete for connections with writes on the primary. Reads go back to
+// the primary whenever the last check found the replica unreachable or lagging more than
+// MaxLag behind, and until the first check has succeeded.
+type Replica struct {
+	DB     *sql.DB
+	MaxLag time.Duration
+
+	mu     sync.RWMutex
+	status ReplicaStatus
+}
+
+// ReplicaStatus is the outcome of the last replica check
+type ReplicaStatus struct {
+	Healthy   bool          // reads are being served by the replica
+	Lag       time.Duration // how far replay on the replica is behind the primary
+	Err       error         // why the replica could not be checked, if it couldn't
+	CheckedAt time.Time     // zero until the first check
+}
+
+// NewReplica returns a Replica for the pool, which serves no reads until Check has found it
+// healthy
+func NewReplica(db *sql.DB, maxLag time.Duration) *Replica {
+	return &Replica{DB: db, MaxLag: maxLag}
+}
+
+// Check measures the replication lag of the replica and records whether it may serve
+// reads. A replica which has replayed everything it received is not lagging, however long
+// ago the last write on the primary was. A server which isn't in recovery is treated as
+// having no lag, so that a promoted replica keeps serving reads.
+func (r *Replica) Check(ctx context.Context) (ReplicaStatus, error) {
+	query := `
+		SELECT CASE
+			WHEN NOT pg_is_in_recovery() THEN 0
+			WHEN pg_last_wal_receive_lsn() = pg_last_wal_replay_lsn() THEN 0
+			ELSE COALESCE(EXTRACT(EPOCH FROM now() - pg_last_xact_replay_timestamp()), 0)
+		END`
+
+	ctx, span := startSpan(ctx, "Replica.Check", query)
+	defer span.End()
+
+	var seconds float64
+	err := r.DB.QueryRowContext(ctx, query).Scan(&seconds)
+	if err != nil {
+		err = spanError(ctx, span, err)
+	}
+
+	status := ReplicaStatus{
+		Lag:       time.Duration(seconds * float64(time.Second)),
+		Err:       err,
+		CheckedAt: time.Now(),
+	}
+	status.Healthy = err == nil && status.Lag <= r.MaxLag
+
+	r.mu.Lock()
+	r.status = status
+	r.mu.Unlock()
+
+	return status, err
+}
+
+// Status returns the outcome of the last check
+func (r *Replica) Status() ReplicaStatus {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	return r.status
+}
+
+// primaryKey is the context key set by WithPrimary
+type primaryKey struct{}
+
+// WithPrimary returns a copy of ctx whose reads always go to the primary. Handlers use it
+// when they read a record in order to change it, as a stale copy from the replica would
+// fail the version check of the write.
+func WithPrimary(ctx context.Context) context.Context {
+	return context.WithValue(ctx, primaryKey{}, true)
+}
+
+// reader returns the pool a read-only query should run on, recording the choice on its
+// span. The replica is used when it is configured, healthy and ctx allows it.
+func (r *Replica) reader(ctx context.Context, span trace.Span, primary Queryer) Queryer {
+	useReplica := r != nil && ctx.Value(primaryKey{}) == nil && r.Status().Healthy
+	span.SetAttributes(attribute.Bool("db.replica", useReplica))
+
+	if useReplica {
+		return r.DB
+	}
+	return primary
+}
@@ -0,0 +1,602 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"slices"
+	"time"
+
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Periods the snapshots are rolled up over. Weeks start on Monday, and both weeks and months
+// follow the UTC calendar.
+const (
+	PeriodWeek  = "week"
+	PeriodMonth = "month"
+)
+
+// RollupStats summarises the snapshots of a vendor in one country over a week or month
+type RollupStats struct {
+	Period  string    `json:"period" xml:"period"`
+	Start   time.Time `json:"start" xml:"start"`
+	End     time.Time `json:"end" xml:"end"` // start of the following period
+	Min     int       `json:"min" xml:"min"`
+	Max     int       `json:"max" xml:"max"`
+	Avg     float64   `json:"avg" xml:"avg"`
+	Last    int       `json:"last" xml:"last"` // amount of the latest snapshot
+	Samples int       `json:"samples" xml:"samples"`
+}
+
+// Rollup is the summary of a vendor's snapshots in one country over a period
+type Rollup struct {
+	Vendor  string `json:"company" xml:"company"`
+	Country string `json:"country" xml:"country"`
+	URL     string `json:"url" xml:"url"` // url of the latest snapshot
+	RollupStats
+}
+
+// periodDay is the period of the daily rollups, which the weekly and monthly ones are made
+// from. They aren't served on their own.
+const periodDay = "day"
+
+// rollupBatchDays is how many days a single call to Refresh or Purge works through. Each
+// call commits its progress, so that a large backlog is worked through in short
+// transactions which never have to start over.
+const rollupBatchDays = 7
+
+// rollupPeriod names the table holding the rollups of a period, and the column of
+// jobs_rollup_state recording the end of the latest one
+type rollupPeriod struct {
+	name    string
+	table   string
+	through string
+}
+
+var rollupPeriods = []rollupPeriod{
+	{name: PeriodWeek, table: "jobs_rollup_weekly", through: "weekly_through"},
+	{name: PeriodMonth, table: "jobs_rollup_monthly", through: "monthly_through"},
+}
+
+// findPeriod returns the rollupPeriod of the named period
+func findPeriod(name string) (rollupPeriod, error) {
+	for _, p := range rollupPeriods {
+		if p.name == name {
+			return p, nil
+		}
+	}
+	return rollupPeriod{}, fmt.Errorf("data: unknown rollup period %q", name)
+}
+
+// ValidPeriod reports whether snapshots are rolled up over the named period
+func ValidPeriod(name string) bool {
+	_, err := findPeriod(name)
+	return err == nil
+}
+
+// periodStart returns the start of the day, week or month containing t
+func periodStart(period string, t time.Time) time.Time {
+	day := dayStart(t)
+	switch period {
+	case periodDay:
+		return day
+	case PeriodMonth:
+		return monthStart(t)
+	}
+	return day.AddDate(0, 0, -(int(day.Weekday())+6)%7)
+}
+
+// periodEnd returns the start of the period following the one starting at start
+func periodEnd(period string, start time.Time) time.Time {
+	switch period {
+	case periodDay:
+		return start.AddDate(0, 0, 1)
+	case PeriodMonth:
+		return start.AddDate(0, 1, 0)
+	}
+	return start.AddDate(0, 0, 7)
+}
+
+// dayStart returns the start of the UTC day containing t
+func dayStart(t time.Time) time.Time {
+	t = t.UTC()
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+}
+
+// historyRange widens from and to so that they cover every period they overlap
+func historyRange(period string, from, to time.Time) (time.Time, time.Time) {
+	from, last := periodStart(period, from), periodStart(period, to)
+	if last.Before(to) {
+		last = periodEnd(period, last)
+	}
+	return from, last
+}
+
+// periodStarts returns the start of every period containing one of the days which ends by
+// through, that is every period whose rollup is complete and made stale by rolling up the
+// days again
+func periodStarts(period string, days []time.Time, through time.Time) []time.Time {
+	var starts []time.Time
+	for _, day := range days {
+		start := periodStart(period, day)
+		if !periodEnd(period, start).After(through) && !slices.ContainsFunc(starts, start.Equal) {
+			starts = append(starts, start)
+		}
+	}
+	return starts
+}
+
+// purgeCutoff returns the day before which the raw snapshots are purged when asked to purge
+// those taken before before. It never goes past what has been rolled up into both weeks and
+// months, nor past the first dirty day, whose rollups are missing some of its snapshots.
+// The purge only happens if it goes further than the previous one. Zero times stand for
+// what hasn't happened yet.
+func purgeCutoff(before, weekly, monthly, dirty, purged time.Time) (time.Time, bool) {
+	if weekly.IsZero() || monthly.IsZero() {
+		return time.Time{}, false
+	}
+
+	cutoff := dayStart(before)
+	for _, limit := range []time.Time{weekly, monthly, dirty} {
+		if !limit.IsZero() && limit.Before(cutoff) {
+			cutoff = limit
+		}
+	}
+	return cutoff, cutoff.After(purged)
+}
+
+// purgeBatch returns the days purged by a single call to Purge, from the end of the
+// previous purge up to cutoff. The first purge starts with the day of the oldest snapshot,
+// if there is one.
+func purgeBatch(purged, oldest, cutoff time.Time) (time.Time, time.Time) {
+	start := purged
+	if start.IsZero() {
+		if oldest.IsZero() {
+			return cutoff, cutoff
+		}
+		start = dayStart(oldest)
+	}
+	end := start.AddDate(0, 0, rollupBatchDays)
+	if end.After(cutoff) {
+		end = cutoff
+	}
+	return start, end
+}
+
+// rollupColumns lists the columns of the rollup tables, in the order selected by
+// rollupSelect
+const rollupColumns = "vendor, country, period_start, min_amount, max_amount, avg_amount, last_amount, url, samples"
+
+// dailyColumns lists the columns of jobs_rollup_daily, which also keeps what merging
+// further snapshots into a rollup needs
+const dailyColumns = rollupColumns + ", sum_amount, last_at"
+
+// dailyAggregates selects the extra columns of jobs_rollup_daily in rollupSelect
+const dailyAggregates = ", sum(amount), max(created_at)"
+
+// rollupSelect returns the query summarising the snapshots matching where into a row per
+// vendor, country and period, the period of a snapshot being the date given by the
+// expression trunc. The columns of rollupColumns are followed by the aggregates listed in
+// extra. It is the same for PostgreSQL and SQLite.
+func rollupSelect(trunc, where, extra string) string {
+	return fmt.Sprintf(`
+		SELECT vendor, country, period_start, min(amount), max(amount), CAST(avg(amount) AS double precision),
+			max(CASE WHEN latest = 1 THEN amount END), max(CASE WHEN latest = 1 THEN url END), count(*)%[3]s
+		FROM (
+			SELECT vendor, country, amount, url, created_at, %[1]s AS period_start,
+				row_number() OVER (PARTITION BY vendor, country, %[1]s ORDER BY created_at DESC, id DESC) AS latest
+			FROM jobs
+			WHERE %[2]s
+		) AS s
+		GROUP BY vendor, country, period_start`, trunc, where, extra)
+}
+
+// dailyMerge ends the statement storing daily rollups, merging them into any already
+// stored for the same day, which only happens for the snapshots added to a purged day.
+// least and greatest name the functions returning the smallest and largest of their
+// arguments.
+func dailyMerge(least, greatest string) string {
+	return fmt.Sprintf(`
+		ON CONFLICT (vendor, country, period_start) DO UPDATE SET
+			min_amount = %[1]s(jobs_rollup_daily.min_amount, excluded.min_amount),
+			max_amount = %[2]s(jobs_rollup_daily.max_amount, excluded.max_amount),
+			avg_amount = CAST(jobs_rollup_daily.sum_amount + excluded.sum_amount AS double precision) /
+				(jobs_rollup_daily.samples + excluded.samples),
+			last_amount = CASE WHEN excluded.last_at >= jobs_rollup_daily.last_at
+				THEN excluded.last_amount ELSE jobs_rollup_daily.last_amount END,
+			url = CASE WHEN excluded.last_at >= jobs_rollup_daily.last_at
+				THEN excluded.url ELSE jobs_rollup_daily.url END,
+			samples = jobs_rollup_daily.samples + excluded.samples,
+			sum_amount = jobs_rollup_daily.sum_amount + excluded.sum_amount,
+			last_at = %[2]s(jobs_rollup_daily.last_at, excluded.last_at)`, least, greatest)
+}
+
+// periodSelect returns the query summarising the daily rollups matching where into a row
+// per vendor, country and period, the period of a day being the date given by the
+// expression trunc. It selects the columns of rollupColumns.
+func periodSelect(trunc, where string) string {
+	return fmt.Sprintf(`
+		SELECT vendor, country, period_start, min(min_amount), max(max_amount),
+			CAST(sum(sum_amount) AS double precision) / sum(samples),
+			max(CASE WHEN latest = 1 THEN last_amount END), max(CASE WHEN latest = 1 THEN url END), sum(samples)
+		FROM (
+			SELECT vendor, country, min_amount, max_amount, sum_amount, samples, last_amount, url, %[1]s AS period_start,
+				row_number() OVER (PARTITION BY vendor, country, %[1]s ORDER BY last_at DESC) AS latest
+			FROM jobs_rollup_daily
+			WHERE %[2]s
+		) AS d
+		GROUP BY vendor, country, period_start`, trunc, where)
+}
+
+// listedRollup receives the rollup columns of a row returned by GetAllRows, which are NULL
+// unless the row was read from the weekly rollups
+type listedRollup struct {
+	period            sql.NullString
+	min, max, samples sql.NullInt64
+	avg               sql.NullFloat64
+}
+
+// dest returns the scan destinations of the columns
+func (r *listedRollup) dest() []any {
+	return []any{&r.period, &r.min, &r.max, &r.avg, &r.samples}
+}
+
+// stats returns the statistics of the listed company, or nil if it is a raw snapshot. Its
+// CreatedAt holds the start of the week and its Total the amount of the latest snapshot.
+func (r *listedRollup) stats(c *Company) *RollupStats {
+	if !r.period.Valid {
+		return nil
+	}
+	start := c.CreatedAt.UTC()
+	return &RollupStats{
+		Period:  r.period.String,
+		Start:   start,
+		End:     periodEnd(r.period.String, start),
+		Min:     int(r.min.Int64),
+		Max:     int(r.max.Int64),
+		Avg:     r.avg.Float64,
+		Last:    c.Total,
+		Samples: int(r.samples.Int64),
+	}
+}
+
+// rollupDialect holds what maintaining the rollup tables differs in between PostgreSQL and
+// SQLite
+type rollupDialect struct {
+	startSpan func(ctx context.Context, operation, query string) (context.Context, trace.Span)
+	// lock ends the query reading jobs_rollup_state, locking it until the transaction ends
+	lock string
+	// day gives the UTC day of a snapshot, and trunc the period of a daily rollup
+	day   string
+	trunc func(p rollupPeriod) string
+	// least and greatest name the functions returning the smallest and largest argument
+	least, greatest string
+	// timestamp turns a time into an argument compared with created_at
+	timestamp func(t time.Time) any
+}
+
+var pgRollups = rollupDialect{
+	startSpan: startSpan,
+	lock:      "FOR UPDATE",
+	day:       "(created_at AT TIME ZONE 'UTC')::date",
+	trunc: func(p rollupPeriod) string {
+		return fmt.Sprintf("date_trunc('%s', period_start::timestamp)::date", p.name)
+	},
+	least:     "least",
+	greatest:  "greatest",
+	timestamp: func(t time.Time) any { return t.UTC() },
+}
+
+// rollupState is the row of jobs_rollup_state. Zero times stand for NULLs.
+type rollupState struct {
+	daily, weekly, monthly, purged time.Time
+}
+
+// rollupMaintainer runs Refresh and Purge for either database
+type rollupMaintainer struct {
+	db       Queryer
+	dialect  rollupDialect
+	timeouts Timeouts
+}
+
+// lockState reads jobs_rollup_state, which stays locked until the transaction ends. The
+// jobs_rollup_dirty triggers take a lock on it as well, so that writes in progress are
+// seen by the rollups, and writes made meanwhile wait to be marked dirty.
+func (r rollupMaintainer) lockState(ctx context.Context) (rollupState, error) {
+	var daily, weekly, monthly, purged nullDate
+	err := r.db.QueryRowContext(ctx, `
+		SELECT daily_through, weekly_through, monthly_through, purged_before
+		FROM jobs_rollup_state `+r.dialect.lock).Scan(&daily, &weekly, &monthly, &purged)
+	return rollupState{daily.Time, weekly.Time, monthly.Time, purged.Time}, err
+}
+
+// oldest returns the creation time of the oldest snapshot, or the zero time if there are
+// none
+func (r rollupMaintainer) oldest(ctx context.Context) (time.Time, error) {
+	var oldest nullDate
+	err := r.db.QueryRowContext(ctx, `SELECT min(created_at) FROM jobs`).Scan(&oldest)
+	return oldest.Time, err
+}
+
+// refresh implements Refresh
+func (r rollupMaintainer) refresh(ctx context.Context, now time.Time) (int64, bool, error) {
+	dayQuery := fmt.Sprintf(`INSERT INTO jobs_rollup_daily (%s) %s %s`, dailyColumns,
+		rollupSelect(r.dialect.day, "created_at >= $1 AND created_at < $2", dailyAggregates),
+		dailyMerge(r.dialect.least, r.dialect.greatest))
+
+	ctx, span := r.dialect.startSpan(ctx, "RollupModel.Refresh", dayQuery)
+	defer span.End()
+
+	ctx, cancel := context.WithTimeout(ctx, r.timeouts.For("RollupModel.Refresh"))
+	defer cancel()
+
+	state, err := r.lockState(ctx)
+	if err != nil {
+		return 0, false, spanError(ctx, span, err)
+	}
+	days, through, err := r.refreshDays(ctx, state, now)
+	if err != nil {
+		return 0, false, spanError(ctx, span, err)
+	}
+	if len(days) == 0 {
+		return 0, false, nil
+	}
+
+	var stored int64
+	for _, day := range days {
+		n, err := r.rollupDay(ctx, dayQuery, day, day.Before(state.purged))
+		stored += n
+		if err != nil {
+			return stored, false, spanError(ctx, span, err)
+		}
+	}
+	for _, p := range rollupPeriods {
+		for _, start := range periodStarts(p.name, days, through) {
+			n, err := r.rollupPeriod(ctx, p, start)
+			stored += n
+			if err != nil {
+				return stored, false, spanError(ctx, span, err)
+			}
+		}
+	}
+	spanRows(span, stored)
+
+	_, err = r.db.ExecContext(ctx, `UPDATE jobs_rollup_state SET daily_through = $1, weekly_through = $2, monthly_through = $3`,
+		through.Format(time.DateOnly), periodStart(PeriodWeek, through).Format(time.DateOnly),
+		periodStart(PeriodMonth, through).Format(time.DateOnly))
+	if err != nil {
+		return stored, false, spanError(ctx, span, err)
+	}
+	return stored, true, nil
+}
+
+// refreshDays returns the next batch of days to roll up, along with the day before which
+// every day is rolled up once they are. Dirty days come first, then the complete days which
+// haven't been rolled up yet, starting with the day of the oldest snapshot.
+func (r rollupMaintainer) refreshDays(ctx context.Context, state rollupState, now time.Time) ([]time.Time, time.Time, error) {
+	rows, err := r.db.QueryContext(ctx, `SELECT day FROM jobs_rollup_dirty ORDER BY day LIMIT $1`, rollupBatchDays)
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+	defer rows.Close()
+
+	var days []time.Time
+	for rows.Next() {
+		var day nullDate
+		if err := rows.Scan(&day); err != nil {
+			return nil, time.Time{}, err
+		}
+		days = append(days, day.Time)
+	}
+	if err := rows.Err(); err != nil || len(days) > 0 {
+		return days, state.daily, err
+	}
+
+	from := state.daily
+	if from.IsZero() {
+		oldest, err := r.oldest(ctx)
+		if err != nil || oldest.IsZero() {
+			return nil, time.Time{}, err
+		}
+		from = dayStart(oldest)
+	}
+
+	for day := from; day.Before(dayStart(now)) && len(days) < rollupBatchDays; day = day.AddDate(0, 0, 1) {
+		days = append(days, day)
+	}
+	if len(days) == 0 {
+		return nil, state.daily, nil
+	}
+	return days, periodEnd(periodDay, days[len(days)-1]), nil
+}
+
+// rollupDay stores the daily rollups of a day with query, which selects its snapshots, and
+// clears its dirty mark. The rollups of a day which hasn't been purged are replaced. Those of
+// a purged day have the snapshots added since merged into them, and the snapshots are then
+// purged as well.
+func (r rollupMaintainer) rollupDay(ctx context.Context, query string, day time.Time, purged bool) (int64, error) {
+	date := day.Format(time.DateOnly)
+	start, end := r.dialect.timestamp(day), r.dialect.timestamp(periodEnd(periodDay, day))
+
+	if !purged {
+		if _, err := r.db.ExecContext(ctx, `DELETE FROM jobs_rollup_daily WHERE period_start = $1`, date); err != nil {
+			return 0, err
+		}
+	}
+
+	result, err := r.db.ExecContext(ctx, query, start, end)
+	if err != nil {
+		return 0, err
+	}
+	stored, err := result.RowsAffected()
+	if err != nil {
+		return 0, err
+	}
+
+	if purged {
+		if _, err := r.db.ExecContext(ctx, `DELETE FROM jobs WHERE created_at >= $1 AND created_at < $2`, start, end); err != nil {
+			return stored, err
+		}
+	}
+	_, err = r.db.ExecContext(ctx, `DELETE FROM jobs_rollup_dirty WHERE day = $1`, date)
+	return stored, err
+}
+
+// rollupPeriod replaces the rollups of the period of p starting at start with the summary
+// of its daily rollups
+func (r rollupMaintainer) rollupPeriod(ctx context.Context, p rollupPeriod, start time.Time) (int64, error) {
+	from, to := start.Format(time.DateOnly), periodEnd(p.name, start).Format(time.DateOnly)
+
+	if _, err := r.db.ExecContext(ctx, fmt.Sprintf(`DELETE FROM %s WHERE period_start = $1`, p.table), from); err != nil {
+		return 0, err
+	}
+
+	result, err := r.db.ExecContext(ctx, fmt.Sprintf(`INSERT INTO %s (%s) %s`, p.table, rollupColumns,
+		periodSelect(r.dialect.trunc(p), "period_start >= $1 AND period_start < $2")), from, to)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+// purge implements Purge
+func (r rollupMaintainer) purge(ctx context.Context, before time.Time) (int64, bool, error) {
+	query := `DELETE FROM jobs WHERE created_at >= $1 AND created_at < $2`
+
+	ctx, span := r.dialect.startSpan(ctx, "RollupModel.Purge", query)
+	defer span.End()
+
+	ctx, cancel := context.WithTimeout(ctx, r.timeouts.For("RollupModel.Purge"))
+	defer cancel()
+
+	state, err := r.lockState(ctx)
+	if err != nil {
+		return 0, false, spanError(ctx, span, err)
+	}
+
+	// dirty days before purged_before only hold snapshots waiting to be merged, and don't
+	// stop the purge
+	var dirty nullDate
+	err = r.db.QueryRowContext(ctx, `SELECT min(day) FROM jobs_rollup_dirty WHERE day >= $1`,
+		state.purged.Format(time.DateOnly)).Scan(&dirty)
+	if err != nil {
+		return 0, false, spanError(ctx, span, err)
+	}
+
+	cutoff, ok := purgeCutoff(before, state.weekly, state.monthly, dirty.Time, state.purged)
+	if !ok {
+		return 0, false, nil
+	}
+	var oldest time.Time
+	if state.purged.IsZero() {
+		if oldest, err = r.oldest(ctx); err != nil {
+			return 0, false, spanError(ctx, span, err)
+		}
+	}
+	start, end := purgeBatch(state.purged, oldest, cutoff)
+
+	// purged_before moves first, so that the triggers don't take the deletions for changes
+	// to rolled up days
+	if _, err := r.db.ExecContext(ctx, `UPDATE jobs_rollup_state SET purged_before = $1`, end.Format(time.DateOnly)); err != nil {
+		return 0, false, spanError(ctx, span, err)
+	}
+
+	result, err := r.db.ExecContext(ctx, query, r.dialect.timestamp(start), r.dialect.timestamp(end))
+	if err != nil {
+		return 0, false, spanError(ctx, span, err)
+	}
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return 0, false, spanError(ctx, span, err)
+	}
+	spanRows(span, rows)
+
+	return rows, end.Before(cutoff), nil
+}
+
+// RollupModel maintains the PostgreSQL rollup tables. Refresh and Purge each lock and then
+// advance jobs_rollup_state, so they should run through Models.WithTx, which also
+// serialises concurrent runs on the row lock they take.
+type RollupModel struct {
+	DB       Queryer
+	Replica  *Replica
+	Timeouts Timeouts
+}
+
+// pgTrunc returns the expression giving the start of the period of a snapshot
+func pgTrunc(p rollupPeriod) string {
+	return fmt.Sprintf("date_trunc('%s', created_at AT TIME ZONE 'UTC')::date", p.name)
+}
+
+// Refresh rolls up the next batch of days: first the days whose snapshots changed since
+// they were rolled up, then the complete days which haven't been rolled up yet, starting
+// with the day of the oldest snapshot. The weeks and months containing them are rolled up
+// again from the days once complete. It returns how many rollups were stored, and whether
+// there was anything to roll up, in which case it should be called again.
+func (m RollupModel) Refresh(ctx context.Context, now time.Time) (int64, bool, error) {
+	return rollupMaintainer{m.DB, pgRollups, m.Timeouts}.refresh(ctx, now)
+}
+
+// Purge deletes the raw snapshots of the next batch of days taken before the day of before,
+// returning how many were deleted and whether more days remain to be purged, in which case
+// it should be called again. Only days which have been rolled up into both weeks and months,
+// and haven't changed since, are purged, so Refresh must have run first. Listing a purged
+// day returns the rollups of its week.
+func (m RollupModel) Purge(ctx context.Context, before time.Time) (int64, bool, error) {
+	return rollupMaintainer{m.DB, pgRollups, m.Timeouts}.purge(ctx, before)
+}
+
+// History returns the rollups of a vendor over every period overlapping from to to, for one
+// country or for all of them if country is empty, ordered by country and period. Periods
+// which have been rolled up are read from the rollup tables, and the rest, including the
+// current period so far, are summarised from the raw snapshots.
+func (m RollupModel) History(ctx context.Context, vendor, country, period string, from, to time.Time) ([]*Rollup, error) {
+	p, err := findPeriod(period)
+	if err != nil {
+		return nil, err
+	}
+	from, to = historyRange(period, from, to)
+
+	query := fmt.Sprintf(`
+		SELECT %[1]s
+		FROM %[2]s
+		WHERE vendor = $1 AND ($2 = '' OR country = $2)
+		AND period_start >= $3::date AND period_start < $4::date
+		AND period_start < (SELECT coalesce(%[3]s, '-infinity') FROM jobs_rollup_state)
+		UNION ALL
+		%[4]s
+		ORDER BY country, period_start`, rollupColumns, p.table, p.through,
+		rollupSelect(pgTrunc(p), fmt.Sprintf(`vendor = $1 AND ($2 = '' OR country = $2)
+				AND created_at >= greatest($3::date, (SELECT %s FROM jobs_rollup_state))
+				AND created_at < $4::date`, p.through), ""))
+
+	ctx, span := startSpan(ctx, "RollupModel.History", query)
+	defer span.End()
+
+	ctx, cancel := context.WithTimeout(ctx, m.Timeouts.For("RollupModel.History"))
+	defer cancel()
+
+	rows, err := m.Replica.reader(ctx, span, m.DB).QueryContext(ctx, query, vendor, country, from, to)
+	if err != nil {
+		return nil, spanError(ctx, span, err)
+	}
+	defer rows.Close()
+
+	rollups := []*Rollup{}
+	for rows.Next() {
+		r := Rollup{RollupStats: RollupStats{Period: period}}
+		if err := rows.Scan(&r.Vendor, &r.Country, &r.Start, &r.Min, &r.Max, &r.Avg, &r.Last, &r.URL, &r.Samples); err != nil {
+			return nil, spanError(ctx, span, err)
+		}
+		r.Start = r.Start.UTC()
+		r.End = periodEnd(period, r.Start)
+		rollups = append(rollups, &r)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, spanError(ctx, span, err)
+	}
+	spanRows(span, int64(len(rollups)))
+
+	return rollups, nil
+}
@@ -0,0 +1,222 @@
+package data
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/sparkycj328/JobAIO-API/internal/validator"
+)
+
+// snapshotColumns maps the accepted CSV header names onto the fields of a Company. Both the
+// names used by the CSV export of the API and the database column names are accepted, so
+// an export can be imported again unchanged.
+var snapshotColumns = map[string]string{
+	"company":    "name",
+	"vendor":     "name",
+	"country":    "country",
+	"total":      "total",
+	"amount":     "total",
+	"url":        "url",
+	"created":    "created",
+	"created_at": "created",
+}
+
+// RowError reports a snapshot which could not be parsed or failed validation. Reading can
+// carry on with the next row after a RowError.
+type RowError struct {
+	Line int
+	Err  error
+}
+
+func (e *RowError) Error() string {
+	return fmt.Sprintf("line %d: %v", e.Line, e.Err)
+}
+
+func (e *RowError) Unwrap() error {
+	return e.Err
+}
+
+// SnapshotReader streams job snapshots from a CSV file with a header row, or from
+// newline-delimited JSON objects with the fields of the API's JSON output. Only the
+// current row is held in memory, so inputs of any size can be read.
+type SnapshotReader struct {
+	line int
+	next func() (*Company, error)
+}
+
+// NewSnapshotReader returns a reader for the format, which is csv or ndjson. A CSV header is
+// read straight away, so that a file missing a required column is rejected before any row.
+func NewSnapshotReader(r io.Reader, format string) (*SnapshotReader, error) {
+	switch format {
+	case "csv":
+		return newCSVSnapshotReader(r)
+	case "ndjson":
+		return newNDJSONSnapshotReader(r), nil
+	default:
+		return nil, fmt.Errorf("unknown snapshot format %q", format)
+	}
+}
+
+// Read returns the next valid snapshot. An invalid row is reported as a *RowError, the end
+// of the input as io.EOF, and any other error means the input can't be read any further.
+func (sr *SnapshotReader) Read() (*Company, error) {
+	return sr.next()
+}
+
+// Line returns the line number of the row returned by the last call to Read
+func (sr *SnapshotReader) Line() int {
+	return sr.line
+}
+
+func newCSVSnapshotReader(in io.Reader) (*SnapshotReader, error) {
+	r := csv.NewReader(in)
+	r.TrimLeadingSpace = true
+	r.ReuseRecord = true
+
+	header, err := r.Read()
+	if err != nil {
+		return nil, fmt.Errorf("reading CSV header: %w", err)
+	}
+
+	// unknown columns such as id and version are ignored
+	columns := make(map[string]int)
+	for i, name := range header {
+		if field, ok := snapshotColumns[strings.ToLower(strings.TrimSpace(name))]; ok {
+			columns[field] = i
+		}
+	}
+	for _, field := range []string{"name", "country", "total", "url"} {
+		if _, ok := columns[field]; !ok {
+			return nil, fmt.Errorf("CSV header must include a %s column", field)
+		}
+	}
+
+	sr := &SnapshotReader{line: 1}
+	sr.next = func() (*Company, error) {
+		record, err := r.Read()
+		var parseErr *csv.ParseError
+		switch {
+		case errors.As(err, &parseErr) && errors.Is(err, csv.ErrFieldCount):
+			// the reader carries on after a row with the wrong number of fields
+			sr.line = parseErr.StartLine
+			return nil, &RowError{Line: sr.line, Err: parseErr.Err}
+		case err != nil:
+			return nil, err
+		}
+		sr.line, _ = r.FieldPos(0)
+
+		total, err := strconv.Atoi(record[columns["total"]])
+		if err != nil {
+			return nil, &RowError{Line: sr.line, Err: errors.New("total must be an integer")}
+		}
+		created := ""
+		if i, ok := columns["created"]; ok {
+			created = record[i]
+		}
+
+		c, err := newSnapshot(record[columns["name"]], record[columns["country"]], total, record[columns["url"]], created)
+		if err != nil {
+			return nil, &RowError{Line: sr.line, Err: err}
+		}
+		return c, nil
+	}
+	return sr, nil
+}
+
+func newNDJSONSnapshotReader(in io.Reader) *SnapshotReader {
+	scanner := bufio.NewScanner(in)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+
+	sr := &SnapshotReader{}
+	sr.next = func() (*Company, error) {
+		// blank lines are skipped
+		for {
+			if !scanner.Scan() {
+				if err := scanner.Err(); err != nil {
+					return nil, err
+				}
+				return nil, io.EOF
+			}
+			sr.line++
+			if len(bytes.TrimSpace(scanner.Bytes())) > 0 {
+				break
+			}
+		}
+
+		// unknown fields such as id and version are ignored, like unknown CSV columns
+		var input struct {
+			Name    string  `json:"company"`
+			Country string  `json:"country"`
+			Total   *int    `json:"total"`
+			URL     string  `json:"url"`
+			Created *string `json:"created"`
+		}
+		if err := json.Unmarshal(scanner.Bytes(), &input); err != nil {
+			return nil, &RowError{Line: sr.line, Err: fmt.Errorf("badly-formed JSON: %w", err)}
+		}
+		if input.Total == nil {
+			return nil, &RowError{Line: sr.line, Err: errors.New("total must be provided")}
+		}
+		created := ""
+		if input.Created != nil {
+			created = *input.Created
+		}
+
+		c, err := newSnapshot(input.Name, input.Country, *input.Total, input.URL, created)
+		if err != nil {
+			return nil, &RowError{Line: sr.line, Err: err}
+		}
+		return c, nil
+	}
+	return sr
+}
+
+// newSnapshot builds a Company from the fields of a row and validates it. An empty created
+// value leaves the creation time to the database.
+func newSnapshot(name, country string, total int, url, created string) (*Company, error) {
+	c := &Company{Name: name, Country: country, Total: total, URL: url}
+
+	if created != "" {
+		t, err := parseSnapshotTime(created)
+		if err != nil {
+			return nil, err
+		}
+		c.CreatedAt = &t
+	}
+
+	v := validator.New()
+	if ValidateCompany(v, c); !v.Valid() {
+		return nil, validationMessage(v)
+	}
+	return c, nil
+}
+
+// parseSnapshotTime accepts an RFC 3339 timestamp or a plain date
+func parseSnapshotTime(value string) (time.Time, error) {
+	for _, layout := range []string{time.RFC3339, "2006-01-02"} {
+		if t, err := time.Parse(layout, value); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, errors.New("created must be an RFC 3339 timestamp or a date such as 2006-01-02")
+}
+
+// validationMessage flattens the errors held by a validator into a single error, sorted by
+// field so the message is stable
+func validationMessage(v *validator.Validator) error {
+	pairs := make([]string, 0, len(v.Errors))
+	for field, message := range v.Errors {
+		pairs = append(pairs, field+" "+message)
+	}
+	sort.Strings(pairs)
+	return errors.New(strings.Join(pairs, "; "))
+}
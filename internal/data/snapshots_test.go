@@ -0,0 +1,156 @@
+package data
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+// readAll reads every row from a snapshot reader, describing the valid snapshots as
+// "name country total [created]" and collecting the line numbers of the rejected rows
+func readAll(t *testing.T, sr *SnapshotReader) ([]string, []int) {
+	t.Helper()
+
+	var snapshots []string
+	var rejected []int
+	for {
+		c, err := sr.Read()
+		if errors.Is(err, io.EOF) {
+			return snapshots, rejected
+		}
+		var rowErr *RowError
+		if errors.As(err, &rowErr) {
+			if rowErr.Line != sr.Line() {
+				t.Errorf("got RowError on line %d, but Line returns %d", rowErr.Line, sr.Line())
+			}
+			rejected = append(rejected, rowErr.Line)
+			continue
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		snapshot := fmt.Sprintf("%s %s %d", c.Name, c.Country, c.Total)
+		if c.CreatedAt != nil {
+			snapshot += " " + c.CreatedAt.Format(time.RFC3339)
+		}
+		snapshots = append(snapshots, snapshot)
+	}
+}
+
+func TestSnapshotReader(t *testing.T) {
+	tests := []struct {
+		name         string
+		format       string
+		input        string
+		wantRows     []string
+		wantRejected []int
+	}{
+		{
+			name:     "csv",
+			format:   "csv",
+			input:    "company,country,total,url\nAcme,US,12,https://acme.example\nGlobex,CA,3,https://globex.example\n",
+			wantRows: []string{"Acme US 12", "Globex CA 3"},
+		},
+		{
+			name:     "csv with database column names and extra columns",
+			format:   "csv",
+			input:    "id,Vendor,country,amount,url,created_at,version\n1,Acme,US,12,https://acme.example,2024-01-02,1\n",
+			wantRows: []string{"Acme US 12 2024-01-02T00:00:00Z"},
+		},
+		{
+			name:     "csv with RFC 3339 creation time",
+			format:   "csv",
+			input:    "company,country,total,url,created\nAcme,US,12,https://acme.example,2024-01-02T15:04:05Z\n",
+			wantRows: []string{"Acme US 12 2024-01-02T15:04:05Z"},
+		},
+		{
+			name:         "csv invalid rows",
+			format:       "csv",
+			input:        "company,country,total,url\nAcme,US,twelve,https://acme.example\nGlobex,CA,3,https://globex.example\n,US,1,https://initech.example\nInitech,US,-1,https://initech.example\n",
+			wantRows:     []string{"Globex CA 3"},
+			wantRejected: []int{2, 4, 5},
+		},
+		{
+			name:         "csv wrong field count",
+			format:       "csv",
+			input:        "company,country,total,url\nAcme,US\nGlobex,CA,3,https://globex.example\n",
+			wantRows:     []string{"Globex CA 3"},
+			wantRejected: []int{2},
+		},
+		{
+			name:         "csv quoted field spanning lines",
+			format:       "csv",
+			input:        "company,country,total,url\n\"Acme\nCorp\",US,12,https://acme.example\nGlobex,CA,x,https://globex.example\n",
+			wantRows:     []string{"Acme\nCorp US 12"},
+			wantRejected: []int{4},
+		},
+		{
+			name:         "csv invalid creation time",
+			format:       "csv",
+			input:        "company,country,total,url,created\nAcme,US,12,https://acme.example,yesterday\n",
+			wantRejected: []int{2},
+		},
+		{
+			name:     "ndjson",
+			format:   "ndjson",
+			input:    `{"company": "Acme", "country": "US", "total": 12, "url": "https://acme.example", "id": 7}` + "\n" + `{"company": "Globex", "country": "CA", "total": 0, "url": "https://globex.example", "created": "2024-01-02"}`,
+			wantRows: []string{"Acme US 12", "Globex CA 0 2024-01-02T00:00:00Z"},
+		},
+		{
+			name:         "ndjson invalid rows",
+			format:       "ndjson",
+			input:        "{\"company\": \"Acme\"\n\n{\"company\": \"Acme\", \"country\": \"US\", \"url\": \"https://acme.example\"}\n{\"company\": \"Acme\", \"country\": \"US\", \"total\": 12, \"url\": \"https://acme.example\"}\n{\"company\": \"\", \"country\": \"US\", \"total\": 1, \"url\": \"https://acme.example\"}\n",
+			wantRows:     []string{"Acme US 12"},
+			wantRejected: []int{1, 3, 5},
+		},
+		{
+			name:   "empty ndjson",
+			format: "ndjson",
+			input:  "\n\n",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sr, err := NewSnapshotReader(strings.NewReader(tt.input), tt.format)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			rows, rejected := readAll(t, sr)
+			if !slices.Equal(rows, tt.wantRows) {
+				t.Errorf("got rows %q, want %q", rows, tt.wantRows)
+			}
+			if !slices.Equal(rejected, tt.wantRejected) {
+				t.Errorf("got rejected lines %v, want %v", rejected, tt.wantRejected)
+			}
+		})
+	}
+}
+
+func TestSnapshotReaderHeader(t *testing.T) {
+	tests := []struct {
+		name    string
+		format  string
+		input   string
+		wantErr string
+	}{
+		{name: "missing column", format: "csv", input: "company,country,url\nAcme,US,https://acme.example\n", wantErr: "must include a total column"},
+		{name: "missing header", format: "csv", input: "", wantErr: "reading CSV header"},
+		{name: "unknown format", format: "xml", input: "<company/>", wantErr: `unknown snapshot format "xml"`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := NewSnapshotReader(strings.NewReader(tt.input), tt.format)
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("got error %v, want it to contain %q", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,835 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"go.opentelemetry.io/otel/trace"
+	"modernc.org/sqlite"
+	sqlite3 "modernc.org/sqlite/lib"
+)
+
+// The SQLite models run the same queries as the PostgreSQL models, with these differences:
+//
+//   - vendor search uses the jobs_vendor_fts full-text table instead of to_tsvector
+//   - email addresses are compared with the NOCASE collation instead of citext
+//   - timestamps are stored in UTC as text, in the format written by datetime('now')
+//   - arrays are passed as JSON and expanded with json_each
+//
+// The operation names, and so the configurable timeouts, are shared with the PostgreSQL models.
+
+// sqliteTimeFormat is the layout of the timestamps stored by SQLite's datetime function
+const sqliteTimeFormat = "2006-01-02 15:04:05"
+
+// NewSQLiteModels returns a Models struct containing models which store their records in
+// the SQLite database db, each of which cancels its queries after the timeouts given
+func NewSQLiteModels(db *sql.DB, timeouts Timeouts) Models {
+	m := sqliteModels(db, timeouts)
+	m.withTx = func(ctx context.Context, fn func(tx Models) error) error {
+		return runTx(ctx, db, "sqlite", func(tx *sql.Tx) error {
+			return fn(joinTx(sqliteModels(tx, timeouts)))
+		})
+	}
+	return m
+}
+
+// sqliteModels returns the SQLite models running their queries on q
+func sqliteModels(q Queryer, timeouts Timeouts) Models {
+	return Models{
+		Vendors:     SQLiteVendorModel{DB: q, Timeouts: timeouts},
+		Users:       SQLiteUserModel{DB: q, Timeouts: timeouts},
+		Tokens:      SQLiteTokenModel{DB: q, Timeouts: timeouts},
+		Permissions: SQLitePermissionModel{DB: q, Timeouts: timeouts},
+		Rollups:     SQLiteRollupModel{DB: q, Timeouts: timeouts},
+	}
+}
+
+// sqliteTime formats t the way SQLite stores timestamps, so that stored values compare
+// correctly as text
+func sqliteTime(t time.Time) string {
+	return t.UTC().Round(time.Second).Format(sqliteTimeFormat)
+}
+
+// nullDate scans a date or timestamp which may be NULL, leaving the zero time. PostgreSQL
+// and SQLite columns declared as DATE or DATETIME are already turned into a time.Time by
+// the drivers, but SQLite values computed by a query, or passed through a compound SELECT,
+// are text.
+type nullDate struct {
+	Time time.Time
+}
+
+func (d *nullDate) Scan(src any) error {
+	switch v := src.(type) {
+	case nil:
+		d.Time = time.Time{}
+		return nil
+	case time.Time:
+		d.Time = v.UTC()
+		return nil
+	case []byte:
+		src = string(v)
+	}
+
+	s, ok := src.(string)
+	if !ok {
+		return fmt.Errorf("data: cannot scan %T into a date", src)
+	}
+	for _, layout := range []string{sqliteTimeFormat, "2006-01-02", time.RFC3339} {
+		if t, err := time.Parse(layout, s); err == nil {
+			d.Time = t.UTC()
+			return nil
+		}
+	}
+	return fmt.Errorf("data: cannot parse %q as a date", s)
+}
+
+// ftsQuery turns a search into a full-text query matching vendors which contain every word
+// of it, like plainto_tsquery does. Each word is quoted so that the search can't use the
+// FTS5 query syntax.
+func ftsQuery(search string) string {
+	terms := searchTerms(search)
+	for i, term := range terms {
+		terms[i] = `"` + term + `"`
+	}
+	return strings.Join(terms, " ")
+}
+
+// isUniqueViolation reports whether err was caused by a UNIQUE constraint on the column,
+// given as table.column
+func isUniqueViolation(err error, column string) bool {
+	var sqliteErr *sqlite.Error
+	return errors.As(err, &sqliteErr) &&
+		sqliteErr.Code() == sqlite3.SQLITE_CONSTRAINT_UNIQUE &&
+		strings.Contains(sqliteErr.Error(), column)
+}
+
+// SQLiteVendorModel wraps a SQLite connection pool and stores the job snapshots
+type SQLiteVendorModel struct {
+	DB       Queryer
+	Timeouts Timeouts
+}
+
+// Insert will insert the company snapshot into the jobs table, keeping CreatedAt if it is set
+func (m SQLiteVendorModel) Insert(ctx context.Context, c *Company) error {
+	query := `
+			INSERT INTO jobs (vendor, country, amount, url, created_at)
+			VALUES ($1, $2, $3, $4, COALESCE($5, datetime('now')))
+			RETURNING id, created_at, version`
+
+	var created any
+	if c.CreatedAt != nil {
+		created = sqliteTime(*c.CreatedAt)
+	}
+	args := []any{c.Name, c.Country, c.Total, c.URL, created}
+
+	ctx, span := startSQLiteSpan(ctx, "VendorModel.Insert", query)
+	defer span.End()
+
+	ctx, cancel := context.WithTimeout(ctx, m.Timeouts.For("VendorModel.Insert"))
+	defer cancel()
+
+	if err := m.DB.QueryRowContext(ctx, query, args...).Scan(&c.ID, &c.CreatedAt, &c.Version); err != nil {
+		return spanError(ctx, span, err)
+	}
+	spanRows(span, 1)
+	return nil
+}
+
+// GetRecord queries the jobs table for the row with the given id
+func (m SQLiteVendorModel) GetRecord(ctx context.Context, id int64) (*Company, error) {
+	if id < 1 {
+		return nil, ErrRecordNotFound
+	}
+
+	query := `
+			SELECT id, created_at, vendor, country, amount, url, version
+			FROM jobs
+			WHERE id = $1`
+
+	var record Company
+
+	ctx, span := startSQLiteSpan(ctx, "VendorModel.GetRecord", query)
+	defer span.End()
+
+	ctx, cancel := context.WithTimeout(ctx, m.Timeouts.For("VendorModel.GetRecord"))
+	defer cancel()
+
+	if err := m.DB.QueryRowContext(ctx, query, id).Scan(
+		&record.ID,
+		&record.CreatedAt,
+		&record.Name,
+		&record.Country,
+		&record.Total,
+		&record.URL,
+		&record.Version,
+	); err != nil {
+		err = spanError(ctx, span, err)
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+	spanRows(span, 1)
+	return &record, nil
+}
+
+// GetAllRows returns a page of the snapshots matching the filters. SQLite supports window
+// functions, so the total number of matches is still read with count(*) OVER(). Like the
+// PostgreSQL query, purged days are answered with the rollups of their week.
+func (m SQLiteVendorModel) GetAllRows(ctx context.Context, vendor string, total int, created time.Time, filters Filters) ([]*Company, Metadata, error) {
+	totalRecords := 0
+	jobs := []*Company{}
+
+	// FTS5 rejects an empty query even where it is never needed, so the full-text
+	// conditions are only part of the query when there is something to search for
+	search := ftsQuery(vendor)
+	match, rollupMatch := "$1 = ''", "$1 = ''"
+	if search != "" {
+		match = "id IN (SELECT rowid FROM jobs_vendor_fts WHERE jobs_vendor_fts MATCH $1)"
+		rollupMatch = "rowid IN (SELECT rowid FROM jobs_rollup_weekly_fts WHERE jobs_rollup_weekly_fts MATCH $1)"
+	}
+
+	query := fmt.Sprintf(`
+		SELECT count(*) OVER(), id, created_at, vendor, country, amount, url, version,
+			period, min_amount, max_amount, avg_amount, samples
+		FROM (
+			SELECT id, created_at, vendor, country, amount, url, version,
+				NULL AS period, NULL AS min_amount, NULL AS max_amount, NULL AS avg_amount, NULL AS samples
+			FROM jobs
+			WHERE %s AND date(created_at) = $3
+			UNION ALL
+			SELECT 0, period_start || ' 00:00:00', vendor, country, last_amount, url, 0,
+				'week', min_amount, max_amount, avg_amount, samples
+			FROM jobs_rollup_weekly
+			WHERE %s AND period_start = date($3, 'weekday 0', '-6 days')
+			AND $3 < (SELECT purged_before FROM jobs_rollup_state)
+		)
+		WHERE amount > $2
+		ORDER BY %s %s, id ASC
+		LIMIT $4 OFFSET $5`, match, rollupMatch, filters.sortColumn(), filters.sortDirection())
+
+	ctx, span := startSQLiteSpan(ctx, "VendorModel.GetAllRows", query)
+	defer span.End()
+
+	ctx, cancel := context.WithTimeout(ctx, m.Timeouts.For("VendorModel.GetAllRows"))
+	defer cancel()
+
+	args := []any{search, total, created.Format("2006-01-02"), filters.limit(), filters.offset()}
+
+	rows, err := m.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, Metadata{}, spanError(ctx, span, err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var country Company
+		var created nullDate
+		var rollup listedRollup
+		dest := []any{
+			&totalRecords,
+			&country.ID,
+			&created,
+			&country.Name,
+			&country.Country,
+			&country.Total,
+			&country.URL,
+			&country.Version,
+		}
+		if err := rows.Scan(append(dest, rollup.dest()...)...); err != nil {
+			return nil, Metadata{}, spanError(ctx, span, err)
+		}
+		country.CreatedAt = &created.Time
+		country.Rollup = rollup.stats(&country)
+		jobs = append(jobs, &country)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, Metadata{}, spanError(ctx, span, err)
+	}
+	spanRows(span, int64(len(jobs)))
+
+	metadata := calculateMetadata(totalRecords, filters.Page, filters.PageSize)
+	return jobs, metadata, nil
+}
+
+// GetRows returns today's snapshots of a vendor for every country with open jobs
+func (m SQLiteVendorModel) GetRows(ctx context.Context, vendor string) ([]*Company, error) {
+	if vendor == "" {
+		return nil, ErrRecordNotFound
+	}
+	countries := make([]*Company, 0)
+
+	query := `SELECT id, created_at, country, amount, url, version
+				FROM jobs
+				WHERE vendor = $1 AND date(created_at) = date('now') AND amount > 0 ORDER BY country`
+
+	ctx, span := startSQLiteSpan(ctx, "VendorModel.GetRows", query)
+	defer span.End()
+
+	ctx, cancel := context.WithTimeout(ctx, m.Timeouts.For("VendorModel.GetRows"))
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, vendor)
+	if err != nil {
+		return nil, spanError(ctx, span, err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		country := Company{}
+		if err := rows.Scan(
+			&country.ID,
+			&country.CreatedAt,
+			&country.Country,
+			&country.Total,
+			&country.URL,
+			&country.Version,
+		); err != nil {
+			return nil, spanError(ctx, span, err)
+		}
+		countries = append(countries, &country)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, spanError(ctx, span, err)
+	}
+	spanRows(span, int64(len(countries)))
+
+	if len(countries) == 0 {
+		return nil, ErrRecordNotFound
+	}
+	return countries, nil
+}
+
+// Update will update the record if its version hasn't changed since it was read
+func (m SQLiteVendorModel) Update(ctx context.Context, c *Company) error {
+	query := `
+			UPDATE jobs
+			SET vendor = $1, country = $2, amount = $3, url = $4, version = version + 1
+			WHERE id = $5 AND version = $6
+			RETURNING version`
+
+	args := []any{
+		c.Name,
+		c.Country,
+		c.Total,
+		c.URL,
+		c.ID,
+		c.Version,
+	}
+
+	ctx, span := startSQLiteSpan(ctx, "VendorModel.Update", query)
+	defer span.End()
+
+	ctx, cancel := context.WithTimeout(ctx, m.Timeouts.For("VendorModel.Update"))
+	defer cancel()
+
+	if err := m.DB.QueryRowContext(ctx, query, args...).Scan(&c.Version); err != nil {
+		err = spanError(ctx, span, err)
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrEditConflict
+		default:
+			return err
+		}
+	}
+	spanRows(span, 1)
+	return nil
+}
+
+// Delete will delete a record from the jobs table if it exists at the given version
+func (m SQLiteVendorModel) Delete(ctx context.Context, id int64, version int32) error {
+	if id < 1 {
+		return ErrRecordNotFound
+	}
+
+	query := `
+			DELETE FROM jobs
+			WHERE id = $1 AND version = $2`
+
+	ctx, span := startSQLiteSpan(ctx, "VendorModel.Delete", query)
+	defer span.End()
+
+	ctx, cancel := context.WithTimeout(ctx, m.Timeouts.For("VendorModel.Delete"))
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, id, version)
+	if err != nil {
+		return spanError(ctx, span, err)
+	}
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return spanError(ctx, span, err)
+	}
+	spanRows(span, rows)
+	if rows == 0 {
+		return missingOrStale(ctx, span, m.DB, id)
+	}
+
+	return nil
+}
+
+// SQLiteUserModel wraps a SQLite connection pool and stores user accounts
+type SQLiteUserModel struct {
+	DB       Queryer
+	Timeouts Timeouts
+}
+
+// Insert will insert a new user, returning ErrDuplicateEmail if the email address is taken
+func (m SQLiteUserModel) Insert(ctx context.Context, user *User) error {
+	query := `
+		INSERT INTO users (name, email, password_hash, activated)
+		VALUES ($1, $2, $3, $4)
+		RETURNING id, created_at, version`
+
+	args := []any{user.Name, user.Email, user.Password.hash, user.Activated}
+
+	ctx, span := startSQLiteSpan(ctx, "UserModel.Insert", query)
+	defer span.End()
+
+	ctx, cancel := context.WithTimeout(ctx, m.Timeouts.For("UserModel.Insert"))
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, args...).Scan(&user.ID, &user.CreatedAt, &user.Version)
+	if err != nil {
+		err = spanError(ctx, span, err)
+		switch {
+		case isUniqueViolation(err, "users.email"):
+			return ErrDuplicateEmail
+		default:
+			return err
+		}
+	}
+	spanRows(span, 1)
+	return nil
+}
+
+// GetByEmail retrieves the user with the email address, ignoring case
+func (m SQLiteUserModel) GetByEmail(ctx context.Context, email string) (*User, error) {
+	query := `
+		SELECT id, created_at, name, email, password_hash, activated, version
+		FROM users
+		WHERE email = $1`
+
+	var user User
+
+	ctx, span := startSQLiteSpan(ctx, "UserModel.GetByEmail", query)
+	defer span.End()
+
+	ctx, cancel := context.WithTimeout(ctx, m.Timeouts.For("UserModel.GetByEmail"))
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, email).Scan(
+		&user.ID,
+		&user.CreatedAt,
+		&user.Name,
+		&user.Email,
+		&user.Password.hash,
+		&user.Activated,
+		&user.Version,
+	)
+	if err != nil {
+		err = spanError(ctx, span, err)
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+	spanRows(span, 1)
+	return &user, nil
+}
+
+// Update the details of a user if the version hasn't changed since they were read
+func (m SQLiteUserModel) Update(ctx context.Context, user *User) error {
+	query := `
+		UPDATE users
+		SET name = $1, email = $2, password_hash = $3, activated = $4, version = version + 1
+		WHERE id = $5 AND version = $6
+		RETURNING version`
+
+	args := []any{
+		user.Name,
+		user.Email,
+		user.Password.hash,
+		user.Activated,
+		user.ID,
+		user.Version,
+	}
+
+	ctx, span := startSQLiteSpan(ctx, "UserModel.Update", query)
+	defer span.End()
+
+	ctx, cancel := context.WithTimeout(ctx, m.Timeouts.For("UserModel.Update"))
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, args...).Scan(&user.Version)
+	if err != nil {
+		err = spanError(ctx, span, err)
+		switch {
+		case isUniqueViolation(err, "users.email"):
+			return ErrDuplicateEmail
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrEditConflict
+		default:
+			return err
+		}
+	}
+
+	spanRows(span, 1)
+	return nil
+}
+
+// GetAllInactive retrieves every user who has not activated their account yet, oldest first
+func (m SQLiteUserModel) GetAllInactive(ctx context.Context) ([]*User, error) {
+	query := `
+		SELECT id, created_at, name, email, password_hash, activated, version
+		FROM users
+		WHERE NOT activated
+		ORDER BY id`
+
+	ctx, span := startSQLiteSpan(ctx, "UserModel.GetAllInactive", query)
+	defer span.End()
+
+	ctx, cancel := context.WithTimeout(ctx, m.Timeouts.For("UserModel.GetAllInactive"))
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query)
+	if err != nil {
+		return nil, spanError(ctx, span, err)
+	}
+	defer rows.Close()
+
+	users := []*User{}
+	for rows.Next() {
+		var user User
+		if err := rows.Scan(
+			&user.ID,
+			&user.CreatedAt,
+			&user.Name,
+			&user.Email,
+			&user.Password.hash,
+			&user.Activated,
+			&user.Version,
+		); err != nil {
+			return nil, spanError(ctx, span, err)
+		}
+		users = append(users, &user)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, spanError(ctx, span, err)
+	}
+	spanRows(span, int64(len(users)))
+	return users, nil
+}
+
+// SQLiteTokenModel wraps a SQLite connection pool and stores the tokens issued to users
+type SQLiteTokenModel struct {
+	DB       Queryer
+	Timeouts Timeouts
+}
+
+// New generates a token for the user and stores it
+func (m SQLiteTokenModel) New(ctx context.Context, userID int64, ttl time.Duration, scope string) (*Token, error) {
+	token, err := generateToken(userID, ttl, scope)
+	if err != nil {
+		return nil, err
+	}
+
+	err = m.Insert(ctx, token)
+	return token, err
+}
+
+// Insert stores the hash of a token
+func (m SQLiteTokenModel) Insert(ctx context.Context, token *Token) error {
+	query := `
+		INSERT INTO tokens (hash, user_id, expiry, scope)
+		VALUES ($1, $2, $3, $4)`
+
+	args := []any{token.Hash, token.UserID, sqliteTime(token.Expiry), token.Scope}
+
+	ctx, span := startSQLiteSpan(ctx, "TokenModel.Insert", query)
+	defer span.End()
+
+	ctx, cancel := context.WithTimeout(ctx, m.Timeouts.For("TokenModel.Insert"))
+	defer cancel()
+
+	if _, err := m.DB.ExecContext(ctx, query, args...); err != nil {
+		return spanError(ctx, span, err)
+	}
+	spanRows(span, 1)
+	return nil
+}
+
+// DeleteAllForUser deletes every token with the scope which was issued to the user
+func (m SQLiteTokenModel) DeleteAllForUser(ctx context.Context, scope string, userID int64) error {
+	query := `
+		DELETE FROM tokens
+		WHERE scope = $1 AND user_id = $2`
+
+	ctx, span := startSQLiteSpan(ctx, "TokenModel.DeleteAllForUser", query)
+	defer span.End()
+
+	ctx, cancel := context.WithTimeout(ctx, m.Timeouts.For("TokenModel.DeleteAllForUser"))
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, scope, userID)
+	if err != nil {
+		return spanError(ctx, span, err)
+	}
+	if rows, err := result.RowsAffected(); err == nil {
+		spanRows(span, rows)
+	}
+	return nil
+}
+
+// DeleteExpired deletes every token past its expiry and returns how many were deleted
+func (m SQLiteTokenModel) DeleteExpired(ctx context.Context) (int64, error) {
+	query := `
+		DELETE FROM tokens
+		WHERE expiry < datetime('now')`
+
+	ctx, span := startSQLiteSpan(ctx, "TokenModel.DeleteExpired", query)
+	defer span.End()
+
+	ctx, cancel := context.WithTimeout(ctx, m.Timeouts.For("TokenModel.DeleteExpired"))
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query)
+	if err != nil {
+		return 0, spanError(ctx, span, err)
+	}
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return 0, spanError(ctx, span, err)
+	}
+	spanRows(span, rows)
+	return rows, nil
+}
+
+// CountExpired returns how many tokens are past their expiry
+func (m SQLiteTokenModel) CountExpired(ctx context.Context) (int64, error) {
+	query := `
+		SELECT count(*)
+		FROM tokens
+		WHERE expiry < datetime('now')`
+
+	ctx, span := startSQLiteSpan(ctx, "TokenModel.CountExpired", query)
+	defer span.End()
+
+	ctx, cancel := context.WithTimeout(ctx, m.Timeouts.For("TokenModel.CountExpired"))
+	defer cancel()
+
+	var count int64
+	if err := m.DB.QueryRowContext(ctx, query).Scan(&count); err != nil {
+		return 0, spanError(ctx, span, err)
+	}
+	spanRows(span, 1)
+	return count, nil
+}
+
+// SQLitePermissionModel wraps a SQLite connection pool and stores the permissions granted
+// to users
+type SQLitePermissionModel struct {
+	DB       Queryer
+	Timeouts Timeouts
+}
+
+// GetAll returns the code of every permission which can be granted
+func (m SQLitePermissionModel) GetAll(ctx context.Context) (Permissions, error) {
+	query := `
+		SELECT code
+		FROM permissions
+		ORDER BY code`
+
+	ctx, span := startSQLiteSpan(ctx, "PermissionModel.GetAll", query)
+	defer span.End()
+
+	ctx, cancel := context.WithTimeout(ctx, m.Timeouts.For("PermissionModel.GetAll"))
+	defer cancel()
+
+	return m.queryCodes(ctx, span, query)
+}
+
+// GetAllForUser returns the codes of the permissions granted to the user
+func (m SQLitePermissionModel) GetAllForUser(ctx context.Context, userID int64) (Permissions, error) {
+	query := `
+		SELECT permissions.code
+		FROM permissions
+		INNER JOIN users_permissions ON users_permissions.permission_id = permissions.id
+		WHERE users_permissions.user_id = $1
+		ORDER BY permissions.code`
+
+	ctx, span := startSQLiteSpan(ctx, "PermissionModel.GetAllForUser", query)
+	defer span.End()
+
+	ctx, cancel := context.WithTimeout(ctx, m.Timeouts.For("PermissionModel.GetAllForUser"))
+	defer cancel()
+
+	return m.queryCodes(ctx, span, query, userID)
+}
+
+// queryCodes runs a query selecting permission codes and collects them
+func (m SQLitePermissionModel) queryCodes(ctx context.Context, span trace.Span, query string, args ...any) (Permissions, error) {
+	rows, err := m.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, spanError(ctx, span, err)
+	}
+	defer rows.Close()
+
+	var permissions Permissions
+	for rows.Next() {
+		var permission string
+		if err := rows.Scan(&permission); err != nil {
+			return nil, spanError(ctx, span, err)
+		}
+		permissions = append(permissions, permission)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, spanError(ctx, span, err)
+	}
+	spanRows(span, int64(len(permissions)))
+	return permissions, nil
+}
+
+// AddForUser grants the permissions with the given codes to the user, ignoring unknown
+// codes and permissions the user already has. The codes are passed as a JSON array, as
+// SQLite has no array type.
+func (m SQLitePermissionModel) AddForUser(ctx context.Context, userID int64, codes ...string) error {
+	query := `
+		INSERT INTO users_permissions
+		SELECT $1, permissions.id FROM permissions WHERE permissions.code IN (SELECT value FROM json_each($2))
+		ON CONFLICT DO NOTHING`
+
+	encoded, err := json.Marshal(codes)
+	if err != nil {
+		return err
+	}
+
+	ctx, span := startSQLiteSpan(ctx, "PermissionModel.AddForUser", query)
+	defer span.End()
+
+	ctx, cancel := context.WithTimeout(ctx, m.Timeouts.For("PermissionModel.AddForUser"))
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, userID, string(encoded))
+	if err != nil {
+		return spanError(ctx, span, err)
+	}
+	if rows, err := result.RowsAffected(); err == nil {
+		spanRows(span, rows)
+	}
+	return nil
+}
+
+// SQLiteRollupModel maintains the SQLite rollup tables. Like RollupModel, its Refresh and
+// Purge should run through Models.WithTx.
+type SQLiteRollupModel struct {
+	DB       Queryer
+	Timeouts Timeouts
+}
+
+// sqliteTrunc returns the expression giving the start of the period of a snapshot. The
+// weekday modifier moves forward to the Sunday ending the week, so that going back six days
+// lands on its Monday.
+func sqliteTrunc(p rollupPeriod) string {
+	return sqlitePeriodStart(p, "created_at")
+}
+
+// sqlitePeriodStart returns the expression giving the start of the period of p containing
+// the date or timestamp held by column
+func sqlitePeriodStart(p rollupPeriod, column string) string {
+	if p.name == PeriodMonth {
+		return fmt.Sprintf("date(%s, 'start of month')", column)
+	}
+	return fmt.Sprintf("date(%s, 'weekday 0', '-6 days')", column)
+}
+
+// sqliteRollups maintains the rollups like pgRollups. SQLite runs one write transaction at
+// a time, so the state needs no lock.
+var sqliteRollups = rollupDialect{
+	startSpan: startSQLiteSpan,
+	day:       "date(created_at)",
+	trunc: func(p rollupPeriod) string {
+		return sqlitePeriodStart(p, "period_start")
+	},
+	least:     "min",
+	greatest:  "max",
+	timestamp: func(t time.Time) any { return sqliteTime(t) },
+}
+
+// Refresh rolls up the next batch of days, like RollupModel.Refresh
+func (m SQLiteRollupModel) Refresh(ctx context.Context, now time.Time) (int64, bool, error) {
+	return rollupMaintainer{m.DB, sqliteRollups, m.Timeouts}.refresh(ctx, now)
+}
+
+// Purge deletes the raw snapshots of the next batch of days which have been rolled up, like
+// RollupModel.Purge
+func (m SQLiteRollupModel) Purge(ctx context.Context, before time.Time) (int64, bool, error) {
+	return rollupMaintainer{m.DB, sqliteRollups, m.Timeouts}.purge(ctx, before)
+}
+
+// History returns the rollups of a vendor over every period overlapping from to to, reading
+// the periods which have been rolled up from the rollup tables and summarising the rest
+// from the raw snapshots
+func (m SQLiteRollupModel) History(ctx context.Context, vendor, country, period string, from, to time.Time) ([]*Rollup, error) {
+	p, err := findPeriod(period)
+	if err != nil {
+		return nil, err
+	}
+	from, to = historyRange(period, from, to)
+
+	// dates are compared as text, and an empty string sorts before every one of them
+	through := fmt.Sprintf("(SELECT coalesce(%s, '') FROM jobs_rollup_state)", p.through)
+	query := fmt.Sprintf(`
+		SELECT %[1]s
+		FROM %[2]s
+		WHERE vendor = $1 AND ($2 = '' OR country = $2)
+		AND period_start >= $3 AND period_start < $4
+		AND period_start < %[3]s
+		UNION ALL
+		%[4]s
+		ORDER BY country, period_start`, rollupColumns, p.table, through,
+		rollupSelect(sqliteTrunc(p), fmt.Sprintf(`vendor = $1 AND ($2 = '' OR country = $2)
+				AND created_at >= max($3, %s) AND created_at < $4`, through), ""))
+
+	ctx, span := startSQLiteSpan(ctx, "RollupModel.History", query)
+	defer span.End()
+
+	ctx, cancel := context.WithTimeout(ctx, m.Timeouts.For("RollupModel.History"))
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, vendor, country, from.Format("2006-01-02"), to.Format("2006-01-02"))
+	if err != nil {
+		return nil, spanError(ctx, span, err)
+	}
+	defer rows.Close()
+
+	rollups := []*Rollup{}
+	for rows.Next() {
+		var start nullDate
+		r := Rollup{RollupStats: RollupStats{Period: period}}
+		if err := rows.Scan(&r.Vendor, &r.Country, &start, &r.Min, &r.Max, &r.Avg, &r.Last, &r.URL, &r.Samples); err != nil {
+			return nil, spanError(ctx, span, err)
+		}
+		r.Start = start.Time
+		r.End = periodEnd(period, r.Start)
+		rollups = append(rollups, &r)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, spanError(ctx, span, err)
+	}
+	spanRows(span, int64(len(rollups)))
+
+	return rollups, nil
+}
@@ -0,0 +1,119 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"net"
+	"time"
+
+	"github.com/lib/pq"
+	"modernc.org/sqlite"
+	sqlite3 "modernc.org/sqlite/lib"
+)
+
+// DefaultTimeout is the query timeout used for any operation without a timeout of its own
+const DefaultTimeout = 3 * time.Second
+
+// ErrUnavailable wraps errors caused by the database being unreachable, as opposed to a
+// query failing, so callers can tell clients to retry later
+var ErrUnavailable = errors.New("database unavailable")
+
+// operations lists the name of every model method that runs a query, which are the names
+// timeouts can be configured for
+var operations = map[string]bool{
+	"VendorModel.Insert":            true,
+	"VendorModel.GetRecord":         true,
+	"VendorModel.GetAllRows":        true,
+	"VendorModel.GetRows":           true,
+	"VendorModel.Update":            true,
+	"VendorModel.Delete":            true,
+	"UserModel.Insert":              true,
+	"UserModel.GetByEmail":          true,
+	"UserModel.Update":              true,
+	"UserModel.GetAllInactive":      true,
+	"TokenModel.Insert":             true,
+	"TokenModel.DeleteAllForUser":   true,
+	"TokenModel.DeleteExpired":      true,
+	"TokenModel.CountExpired":       true,
+	"PermissionModel.GetAll":        true,
+	"PermissionModel.GetAllForUser": true,
+	"PermissionModel.AddForUser":    true,
+	"RollupModel.Refresh":           true,
+	"RollupModel.Purge":             true,
+	"RollupModel.History":           true,
+}
+
+// maintenanceTimeouts holds the default timeouts of the operations maintaining the rollups
+// in the background. Each call works through a batch of days, which can take far longer
+// than the queries serving requests, and a timeout meant for those would stop the rollups
+// from ever making progress.
+var maintenanceTimeouts = map[string]time.Duration{
+	"RollupModel.Refresh": 10 * time.Minute,
+	"RollupModel.Purge":   10 * time.Minute,
+}
+
+// ValidOperation reports whether name is the name of a model operation, such as
+// VendorModel.GetAllRows
+func ValidOperation(name string) bool {
+	return operations[name]
+}
+
+// Timeouts holds how long each model operation may run before its query is cancelled
+type Timeouts struct {
+	// Default applies to every operation not listed in Operations, other than the
+	// maintenance operations, which have long defaults of their own. DefaultTimeout is used
+	// if it is zero.
+	Default time.Duration
+	// Operations holds the timeouts of individual operations, keyed by operation name
+	Operations map[string]time.Duration
+}
+
+// For returns the timeout of the named operation
+func (t Timeouts) For(operation string) time.Duration {
+	if timeout, ok := t.Operations[operation]; ok {
+		return timeout
+	}
+	if timeout, ok := maintenanceTimeouts[operation]; ok {
+		return timeout
+	}
+	if t.Default > 0 {
+		return t.Default
+	}
+	return DefaultTimeout
+}
+
+// queryError makes the cause of a failed query recognisable to callers. A query cancelled
+// by the server because its context was done is reported as the context's error (either
+// context.Canceled or context.DeadlineExceeded), and a failure to reach the database, or
+// for SQLite to lock it, is wrapped in ErrUnavailable. Any other error is returned unchanged.
+func queryError(ctx context.Context, err error) error {
+	var (
+		pqErr     *pq.Error
+		sqliteErr *sqlite.Error
+	)
+	switch {
+	case errors.Is(err, context.Canceled), errors.Is(err, context.DeadlineExceeded):
+		return err
+	case errors.As(err, &pqErr) && pqErr.Code == "57014" && ctx.Err() != nil:
+		// query_canceled: lib/pq asks the server to cancel the statement when the context
+		// is done, and the server's reply can arrive before database/sql notices
+		return fmt.Errorf("%w: %v", ctx.Err(), err)
+	case errors.As(err, &sqliteErr) && sqliteErr.Code() == sqlite3.SQLITE_INTERRUPT && ctx.Err() != nil:
+		// the SQLite driver interrupts the statement when the context is done
+		return fmt.Errorf("%w: %v", ctx.Err(), err)
+	case errors.As(err, &sqliteErr) && sqliteErr.Code()&0xff == sqlite3.SQLITE_BUSY:
+		// another connection held the write lock for longer than the busy timeout
+		return fmt.Errorf("%w: %w", ErrUnavailable, err)
+	case errors.Is(err, driver.ErrBadConn), errors.Is(err, sql.ErrConnDone):
+		return fmt.Errorf("%w: %w", ErrUnavailable, err)
+	}
+
+	var netErr *net.OpError
+	if errors.As(err, &netErr) {
+		return fmt.Errorf("%w: %w", ErrUnavailable, err)
+	}
+	return err
+}
@@ -4,7 +4,6 @@ import (
 	"context"
 	"crypto/rand"
 	"crypto/sha256"
-	"database/sql"
 	"encoding/base32"
 	"github.com/sparkycj328/JobAIO-API/internal/validator"
 	"time"
@@ -68,45 +67,107 @@ func ValidateTokenPlaintext(v *validator.Validator, tokenPlaintext string) {
 
 // TokenModel defines the TokenModel type and wraps the db connection pool
 type TokenModel struct {
-	DB *sql.DB
+	DB       Queryer
+	Timeouts Timeouts
 }
 
 // New method is a shortcut which creates a new Token struct and then inserts the
 // data in the tokens table.
-func (m TokenModel) New(userId int64, ttl time.Duration, scope string) (*Token, error) {
+func (m TokenModel) New(ctx context.Context, userId int64, ttl time.Duration, scope string) (*Token, error) {
 	token, err := generateToken(userId, ttl, scope)
 	if err != nil {
 		return nil, err
 	}
 
-	err = m.Insert(token)
+	err = m.Insert(ctx, token)
 	return token, err
 }
 
 // Insert adds the data for a specific token to the tokens table.
-func (m TokenModel) Insert(token *Token) error {
+func (m TokenModel) Insert(ctx context.Context, token *Token) error {
 	query := `
 		INSERT INTO tokens (hash, user_id, expiry, scope)
 		VALUES($1, $2, $3, $4)
 `
 	args := []any{token.Hash, token.UserID, token.Expiry, token.Scope}
 
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	ctx, span := startSpan(ctx, "TokenModel.Insert", query)
+	defer span.End()
+
+	ctx, cancel := context.WithTimeout(ctx, m.Timeouts.For("TokenModel.Insert"))
 	defer cancel()
 
-	_, err := m.DB.ExecContext(ctx, query, args...)
-	return err
+	if _, err := m.DB.ExecContext(ctx, query, args...); err != nil {
+		return spanError(ctx, span, err)
+	}
+	spanRows(span, 1)
+	return nil
 }
 
 // DeleteAllForUser deletes all tokens for a specific user and scope.
-func (m TokenModel) DeleteAllForUser(scope string, userID int64) error {
+func (m TokenModel) DeleteAllForUser(ctx context.Context, scope string, userID int64) error {
 	query := `
 		DELETE FROM tokens
 		WHERE scope = $1 and user_id = $2`
 
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	ctx, span := startSpan(ctx, "TokenModel.DeleteAllForUser", query)
+	defer span.End()
+
+	ctx, cancel := context.WithTimeout(ctx, m.Timeouts.For("TokenModel.DeleteAllForUser"))
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, scope, userID)
+	if err != nil {
+		return spanError(ctx, span, err)
+	}
+	if rows, err := result.RowsAffected(); err == nil {
+		spanRows(span, rows)
+	}
+	return nil
+}
+
+// DeleteExpired deletes every token whose expiry time has passed and returns how many
+// tokens were removed
+func (m TokenModel) DeleteExpired(ctx context.Context) (int64, error) {
+	query := `
+		DELETE FROM tokens
+		WHERE expiry < NOW()`
+
+	ctx, span := startSpan(ctx, "TokenModel.DeleteExpired", query)
+	defer span.End()
+
+	ctx, cancel := context.WithTimeout(ctx, m.Timeouts.For("TokenModel.DeleteExpired"))
 	defer cancel()
 
-	_, err := m.DB.ExecContext(ctx, query, scope, userID)
-	return err
+	result, err := m.DB.ExecContext(ctx, query)
+	if err != nil {
+		return 0, spanError(ctx, span, err)
+	}
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return 0, spanError(ctx, span, err)
+	}
+	spanRows(span, rows)
+	return rows, nil
+}
+
+// CountExpired returns how many tokens have passed their expiry time
+func (m TokenModel) CountExpired(ctx context.Context) (int64, error) {
+	query := `
+		SELECT count(*)
+		FROM tokens
+		WHERE expiry < NOW()`
+
+	ctx, span := startSpan(ctx, "TokenModel.CountExpired", query)
+	defer span.End()
+
+	ctx, cancel := context.WithTimeout(ctx, m.Timeouts.For("TokenModel.CountExpired"))
+	defer cancel()
+
+	var count int64
+	if err := m.DB.QueryRowContext(ctx, query).Scan(&count); err != nil {
+		return 0, spanError(ctx, span, err)
+	}
+	spanRows(span, 1)
+	return count, nil
 }
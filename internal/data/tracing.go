@@ -0,0 +1,57 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"strings"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// tracer creates the spans wrapped around the SQL of every model method. It uses the global
+// tracer provider, so spans are only recorded once cmd/api has configured an exporter.
+var tracer = otel.Tracer("github.com/sparkycj328/JobAIO-API/internal/data")
+
+// startSpan starts a client span for a single model method, named after the method (such as
+// VendorModel.Insert) and carrying the SQL statement it runs. The caller must end the span.
+func startSpan(ctx context.Context, operation, query string) (context.Context, trace.Span) {
+	return tracer.Start(ctx, operation,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(
+			attribute.String("db.system", "postgresql"),
+			attribute.String("db.operation.name", operation),
+			attribute.String("db.query.text", strings.Join(strings.Fields(query), " ")),
+		),
+	)
+}
+
+// spanRows records how many rows a statement returned or affected
+func spanRows(span trace.Span, rows int64) {
+	span.SetAttributes(attribute.Int64("db.rows_affected", rows))
+}
+
+// spanError classifies err with queryError, records it on the span and returns it. A query
+// which simply finds no rows is an expected outcome rather than a database failure, so it
+// is noted on the span without marking the span as failed.
+func spanError(ctx context.Context, span trace.Span, err error) error {
+	if errors.Is(err, sql.ErrNoRows) {
+		span.SetAttributes(attribute.Bool("db.no_rows", true))
+		return err
+	}
+	err = queryError(ctx, err)
+	span.RecordError(err)
+	span.SetStatus(codes.Error, err.Error())
+	return err
+}
+
+// startSQLiteSpan starts the span of a SQLite model method. It only differs from the span of
+// the matching PostgreSQL method in the database system recorded.
+func startSQLiteSpan(ctx context.Context, operation, query string) (context.Context, trace.Span) {
+	ctx, span := startSpan(ctx, operation, query)
+	span.SetAttributes(attribute.String("db.system", "sqlite"))
+	return ctx, span
+}
@@ -0,0 +1,84 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Queryer is satisfied by both *sql.DB and *sql.Tx, so the same model can run its queries
+// on the connection pool or inside a transaction
+type Queryer interface {
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+	QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row
+}
+
+// WithTx runs fn as a single unit of work. Every call fn makes through the tx models is
+// committed together when fn returns nil, and rolled back if fn returns an error or panics,
+// in which case the error is returned or the panic carries on once the rollback is done.
+//
+// fn must only use tx. Calls through m itself are not part of the transaction, and with the
+// in-memory stores they block until the transaction has finished. Calling WithTx on tx
+// joins the transaction that is already open.
+func (m Models) WithTx(ctx context.Context, fn func(tx Models) error) error {
+	if m.withTx == nil {
+		return errors.New("data: models do not support transactions")
+	}
+	return m.withTx(ctx, fn)
+}
+
+// joinTx returns the tx models with WithTx set to run further units of work in the same
+// transaction
+func joinTx(tx Models) Models {
+	tx.withTx = func(ctx context.Context, fn func(tx Models) error) error {
+		return fn(tx)
+	}
+	return tx
+}
+
+// runTx begins a transaction on db and runs fn in it, committing or rolling back as
+// described by WithTx. The transaction gets a span of its own, so that the spans of the
+// statements run in it are nested under it.
+func runTx(ctx context.Context, db *sql.DB, system string, fn func(tx *sql.Tx) error) (err error) {
+	ctx, span := tracer.Start(ctx, "Models.WithTx",
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(attribute.String("db.system", system)),
+	)
+	defer func() {
+		if err != nil {
+			span.RecordError(err)
+			span.SetStatus(codes.Error, err.Error())
+		}
+		span.End()
+	}()
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return queryError(ctx, err)
+	}
+
+	defer func() {
+		if p := recover(); p != nil {
+			tx.Rollback()
+			panic(p)
+		}
+	}()
+
+	if err := fn(tx); err != nil {
+		if rbErr := tx.Rollback(); rbErr != nil && !errors.Is(rbErr, sql.ErrTxDone) {
+			return fmt.Errorf("%w (rollback failed: %v)", err, rbErr)
+		}
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return queryError(ctx, err)
+	}
+	return nil
+}
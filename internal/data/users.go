@@ -38,14 +38,17 @@ type password struct {
 }
 
 // Set method calculates the bcrypt hash of a plaintext password, and stores both
-// the hash and the plaintext versions in the struct.
+// the hash and the plaintext versions in the struct. The plaintext is kept even when
+// hashing fails, so that ValidateUser can report a password bcrypt refused, such as one
+// longer than 72 bytes.
 func (p *password) Set(plaintextPassword string) error {
+	p.plaintext = &plaintextPassword
+
 	hash, err := bcrypt.GenerateFromPassword([]byte(plaintextPassword), 12)
 	if err != nil {
 		return err
 	}
 
-	p.plaintext = &plaintextPassword
 	p.hash = hash
 
 	return nil
@@ -94,21 +97,23 @@ func ValidateUser(v *validator.Validator, user *User) {
 	// codebase (probably because we forgot to set a password for the user). It's a
 	// useful sanity check to include here, but it's not a problem with the data
 	// provided by the client. So rather than adding an error to the validation map we
-	// raise a panic instead.
-	if user.Password.hash == nil {
+	// raise a panic instead. A password bcrypt refused to hash has already failed
+	// validation above, so that case is left to the client error.
+	if user.Password.hash == nil && v.Valid() {
 		panic("missing password hash for user")
 	}
 }
 
 // UserModel wraps the connection pool
 type UserModel struct {
-	DB *sql.DB
+	DB       Queryer
+	Timeouts Timeouts
 }
 
 // Insert will insert a new record in the database for the user. Id, created_at, and
 // version fields are all automatically generated by our database when creating a new record.
 // These will be returned and read into the User struct after the insert.
-func (m UserModel) Insert(user *User) error {
+func (m UserModel) Insert(ctx context.Context, user *User) error {
 	query := `
 		INSERT INTO users(name, email, password_hash, activated)
 		VALUES ($1, $2, $3, $4)
@@ -116,7 +121,10 @@ func (m UserModel) Insert(user *User) error {
 
 	args := []any{user.Name, user.Email, user.Password.hash, user.Activated}
 
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	ctx, span := startSpan(ctx, "UserModel.Insert", query)
+	defer span.End()
+
+	ctx, cancel := context.WithTimeout(ctx, m.Timeouts.For("UserModel.Insert"))
 	defer cancel()
 
 	// If the table already contains a record with this email address, then when we try
@@ -125,6 +133,7 @@ func (m UserModel) Insert(user *User) error {
 	// specifically, and return custom ErrDuplicateEmail error instead.
 	err := m.DB.QueryRowContext(ctx, query, args...).Scan(&user.ID, &user.CreatedAt, &user.Version)
 	if err != nil {
+		err = spanError(ctx, span, err)
 		switch {
 		case err.Error() == `pq: duplicate key value violates unique constraint "users_email_key"`:
 			return ErrDuplicateEmail
@@ -132,13 +141,14 @@ func (m UserModel) Insert(user *User) error {
 			return err
 		}
 	}
+	spanRows(span, 1)
 	return nil
 }
 
 // GetByEmail retrieves the User details from the database based on the user's email address.
 // Because we have a UNIQUE constraint on the email column, this SQL query will only
 // return one record (or none at all, in which case we return a ErrRecordNotFound error).
-func (m UserModel) GetByEmail(email string) (*User, error) {
+func (m UserModel) GetByEmail(ctx context.Context, email string) (*User, error) {
 	query := ` 
 		SELECT id, created_at, name, email, password_hash, activated, version
 		FROM users
@@ -146,7 +156,10 @@ func (m UserModel) GetByEmail(email string) (*User, error) {
 
 	var user User
 
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	ctx, span := startSpan(ctx, "UserModel.GetByEmail", query)
+	defer span.End()
+
+	ctx, cancel := context.WithTimeout(ctx, m.Timeouts.For("UserModel.GetByEmail"))
 	defer cancel()
 
 	err := m.DB.QueryRowContext(ctx, query, email).Scan(
@@ -160,6 +173,7 @@ func (m UserModel) GetByEmail(email string) (*User, error) {
 	)
 
 	if err != nil {
+		err = spanError(ctx, span, err)
 		switch {
 		case errors.Is(err, sql.ErrNoRows):
 			return nil, ErrRecordNotFound
@@ -167,6 +181,7 @@ func (m UserModel) GetByEmail(email string) (*User, error) {
 			return nil, err
 		}
 	}
+	spanRows(span, 1)
 	return &user, nil
 }
 
@@ -175,7 +190,7 @@ func (m UserModel) GetByEmail(email string) (*User, error) {
 // when updating a movie. And we also check for a violation of the "users_email_key"
 // constraint when performing the update, just like we did when inserting the user
 // record originally.
-func (m UserModel) Update(user *User) error {
+func (m UserModel) Update(ctx context.Context, user *User) error {
 	query := `
         UPDATE users 
         SET name = $1, email = $2, password_hash = $3, activated = $4, version = version + 1
@@ -191,11 +206,15 @@ func (m UserModel) Update(user *User) error {
 		user.Version,
 	}
 
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	ctx, span := startSpan(ctx, "UserModel.Update", query)
+	defer span.End()
+
+	ctx, cancel := context.WithTimeout(ctx, m.Timeouts.For("UserModel.Update"))
 	defer cancel()
 
 	err := m.DB.QueryRowContext(ctx, query, args...).Scan(&user.Version)
 	if err != nil {
+		err = spanError(ctx, span, err)
 		switch {
 		case err.Error() == `pq: duplicate key value violates unique constraint "users_email_key"`:
 			return ErrDuplicateEmail
@@ -206,5 +225,50 @@ func (m UserModel) Update(user *User) error {
 		}
 	}
 
+	spanRows(span, 1)
 	return nil
 }
+
+// GetAllInactive retrieves every user who has not activated their account yet, oldest first
+func (m UserModel) GetAllInactive(ctx context.Context) ([]*User, error) {
+	query := `
+		SELECT id, created_at, name, email, password_hash, activated, version
+		FROM users
+		WHERE NOT activated
+		ORDER BY id`
+
+	ctx, span := startSpan(ctx, "UserModel.GetAllInactive", query)
+	defer span.End()
+
+	ctx, cancel := context.WithTimeout(ctx, m.Timeouts.For("UserModel.GetAllInactive"))
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query)
+	if err != nil {
+		return nil, spanError(ctx, span, err)
+	}
+	defer rows.Close()
+
+	users := []*User{}
+	for rows.Next() {
+		var user User
+		if err := rows.Scan(
+			&user.ID,
+			&user.CreatedAt,
+			&user.Name,
+			&user.Email,
+			&user.Password.hash,
+			&user.Activated,
+			&user.Version,
+		); err != nil {
+			return nil, spanError(ctx, span, err)
+		}
+		users = append(users, &user)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, spanError(ctx, span, err)
+	}
+	spanRows(span, int64(len(users)))
+	return users, nil
+}
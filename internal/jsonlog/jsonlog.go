@@ -1,12 +1,18 @@
 package jsonlog
 
 import (
+	"context"
 	"encoding/json"
+	"fmt"
 	"io"
 	"os"
 	"runtime/debug"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
+
+	"go.opentelemetry.io/otel/trace"
 )
 
 // Level defines a Level type to represent the severity level for a log entry.
@@ -42,36 +48,68 @@ func (l Level) String() string {
 // the log entries will be written for and a mutex which will coordinate the writes
 type Logger struct {
 	out      io.Writer
-	minLevel Level
+	minLevel atomic.Int32
 	mu       sync.Mutex
 }
 
 func New(out io.Writer, minLevel Level) *Logger {
-	return &Logger{
-		out:      out,
-		minLevel: minLevel,
+	l := &Logger{out: out}
+	l.minLevel.Store(int32(minLevel))
+	return l
+}
+
+// ParseLevel returns the Level matching a case-insensitive level name such as "info"
+func ParseLevel(name string) (Level, error) {
+	for level := LevelInfo; level <= LevelOff; level++ {
+		if strings.EqualFold(name, level.String()) {
+			return level, nil
+		}
 	}
+	return LevelInfo, fmt.Errorf("unknown log level %q", name)
+}
+
+// MinLevel returns the minimum severity level of entries which are written
+func (l *Logger) MinLevel() Level {
+	return Level(l.minLevel.Load())
+}
+
+// SetMinLevel changes the minimum severity level. It is safe to call while the logger is
+// in use, which allows the level to be changed without restarting the application.
+func (l *Logger) SetMinLevel(level Level) {
+	l.minLevel.Store(int32(level))
 }
 
 // PrintInfo will write errors at the Info level
 func (l *Logger) PrintInfo(message string, properties map[string]string) {
-	l.print(LevelInfo, message, properties)
+	l.print(context.Background(), LevelInfo, message, properties)
 }
 
 // PrintError will write errors at the Error level
 func (l *Logger) PrintError(err error, properties map[string]string) {
-	l.print(LevelError, err.Error(), properties)
+	l.print(context.Background(), LevelError, err.Error(), properties)
+}
+
+// PrintInfoContext writes an Info entry which includes the trace and span IDs of the span
+// held by ctx, if any, so the entry can be matched up with its trace
+func (l *Logger) PrintInfoContext(ctx context.Context, message string, properties map[string]string) {
+	l.print(ctx, LevelInfo, message, properties)
+}
+
+// PrintErrorContext writes an Error entry which includes the trace and span IDs of the span
+// held by ctx, if any
+func (l *Logger) PrintErrorContext(ctx context.Context, err error, properties map[string]string) {
+	l.print(ctx, LevelError, err.Error(), properties)
 }
 
 func (l *Logger) PrintFatal(err error, properties map[string]string) {
-	l.print(LevelFatal, err.Error(), properties)
+	l.print(context.Background(), LevelFatal, err.Error(), properties)
 	os.Exit(1) // For entries at the FATAL level, we also terminate the application.
 }
 
-func (l *Logger) print(level Level, message string, properties map[string]string) (int, error) {
+func (l *Logger) print(ctx context.Context, level Level, message string, properties map[string]string) (int, error) {
 	// ensure that the level is not below the minimum severity
 	// and then return with no further action.
-	if level < l.minLevel {
+	if level < l.MinLevel() {
 		return 0, nil
 	}
 	// declare an anonymous struct that will hold the data for the log entry
@@ -80,6 +118,8 @@ func (l *Logger) print(level Level, message string, properties map[string]string
 		Time       string            `json:"time"`
 		Message    string            `json:"message"`
 		Properties map[string]string `json:"properties,omitempty"`
+		TraceID    string            `json:"trace_id,omitempty"`
+		SpanID     string            `json:"span_id,omitempty"`
 		Trace      string            `json:"trace,omitempty"`
 	}{
 		Level:      level.String(),
@@ -87,6 +127,12 @@ func (l *Logger) print(level Level, message string, properties map[string]string
 		Message:    message,
 		Properties: properties,
 	}
+	// Include the IDs of the current span, so that log entries can be found from a trace
+	// and the other way around.
+	if sc := trace.SpanContextFromContext(ctx); sc.IsValid() {
+		aux.TraceID = sc.TraceID().String()
+		aux.SpanID = sc.SpanID().String()
+	}
 	// Include a stack trace for entries at the ERROR and FATAL levels.
 	if level >= LevelError {
 		aux.Trace = string(debug.Stack())
@@ -114,5 +160,5 @@ func (l *Logger) print(level Level, message string, properties map[string]string
 // io.Writer interface. This writes a log entry at the ERROR level with no additional
 // properties.
 func (l *Logger) Write(message []byte) (n int, err error) {
-	return l.print(LevelError, string(message), nil)
+	return l.print(context.Background(), LevelError, string(message), nil)
 }
@@ -0,0 +1,48 @@
+package mailer
+
+import (
+	"context"
+	"sync"
+)
+
+// Capture is a Transport which keeps every email in memory instead of sending it, so that
+// tests can check what would have been sent. It is safe for concurrent use.
+type Capture struct {
+	mu       sync.Mutex
+	messages []Message
+}
+
+// NewCapture returns an empty Capture transport
+func NewCapture() *Capture {
+	return &Capture{}
+}
+
+// Deliver records a copy of the message
+func (c *Capture) Deliver(ctx context.Context, msg *Message) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.messages = append(c.messages, *msg)
+	return nil
+}
+
+// Ping always succeeds, as there is nothing to connect to
+func (c *Capture) Ping(ctx context.Context) error {
+	return nil
+}
+
+// Messages returns a copy of the messages delivered so far, oldest first
+func (c *Capture) Messages() []Message {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return append([]Message(nil), c.messages...)
+}
+
+// Reset forgets every message delivered so far
+func (c *Capture) Reset() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.messages = nil
+}
@@ -0,0 +1,35 @@
+package mailer
+
+import (
+	"context"
+
+	"github.com/sparkycj328/JobAIO-API/internal/jsonlog"
+)
+
+// Log is a Transport which writes every email to the log instead of sending it, at the
+// info level. Only the plain-text body is logged.
+type Log struct {
+	logger *jsonlog.Logger
+}
+
+// NewLog returns a Log transport writing to logger
+func NewLog(logger *jsonlog.Logger) *Log {
+	return &Log{logger: logger}
+}
+
+// Deliver logs the message
+func (l *Log) Deliver(ctx context.Context, msg *Message) error {
+	l.logger.PrintInfoContext(ctx, "email", map[string]string{
+		"from":     msg.From,
+		"to":       msg.To,
+		"subject":  msg.Subject,
+		"template": msg.Template,
+		"body":     msg.PlainBody,
+	})
+	return nil
+}
+
+// Ping always succeeds, as there is nothing to connect to
+func (l *Log) Ping(ctx context.Context) error {
+	return nil
+}
@@ -0,0 +1,81 @@
+package mailer
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"time"
+)
+
+// Maildir is a Transport which writes every email to its own .eml file in a maildir, where
+// it can be opened with a mail client. Files are written to the tmp subdirectory and moved
+// into new once complete, so readers never see a partial message.
+type Maildir struct {
+	dir      string
+	hostname string
+	seq      atomic.Uint64
+}
+
+// NewMaildir returns a Maildir transport writing to dir, creating the directory and its
+// tmp, new and cur subdirectories if they don't exist
+func NewMaildir(dir string) (*Maildir, error) {
+	for _, sub := range []string{"tmp", "new", "cur"} {
+		if err := os.MkdirAll(filepath.Join(dir, sub), 0o750); err != nil {
+			return nil, fmt.Errorf("mailer: %w", err)
+		}
+	}
+
+	hostname, err := os.Hostname()
+	if err != nil {
+		hostname = "localhost"
+	}
+
+	return &Maildir{dir: dir, hostname: hostname}, nil
+}
+
+// Deliver writes the message to a uniquely named file in the new subdirectory, and
+// returns once the file has been synced to disk
+func (m *Maildir) Deliver(ctx context.Context, msg *Message) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	now := time.Now()
+	name := fmt.Sprintf("%d.M%dP%dQ%d.%s.eml", now.Unix(), now.Nanosecond()/1000, os.Getpid(), m.seq.Add(1), m.hostname)
+	tmp := filepath.Join(m.dir, "tmp", name)
+
+	f, err := os.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o640)
+	if err != nil {
+		return err
+	}
+	if _, err := msg.WriteTo(f); err != nil {
+		f.Close()
+		os.Remove(tmp)
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		os.Remove(tmp)
+		return err
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+
+	return os.Rename(tmp, filepath.Join(m.dir, "new", name))
+}
+
+// Ping checks that the maildir still exists
+func (m *Maildir) Ping(ctx context.Context) error {
+	info, err := os.Stat(filepath.Join(m.dir, "new"))
+	if err != nil {
+		return err
+	}
+	if !info.IsDir() {
+		return fmt.Errorf("mailer: %s is not a directory", filepath.Join(m.dir, "new"))
+	}
+	return nil
+}
@@ -2,11 +2,16 @@ package mailer
 
 import (
 	"bytes"
+	"context"
 	"embed"
 	"html/template"
+	"sync/atomic"
 	"time"
 
-	"github.com/go-mail/mail/v2"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
 )
 
 // Below we declare a new variable with the type embed.FS (embedded file system) to hold
@@ -17,31 +22,66 @@ import (
 //go:embed "templates"
 var templateFS embed.FS
 
-// Mailer struct which contains a mail.Dialer instance (used to connect to a
-// SMTP server) and the sender information for your emails (the name and address you
-// want the email to be from, such as "Alice Smith <alice@example.com>").
+// tracer creates a span for every email sent, with a child span for each delivery attempt
+var tracer = otel.Tracer("github.com/sparkycj328/JobAIO-API/internal/mailer")
+
+// Mailer struct which contains the Transport used to deliver emails and the sender
+// information for your emails (the name and address you want the email to be from, such
+// as "Alice Smith <alice@example.com>").
 type Mailer struct {
-	dialer *mail.Dialer
-	sender string
+	transport Transport
+	sender    *atomic.Value
 }
 
-func New(host string, port int, username, password, sender string) Mailer {
-	// Initialize a new mail.Dialer instance with the given SMTP server settings. We
-	// also configure this to use a 5-second timeout whenever we send an email.
-	dialer := mail.NewDialer(host, port, username, password)
-	dialer.Timeout = 5 * time.Second
-
-	// Return a Mailer instance containing the dialer and sender information.
-	return Mailer{
-		dialer: dialer,
-		sender: sender,
+// New returns a Mailer which renders emails from the embedded templates and hands them to
+// the transport for delivery
+func New(transport Transport, sender string) Mailer {
+	m := Mailer{
+		transport: transport,
+		sender:    new(atomic.Value),
 	}
+	m.SetSender(sender)
+	return m
+}
+
+// SetSender changes the sender used for emails sent from now on. The sender is shared by
+// every copy of the Mailer, so it can be changed while the application is running.
+func (m Mailer) SetSender(sender string) {
+	m.sender.Store(sender)
+}
+
+// Sender returns the current sender information
+func (m Mailer) Sender() string {
+	return m.sender.Load().(string)
+}
+
+// Transport returns the transport emails are delivered with
+func (m Mailer) Transport() Transport {
+	return m.transport
+}
+
+// Ping checks that the transport is able to deliver emails, without sending anything,
+// giving up if the context is done
+func (m Mailer) Ping(ctx context.Context) error {
+	return m.transport.Ping(ctx)
 }
 
 // Send method on the Mailer type. This takes the recipient email address
-// as the first parameter, the name of the file containing the templates, and any
-// dynamic data for the templates as an any parameter.
-func (m Mailer) Send(recipient, templateFile string, data any) error {
+// as the first parameter after the context, the name of the file containing the templates,
+// and any dynamic data for the templates as an any parameter. Retries stop early if the
+// context is done.
+func (m Mailer) Send(ctx context.Context, recipient, templateFile string, data any) (err error) {
+	ctx, span := tracer.Start(ctx, "Mailer.Send", trace.WithAttributes(
+		attribute.String("email.template", templateFile),
+	))
+	defer func() {
+		if err != nil {
+			span.RecordError(err)
+			span.SetStatus(codes.Error, err.Error())
+		}
+		span.End()
+	}()
+
 	// Use the ParseFS() method to parse the required template file from the embedded
 	// file system.
 	tmpl, err := template.New("email").ParseFS(templateFS, "templates/"+templateFile)
@@ -72,33 +112,49 @@ func (m Mailer) Send(recipient, templateFile string, data any) error {
 		return err
 	}
 
-	// Use the mail.NewMessage() function to initialize a new mail.Message instance.
-	// Then we use the SetHeader() method to set the email recipient, sender and subject
-	// headers, the SetBody() method to set the plain-text body, and the AddAlternative()
-	// method to set the HTML body. It's important to note that AddAlternative() should
-	// always be called *after* SetBody().
-	msg := mail.NewMessage()
-	msg.SetHeader("To", recipient)
-	msg.SetHeader("From", m.sender)
-	msg.SetHeader("Subject", subject.String())
-	msg.SetBody("text/plain", plainBody.String())
-	msg.AddAlternative("text/html", htmlBody.String())
-
-	// Call the DialAndSend() method on the dialer, passing in the message to send. This
-	// opens a connection to the SMTP server, sends the message, then closes the
-	// connection. If there is a timeout, it will return a "dial tcp: i/o timeout"
-	// error. Try sending the email up to three times before aborting and returning the final
-	// error. We sleep for 500 milliseconds between each attempt.
+	msg := &Message{
+		From:      m.Sender(),
+		To:        recipient,
+		Subject:   subject.String(),
+		Template:  templateFile,
+		PlainBody: plainBody.String(),
+		HTMLBody:  htmlBody.String(),
+	}
+
+	// Hand the message to the transport. Delivery can fail for transient reasons, such
+	// as an SMTP server timing out with a "dial tcp: i/o timeout" error, so try up to
+	// three times before aborting and returning the final error. We sleep for 500
+	// milliseconds between each attempt.
 	for i := 1; i <= 3; i++ {
-		err = m.dialer.DialAndSend(msg)
+		err = m.attempt(ctx, msg, i)
 		// If everything worked, return nil.
 		if nil == err {
 			return nil
 		}
 
 		// If it didn't work, sleep for a short time and retry.
-		time.Sleep(500 * time.Millisecond)
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(500 * time.Millisecond):
+		}
 	}
 
 	return err
 }
+
+// attempt makes a single delivery attempt, recorded in its own span. Transports may add
+// attributes of their own to the span, such as the address of the SMTP server.
+func (m Mailer) attempt(ctx context.Context, msg *Message, attempt int) error {
+	ctx, span := tracer.Start(ctx, "mail.deliver", trace.WithSpanKind(trace.SpanKindClient), trace.WithAttributes(
+		attribute.Int("email.attempt", attempt),
+	))
+	defer span.End()
+
+	if err := m.transport.Deliver(ctx, msg); err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		return err
+	}
+	return nil
+}
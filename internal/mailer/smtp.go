@@ -0,0 +1,91 @@
+package mailer
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/go-mail/mail/v2"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// SMTP connection security modes
+const (
+	SecurityPlain    = "plain"    // never encrypt the connection
+	SecuritySTARTTLS = "starttls" // upgrade the connection with STARTTLS, failing if it isn't supported
+	SecurityTLS      = "tls"      // connect over TLS from the start, usually on port 465
+)
+
+// SMTPConfig holds the settings of an SMTP transport
+type SMTPConfig struct {
+	Host     string
+	Port     int
+	Username string
+	Password string
+	Security string // one of SecurityPlain, SecuritySTARTTLS or SecurityTLS
+}
+
+// SMTP is a Transport which sends emails through an SMTP server, opening a new connection
+// for each one
+type SMTP struct {
+	dialer *mail.Dialer
+}
+
+// NewSMTP returns an SMTP transport for the server, using a 5-second timeout whenever it
+// connects to the server or sends an email
+func NewSMTP(cfg SMTPConfig) (*SMTP, error) {
+	dialer := mail.NewDialer(cfg.Host, cfg.Port, cfg.Username, cfg.Password)
+	dialer.Timeout = 5 * time.Second
+
+	switch cfg.Security {
+	case SecurityPlain:
+		dialer.SSL = false
+		dialer.StartTLSPolicy = mail.NoStartTLS
+	case SecuritySTARTTLS:
+		dialer.SSL = false
+		dialer.StartTLSPolicy = mail.MandatoryStartTLS
+	case SecurityTLS:
+		dialer.SSL = true
+	default:
+		return nil, fmt.Errorf("mailer: unknown SMTP security %q", cfg.Security)
+	}
+
+	return &SMTP{dialer: dialer}, nil
+}
+
+// Deliver opens a connection to the SMTP server, sends the message, then closes the
+// connection
+func (s *SMTP) Deliver(ctx context.Context, msg *Message) error {
+	trace.SpanFromContext(ctx).SetAttributes(
+		attribute.String("server.address", s.dialer.Host),
+		attribute.Int("server.port", s.dialer.Port),
+	)
+
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return s.dialer.DialAndSend(msg.mime())
+}
+
+// Ping checks that the SMTP server can be reached and accepts our credentials by opening
+// a connection and closing it again without sending anything. The dialer has no support
+// for contexts, so the dial runs in the background and Ping returns as soon as ctx is
+// done, leaving the connection to be closed whenever the dial finishes.
+func (s *SMTP) Ping(ctx context.Context) error {
+	result := make(chan error, 1)
+	go func() {
+		conn, err := s.dialer.Dial()
+		if err == nil {
+			err = conn.Close()
+		}
+		result <- err
+	}()
+
+	select {
+	case err := <-result:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,46 @@
+package mailer
+
+import (
+	"context"
+	"io"
+
+	"github.com/go-mail/mail/v2"
+)
+
+// Transport delivers rendered emails. The SMTP transport sends them to a mail server, while
+// the others keep them for developers and tests to look at.
+type Transport interface {
+	// Deliver delivers a single message, giving up if the context is done
+	Deliver(ctx context.Context, msg *Message) error
+	// Ping checks that the transport is able to deliver messages, without sending any,
+	// giving up if the context is done
+	Ping(ctx context.Context) error
+}
+
+// Message is an email rendered from one of the templates
+type Message struct {
+	From      string
+	To        string
+	Subject   string
+	Template  string // name of the template file the message was rendered from
+	PlainBody string
+	HTMLBody  string
+}
+
+// WriteTo writes the message in the MIME format it is sent in over SMTP, which is also the
+// format of .eml files. It implements io.WriterTo.
+func (msg *Message) WriteTo(w io.Writer) (int64, error) {
+	return msg.mime().WriteTo(w)
+}
+
+// mime converts the message into a multipart message with a plain-text body and an HTML
+// alternative. AddAlternative() must always be called *after* SetBody().
+func (msg *Message) mime() *mail.Message {
+	m := mail.NewMessage()
+	m.SetHeader("To", msg.To)
+	m.SetHeader("From", msg.From)
+	m.SetHeader("Subject", msg.Subject)
+	m.SetBody("text/plain", msg.PlainBody)
+	m.AddAlternative("text/html", msg.HTMLBody)
+	return m
+}
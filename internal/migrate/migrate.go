@@ -0,0 +1,308 @@
+package migrate
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io/fs"
+	"regexp"
+	"sort"
+	"strconv"
+)
+
+// lockID is the key of the PostgreSQL advisory lock held while migrations run, so that
+// several instances starting at once don't try to apply the same migrations concurrently
+const lockID int64 = 4_711_202_306
+
+var (
+	// ErrDirty is returned if a previous migration failed part-way through and the schema
+	// must be repaired by hand before any more migrations can run
+	ErrDirty = errors.New("database schema is dirty")
+	// ErrUnknownVersion is returned when migrating to a version which has no migration file
+	ErrUnknownVersion = errors.New("unknown schema version")
+)
+
+// fileRX matches migration file names such as 000001_create_jobs_table.up.sql
+var fileRX = regexp.MustCompile(`^(\d+)_(.+)\.(up|down)\.sql$`)
+
+// Dialect selects the SQL the Migrator uses for its own bookkeeping, as opposed to the SQL
+// of the migrations themselves
+type Dialect int
+
+const (
+	Postgres Dialect = iota
+	SQLite
+)
+
+// migration holds the up and down SQL for a single schema version
+type migration struct {
+	version     int64
+	description string
+	up          string
+	down        string
+}
+
+// Migrator applies the migrations found in a file system to a database. Applied versions
+// are recorded in the schema_migrations table using the same layout as the migrate CLI,
+// so databases previously migrated with the CLI are picked up where they left off.
+type Migrator struct {
+	db         *sql.DB
+	dialect    Dialect
+	migrations []migration
+}
+
+// New reads every migration file in the root of fsys and returns a Migrator for the
+// PostgreSQL db
+func New(db *sql.DB, fsys fs.FS) (*Migrator, error) {
+	return NewDialect(db, Postgres, fsys)
+}
+
+// NewDialect reads every migration file in the root of fsys and returns a Migrator for a db
+// of the given dialect
+func NewDialect(db *sql.DB, dialect Dialect, fsys fs.FS) (*Migrator, error) {
+	entries, err := fs.ReadDir(fsys, ".")
+	if err != nil {
+		return nil, err
+	}
+
+	byVersion := make(map[int64]*migration)
+	for _, entry := range entries {
+		matches := fileRX.FindStringSubmatch(entry.Name())
+		if entry.IsDir() || matches == nil {
+			continue
+		}
+
+		version, err := strconv.ParseInt(matches[1], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("migration %s: %w", entry.Name(), err)
+		}
+
+		contents, err := fs.ReadFile(fsys, entry.Name())
+		if err != nil {
+			return nil, err
+		}
+
+		m, ok := byVersion[version]
+		if !ok {
+			m = &migration{version: version, description: matches[2]}
+			byVersion[version] = m
+		}
+		if matches[3] == "up" {
+			m.up = string(contents)
+		} else {
+			m.down = string(contents)
+		}
+	}
+
+	migrator := &Migrator{db: db, dialect: dialect}
+	for _, m := range byVersion {
+		migrator.migrations = append(migrator.migrations, *m)
+	}
+	sort.Slice(migrator.migrations, func(i, j int) bool {
+		return migrator.migrations[i].version < migrator.migrations[j].version
+	})
+
+	return migrator, nil
+}
+
+// Latest returns the highest version available, which is the version Up migrates to
+func (m *Migrator) Latest() int64 {
+	if len(m.migrations) == 0 {
+		return 0
+	}
+	return m.migrations[len(m.migrations)-1].version
+}
+
+// Version returns the current schema version and whether it is dirty. A database which has
+// never been migrated is at version 0.
+func (m *Migrator) Version(ctx context.Context) (int64, bool, error) {
+	return m.version(ctx, m.db)
+}
+
+// Up applies every migration newer than the current version. A database which is already
+// ahead of the newest migration known to this binary is left alone.
+func (m *Migrator) Up(ctx context.Context) error {
+	return m.migrate(ctx, m.Latest(), false)
+}
+
+// Down reverts every applied migration
+func (m *Migrator) Down(ctx context.Context) error {
+	return m.To(ctx, 0)
+}
+
+// Rollback reverts the given number of most recently applied migrations
+func (m *Migrator) Rollback(ctx context.Context, steps int) error {
+	current, _, err := m.Version(ctx)
+	if err != nil {
+		return err
+	}
+
+	i := m.index(current)
+	if i < 0 {
+		return fmt.Errorf("%w %d", ErrUnknownVersion, current)
+	}
+
+	var target int64
+	if i-steps >= 0 {
+		target = m.migrations[i-steps].version
+	}
+	return m.To(ctx, target)
+}
+
+// To migrates up or down to the given version while holding an advisory lock. SQLite has
+// no advisory locks, but a SQLite database file is only ever used by a single instance. Each
+// migration runs in its own transaction together with the update of schema_migrations, so
+// a failing migration leaves the schema at the last version which succeeded.
+func (m *Migrator) To(ctx context.Context, target int64) error {
+	return m.migrate(ctx, target, true)
+}
+
+// migrate moves the schema to the target version, reverting migrations only if allowDown
+// is set
+func (m *Migrator) migrate(ctx context.Context, target int64, allowDown bool) error {
+	if target != 0 && m.index(target) < 0 {
+		return fmt.Errorf("%w %d", ErrUnknownVersion, target)
+	}
+
+	// advisory locks belong to a session, so take the lock on a dedicated connection
+	conn, err := m.db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	if m.dialect == Postgres {
+		if _, err := conn.ExecContext(ctx, `SELECT pg_advisory_lock($1)`, lockID); err != nil {
+			return err
+		}
+		defer conn.ExecContext(context.Background(), `SELECT pg_advisory_unlock($1)`, lockID)
+	}
+
+	_, err = conn.ExecContext(ctx, `
+		CREATE TABLE IF NOT EXISTS schema_migrations (
+			version bigint NOT NULL PRIMARY KEY,
+			dirty boolean NOT NULL
+		)`)
+	if err != nil {
+		return err
+	}
+
+	// read the version only once the lock is held, as another instance may have just
+	// finished migrating
+	current, dirty, err := m.version(ctx, conn)
+	if err != nil {
+		return err
+	}
+	if dirty {
+		return fmt.Errorf("%w at version %d", ErrDirty, current)
+	}
+	if current > target && !allowDown {
+		return nil
+	}
+
+	for current != target {
+		var (
+			script string
+			next   int64
+		)
+
+		if current < target {
+			// apply the first migration above the current version. Version 0 is an empty
+			// database, any other version must be one of the known migrations.
+			i := m.index(current)
+			if i < 0 && current != 0 {
+				return fmt.Errorf("%w %d", ErrUnknownVersion, current)
+			}
+			script, next = m.migrations[i+1].up, m.migrations[i+1].version
+		} else {
+			// revert the current version, moving to the one below it
+			i := m.index(current)
+			if i < 0 {
+				return fmt.Errorf("%w %d", ErrUnknownVersion, current)
+			}
+			script = m.migrations[i].down
+			if i > 0 {
+				next = m.migrations[i-1].version
+			} else {
+				next = 0
+			}
+		}
+
+		if err := apply(ctx, conn, script, next); err != nil {
+			return fmt.Errorf("migrating from version %d to %d: %w", current, next, err)
+		}
+		current = next
+	}
+
+	return nil
+}
+
+// index returns the position of version in the sorted migrations, or -1 if there is no
+// migration for it. Version 0 always returns -1 as it means no migrations are applied.
+func (m *Migrator) index(version int64) int {
+	for i, migration := range m.migrations {
+		if migration.version == version {
+			return i
+		}
+	}
+	return -1
+}
+
+// apply runs a migration script and records the resulting version in a single transaction
+func apply(ctx context.Context, conn *sql.Conn, script string, version int64) error {
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, script); err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, `DELETE FROM schema_migrations`); err != nil {
+		return err
+	}
+	if version > 0 {
+		_, err := tx.ExecContext(ctx, `INSERT INTO schema_migrations (version, dirty) VALUES ($1, false)`, version)
+		if err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// queryer is satisfied by both *sql.DB and *sql.Conn
+type queryer interface {
+	QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row
+}
+
+// version reads the recorded schema version, treating a missing table or row as version 0
+func (m *Migrator) version(ctx context.Context, q queryer) (int64, bool, error) {
+	query := `SELECT to_regclass('schema_migrations') IS NOT NULL`
+	if m.dialect == SQLite {
+		query = `SELECT EXISTS (SELECT 1 FROM sqlite_master WHERE type = 'table' AND name = 'schema_migrations')`
+	}
+
+	var exists bool
+	err := q.QueryRowContext(ctx, query).Scan(&exists)
+	if err != nil || !exists {
+		return 0, false, err
+	}
+
+	var (
+		current int64
+		dirty   bool
+	)
+	err = q.QueryRowContext(ctx, `SELECT version, dirty FROM schema_migrations LIMIT 1`).Scan(&current, &dirty)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return 0, false, nil
+		default:
+			return 0, false, err
+		}
+	}
+	return current, dirty, nil
+}
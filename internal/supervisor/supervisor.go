@@ -0,0 +1,235 @@
+package supervisor
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sparkycj328/JobAIO-API/internal/jsonlog"
+)
+
+// ErrStopped is returned when a task is submitted after Shutdown has been called
+var ErrStopped = errors.New("supervisor has been shut down")
+
+// TaskStats holds the counters recorded for every task of the same name
+type TaskStats struct {
+	Running   int64 `json:"running"`
+	Succeeded int64 `json:"succeeded"`
+	Failed    int64 `json:"failed"`
+	Panicked  int64 `json:"panicked"`
+	// TotalDuration is the combined run time of every finished task, in milliseconds
+	TotalDuration int64 `json:"total_duration_ms"`
+}
+
+// Supervisor runs named background tasks and long-running workers, and stops them cleanly
+// on shutdown. One-off tasks each run with a timeout and are allowed to finish during
+// shutdown until its deadline expires. Workers are cancelled as soon as shutdown begins.
+type Supervisor struct {
+	logger      *jsonlog.Logger
+	taskTimeout time.Duration
+
+	// taskCtx is the parent of every one-off task and is only cancelled once the shutdown
+	// deadline passes, while workerCtx is cancelled as soon as shutdown begins
+	taskCtx      context.Context
+	cancelTasks  context.CancelFunc
+	workerCtx    context.Context
+	cancelWorker context.CancelFunc
+
+	wg      sync.WaitGroup
+	mu      sync.Mutex
+	stopped bool
+	stats   map[string]*TaskStats
+}
+
+// New returns a Supervisor which gives every one-off task at most taskTimeout to complete
+func New(logger *jsonlog.Logger, taskTimeout time.Duration) *Supervisor {
+	s := &Supervisor{
+		logger:      logger,
+		taskTimeout: taskTimeout,
+		stats:       make(map[string]*TaskStats),
+	}
+	s.taskCtx, s.cancelTasks = context.WithCancel(context.Background())
+	s.workerCtx, s.cancelWorker = context.WithCancel(context.Background())
+	return s
+}
+
+// Go runs fn in the background as a one-off task. Its context carries the values of parent,
+// such as the trace of the request which started the task, but not its cancellation, as the
+// task is expected to outlive the request. Instead it is cancelled when the task timeout
+// expires or the shutdown deadline passes, whichever comes first.
+func (s *Supervisor) Go(parent context.Context, name string, fn func(ctx context.Context) error) error {
+	return s.start(name, func() {
+		ctx, cancel := context.WithTimeout(context.WithoutCancel(parent), s.taskTimeout)
+		defer cancel()
+		stop := context.AfterFunc(s.taskCtx, cancel)
+		defer stop()
+
+		s.run(ctx, name, fn)
+	})
+}
+
+// Worker runs fn in the background until it returns or shutdown begins, at which point its
+// context is cancelled. fn should return promptly once the context is done.
+func (s *Supervisor) Worker(name string, fn func(ctx context.Context) error) error {
+	return s.start(name, func() {
+		s.logger.PrintInfo("starting worker", map[string]string{"task": name})
+		s.run(s.workerCtx, name, fn)
+	})
+}
+
+// Every runs fn once per interval as a worker, until shutdown begins. A failing run is
+// recorded and the next one still happens on schedule.
+func (s *Supervisor) Every(name string, interval time.Duration, fn func(ctx context.Context) error) error {
+	return s.Worker(name, func(ctx context.Context) error {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return nil
+			case <-ticker.C:
+				if err := s.safely(ctx, fn); err != nil {
+					s.logger.PrintError(err, map[string]string{"task": name})
+				}
+			}
+		}
+	})
+}
+
+// start launches a goroutine tracked by the wait group, unless shutdown has begun. The
+// check and the Add happen under the mutex so that Shutdown never waits on a group which
+// is still growing.
+func (s *Supervisor) start(name string, fn func()) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.stopped {
+		s.logger.PrintError(ErrStopped, map[string]string{"task": name})
+		return ErrStopped
+	}
+
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		fn()
+	}()
+	return nil
+}
+
+// run executes a task, recording its duration and outcome in the log and the stats
+func (s *Supervisor) run(ctx context.Context, name string, fn func(ctx context.Context) error) {
+	s.record(name, func(stats *TaskStats) { stats.Running++ })
+	start := time.Now()
+
+	err := s.safely(ctx, fn)
+	duration := time.Since(start)
+
+	var panicked *panicError
+	s.record(name, func(stats *TaskStats) {
+		stats.Running--
+		stats.TotalDuration += duration.Milliseconds()
+		switch {
+		case errors.As(err, &panicked):
+			stats.Panicked++
+		case err != nil:
+			stats.Failed++
+		default:
+			stats.Succeeded++
+		}
+	})
+
+	properties := map[string]string{
+		"task":     name,
+		"duration": duration.String(),
+	}
+	if err != nil {
+		s.logger.PrintErrorContext(ctx, err, properties)
+		return
+	}
+	s.logger.PrintInfoContext(ctx, "background task completed", properties)
+}
+
+// panicError wraps the value recovered from a panicking task
+type panicError struct {
+	value any
+}
+
+func (e *panicError) Error() string {
+	return fmt.Sprintf("panic: %v", e.value)
+}
+
+// safely calls fn, turning a panic into an error so that one bad task can't bring down the
+// whole process
+func (s *Supervisor) safely(ctx context.Context, fn func(ctx context.Context) error) (err error) {
+	defer func() {
+		if value := recover(); value != nil {
+			err = &panicError{value: value}
+		}
+	}()
+	return fn(ctx)
+}
+
+// record applies update to the stats of the named task while holding the mutex
+func (s *Supervisor) record(name string, update func(stats *TaskStats)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	stats, ok := s.stats[name]
+	if !ok {
+		stats = &TaskStats{}
+		s.stats[name] = stats
+	}
+	update(stats)
+}
+
+// Stats returns a copy of the counters for every task name seen so far
+func (s *Supervisor) Stats() map[string]TaskStats {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	stats := make(map[string]TaskStats, len(s.stats))
+	for name, st := range s.stats {
+		stats[name] = *st
+	}
+	return stats
+}
+
+// Shutdown stops accepting tasks, cancels every worker and waits for the running tasks to
+// finish. If ctx expires first, the remaining tasks have their contexts cancelled and an
+// error naming them is returned.
+func (s *Supervisor) Shutdown(ctx context.Context) error {
+	s.mu.Lock()
+	s.stopped = true
+	s.mu.Unlock()
+
+	s.cancelWorker()
+
+	done := make(chan struct{})
+	go func() {
+		s.wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		s.cancelTasks()
+		return nil
+	case <-ctx.Done():
+		s.cancelTasks()
+	}
+
+	var running []string
+	for name, stats := range s.Stats() {
+		if stats.Running > 0 {
+			running = append(running, fmt.Sprintf("%s (%d)", name, stats.Running))
+		}
+	}
+	sort.Strings(running)
+	return fmt.Errorf("background tasks still running at the shutdown deadline: %s: %w",
+		strings.Join(running, ", "), ctx.Err())
+}
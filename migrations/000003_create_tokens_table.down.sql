@@ -1 +1 @@
-DROP TABLE IF EXISTS token;
\ No newline at end of file
+DROP TABLE IF EXISTS tokens;
\ No newline at end of file
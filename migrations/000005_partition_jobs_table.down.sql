@@ -0,0 +1,28 @@
+DROP FUNCTION IF EXISTS jobs_create_partition(date);
+
+ALTER TABLE jobs RENAME TO jobs_partitioned;
+ALTER INDEX jobs_pkey RENAME TO jobs_partitioned_pkey;
+ALTER INDEX jobs_vendor_idx RENAME TO jobs_partitioned_vendor_idx;
+ALTER SEQUENCE jobs_id_seq OWNED BY NONE;
+
+CREATE TABLE jobs (
+    id bigint PRIMARY KEY DEFAULT nextval('jobs_id_seq'),
+    created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    vendor text NOT NULL,
+    country text NOT NULL,
+    amount integer NOT NULL,
+    url text NOT NULL,
+    version integer NOT NULL DEFAULT 1
+);
+
+ALTER SEQUENCE jobs_id_seq OWNED BY jobs.id;
+
+ALTER TABLE jobs ADD CONSTRAINT jobs_amount_check CHECK (amount >=0);
+
+CREATE INDEX IF NOT EXISTS jobs_vendor_idx ON jobs USING GIN (to_tsvector('simple', vendor));
+
+-- partitions detached by the retention policy are left alone
+INSERT INTO jobs (id, created_at, vendor, country, amount, url, version)
+SELECT id, created_at, vendor, country, amount, url, version FROM jobs_partitioned;
+
+DROP TABLE jobs_partitioned;
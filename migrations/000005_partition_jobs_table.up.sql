@@ -0,0 +1,71 @@
+-- Move the existing table aside. Index names are shared by the whole schema, so they are
+-- renamed along with it.
+ALTER TABLE jobs RENAME TO jobs_unpartitioned;
+ALTER INDEX jobs_pkey RENAME TO jobs_unpartitioned_pkey;
+ALTER INDEX jobs_vendor_idx RENAME TO jobs_unpartitioned_vendor_idx;
+ALTER SEQUENCE jobs_id_seq OWNED BY NONE;
+
+-- The partition key has to be part of the primary key. Rows outside every monthly
+-- partition, such as old snapshots imported before their month's partition exists, land in
+-- jobs_default.
+CREATE TABLE jobs (
+    id bigint NOT NULL DEFAULT nextval('jobs_id_seq'),
+    created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    vendor text NOT NULL,
+    country text NOT NULL,
+    amount integer NOT NULL,
+    url text NOT NULL,
+    version integer NOT NULL DEFAULT 1,
+    CONSTRAINT jobs_amount_check CHECK (amount >= 0),
+    PRIMARY KEY (id, created_at)
+) PARTITION BY RANGE (created_at);
+
+ALTER SEQUENCE jobs_id_seq OWNED BY jobs.id;
+
+CREATE INDEX jobs_vendor_idx ON jobs USING GIN (to_tsvector('simple', vendor));
+
+CREATE TABLE jobs_default PARTITION OF jobs DEFAULT;
+
+-- jobs_create_partition creates the partition jobs_pYYYYMM holding the UTC calendar month
+-- containing the given day, returning false if it already exists. Rows of that month
+-- which were stored in jobs_default are moved into the new partition, as a partition can't
+-- be attached while the default partition holds rows belonging to it. Callers are
+-- serialised by an advisory lock, so several servers may run it at once.
+CREATE OR REPLACE FUNCTION jobs_create_partition(day date) RETURNS boolean
+LANGUAGE plpgsql AS $$
+DECLARE
+    month_start timestamptz := date_trunc('month', day::timestamp) AT TIME ZONE 'UTC';
+    month_end timestamptz := (date_trunc('month', day::timestamp) + interval '1 month') AT TIME ZONE 'UTC';
+    partition_name text := 'jobs_p' || to_char(day, 'YYYYMM');
+BEGIN
+    PERFORM pg_advisory_xact_lock(hashtext('jobs_create_partition'));
+
+    IF to_regclass(partition_name) IS NOT NULL THEN
+        RETURN false;
+    END IF;
+
+    EXECUTE format('CREATE TABLE %I (LIKE jobs INCLUDING DEFAULTS INCLUDING CONSTRAINTS)', partition_name);
+    EXECUTE format(
+        'WITH moved AS (DELETE FROM jobs_default WHERE created_at >= $1 AND created_at < $2 RETURNING *)
+         INSERT INTO %I SELECT * FROM moved', partition_name)
+        USING month_start, month_end;
+    EXECUTE format('ALTER TABLE jobs ATTACH PARTITION %I FOR VALUES FROM (%L) TO (%L)',
+        partition_name, month_start, month_end);
+
+    RETURN true;
+END;
+$$;
+
+-- create a partition for every month holding snapshots and the next three months, then
+-- copy the snapshots across
+SELECT jobs_create_partition(month::date)
+FROM generate_series(
+    date_trunc('month', COALESCE((SELECT min(created_at) FROM jobs_unpartitioned), NOW()) AT TIME ZONE 'UTC'),
+    date_trunc('month', NOW() AT TIME ZONE 'UTC') + interval '3 months',
+    interval '1 month'
+) AS month;
+
+INSERT INTO jobs (id, created_at, vendor, country, amount, url, version)
+SELECT id, created_at, vendor, country, amount, url, version FROM jobs_unpartitioned;
+
+DROP TABLE jobs_unpartitioned;
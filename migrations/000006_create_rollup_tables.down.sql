@@ -0,0 +1,9 @@
+DROP TRIGGER IF EXISTS jobs_rollup_dirty_delete ON jobs;
+DROP TRIGGER IF EXISTS jobs_rollup_dirty_update ON jobs;
+DROP TRIGGER IF EXISTS jobs_rollup_dirty_insert ON jobs;
+DROP FUNCTION IF EXISTS jobs_rollup_mark_dirty();
+DROP TABLE IF EXISTS jobs_rollup_dirty;
+DROP TABLE IF EXISTS jobs_rollup_state;
+DROP TABLE IF EXISTS jobs_rollup_monthly;
+DROP TABLE IF EXISTS jobs_rollup_weekly;
+DROP TABLE IF EXISTS jobs_rollup_daily;
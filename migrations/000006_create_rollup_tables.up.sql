@@ -0,0 +1,102 @@
+-- Summaries of the snapshots of each vendor and country over a UTC day. They are the
+-- source of the weekly and monthly rollups, and are kept once the raw snapshots are purged
+-- so that snapshots added later to a purged day can be merged into them. sum_amount and
+-- last_at are what merging needs on top of the statistics served.
+CREATE TABLE IF NOT EXISTS jobs_rollup_daily (
+    vendor text NOT NULL,
+    country text NOT NULL,
+    period_start date NOT NULL,
+    min_amount integer NOT NULL,
+    max_amount integer NOT NULL,
+    avg_amount double precision NOT NULL,
+    last_amount integer NOT NULL,
+    url text NOT NULL,
+    samples integer NOT NULL,
+    sum_amount bigint NOT NULL,
+    last_at timestamp(0) with time zone NOT NULL,
+    PRIMARY KEY (vendor, country, period_start)
+);
+
+-- Summaries over a UTC week (starting on Monday) or calendar month, which outlive the raw
+-- snapshots once those are purged.
+CREATE TABLE IF NOT EXISTS jobs_rollup_weekly (
+    vendor text NOT NULL,
+    country text NOT NULL,
+    period_start date NOT NULL,
+    min_amount integer NOT NULL,
+    max_amount integer NOT NULL,
+    avg_amount double precision NOT NULL,
+    last_amount integer NOT NULL,
+    url text NOT NULL,
+    samples integer NOT NULL,
+    PRIMARY KEY (vendor, country, period_start)
+);
+
+CREATE INDEX IF NOT EXISTS jobs_rollup_weekly_vendor_idx ON jobs_rollup_weekly USING GIN (to_tsvector('simple', vendor));
+
+CREATE TABLE IF NOT EXISTS jobs_rollup_monthly (LIKE jobs_rollup_weekly INCLUDING ALL);
+
+-- Progress of the rollups, kept in a single row: every day before daily_through, and every
+-- period starting before weekly_through or monthly_through, has been rolled up, and the raw
+-- snapshots taken before purged_before have been deleted. They stay NULL until the first
+-- rollup or purge.
+CREATE TABLE IF NOT EXISTS jobs_rollup_state (
+    id boolean PRIMARY KEY DEFAULT true CHECK (id),
+    daily_through date,
+    weekly_through date,
+    monthly_through date,
+    purged_before date
+);
+
+INSERT INTO jobs_rollup_state DEFAULT VALUES ON CONFLICT DO NOTHING;
+
+-- Days which have been rolled up but whose snapshots have changed since, and must be rolled
+-- up again. A day which has been purged only holds the snapshots added since, which are
+-- merged into its daily rollups.
+CREATE TABLE IF NOT EXISTS jobs_rollup_dirty (
+    day date PRIMARY KEY
+);
+
+-- jobs_rollup_mark_dirty records the rolled up days touched by a write to jobs. Deleting
+-- a snapshot of a purged day leaves it as it is: the snapshot is either being purged, or
+-- was added since the purge and its day is already dirty. Locking the state row makes
+-- writes wait for a rollup or purge in progress, and those wait for the writes in progress,
+-- so that no snapshot is rolled up or purged without being seen.
+CREATE OR REPLACE FUNCTION jobs_rollup_mark_dirty() RETURNS trigger
+LANGUAGE plpgsql AS $$
+BEGIN
+    PERFORM 1 FROM jobs_rollup_state FOR KEY SHARE;
+
+    IF TG_OP IN ('INSERT', 'UPDATE') THEN
+        INSERT INTO jobs_rollup_dirty (day)
+        SELECT DISTINCT (n.created_at AT TIME ZONE 'UTC')::date
+        FROM new_rows AS n, jobs_rollup_state AS s
+        WHERE (n.created_at AT TIME ZONE 'UTC')::date < s.daily_through
+        ON CONFLICT DO NOTHING;
+    END IF;
+
+    IF TG_OP IN ('UPDATE', 'DELETE') THEN
+        INSERT INTO jobs_rollup_dirty (day)
+        SELECT DISTINCT (o.created_at AT TIME ZONE 'UTC')::date
+        FROM old_rows AS o, jobs_rollup_state AS s
+        WHERE (o.created_at AT TIME ZONE 'UTC')::date < s.daily_through
+        AND (o.created_at AT TIME ZONE 'UTC')::date >= coalesce(s.purged_before, '-infinity')
+        ON CONFLICT DO NOTHING;
+    END IF;
+
+    RETURN NULL;
+END;
+$$;
+
+-- transition tables can only be declared by triggers firing on a single event
+CREATE TRIGGER jobs_rollup_dirty_insert AFTER INSERT ON jobs
+REFERENCING NEW TABLE AS new_rows
+FOR EACH STATEMENT EXECUTE FUNCTION jobs_rollup_mark_dirty();
+
+CREATE TRIGGER jobs_rollup_dirty_update AFTER UPDATE ON jobs
+REFERENCING OLD TABLE AS old_rows NEW TABLE AS new_rows
+FOR EACH STATEMENT EXECUTE FUNCTION jobs_rollup_mark_dirty();
+
+CREATE TRIGGER jobs_rollup_dirty_delete AFTER DELETE ON jobs
+REFERENCING OLD TABLE AS old_rows
+FOR EACH STATEMENT EXECUTE FUNCTION jobs_rollup_mark_dirty();
@@ -0,0 +1,30 @@
+// Package migrations embeds the SQL schema migrations so that they ship inside the binary
+// and can be applied by it, instead of relying on an external migrate CLI.
+package migrations
+
+import (
+	"embed"
+	"io/fs"
+)
+
+// FS holds the up and down PostgreSQL migration files, named in the format expected by
+// internal/migrate: <version>_<description>.<up|down>.sql
+//
+//go:embed *.sql
+var FS embed.FS
+
+//go:embed sqlite/*.sql
+var sqliteFiles embed.FS
+
+// SQLite holds the migrations creating the same schema in SQLite. They keep the versions
+// and names of the PostgreSQL migrations, so the two sets must be changed together.
+var SQLite = mustSub(sqliteFiles, "sqlite")
+
+// mustSub returns the subtree of fsys rooted at dir, which only fails for invalid names
+func mustSub(fsys fs.FS, dir string) fs.FS {
+	sub, err := fs.Sub(fsys, dir)
+	if err != nil {
+		panic(err)
+	}
+	return sub
+}
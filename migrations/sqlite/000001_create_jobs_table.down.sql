@@ -0,0 +1,5 @@
+DROP TRIGGER IF EXISTS jobs_vendor_fts_update;
+DROP TRIGGER IF EXISTS jobs_vendor_fts_delete;
+DROP TRIGGER IF EXISTS jobs_vendor_fts_insert;
+DROP TABLE IF EXISTS jobs_vendor_fts;
+DROP TABLE IF EXISTS jobs;
@@ -0,0 +1,32 @@
+CREATE TABLE IF NOT EXISTS jobs (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    created_at DATETIME NOT NULL DEFAULT (datetime('now')),
+    vendor TEXT NOT NULL,
+    country TEXT NOT NULL,
+    amount INTEGER NOT NULL,
+    url TEXT NOT NULL,
+    version INTEGER NOT NULL DEFAULT 1,
+    CONSTRAINT jobs_amount_check CHECK (amount >= 0)
+);
+
+-- full-text index of the vendor names, standing in for the GIN index on
+-- to_tsvector('simple', vendor) used with PostgreSQL
+CREATE VIRTUAL TABLE IF NOT EXISTS jobs_vendor_fts USING fts5(
+    vendor,
+    content = 'jobs',
+    content_rowid = 'id',
+    tokenize = 'unicode61 remove_diacritics 0'
+);
+
+CREATE TRIGGER IF NOT EXISTS jobs_vendor_fts_insert AFTER INSERT ON jobs BEGIN
+    INSERT INTO jobs_vendor_fts (rowid, vendor) VALUES (new.id, new.vendor);
+END;
+
+CREATE TRIGGER IF NOT EXISTS jobs_vendor_fts_delete AFTER DELETE ON jobs BEGIN
+    INSERT INTO jobs_vendor_fts (jobs_vendor_fts, rowid, vendor) VALUES ('delete', old.id, old.vendor);
+END;
+
+CREATE TRIGGER IF NOT EXISTS jobs_vendor_fts_update AFTER UPDATE OF vendor ON jobs BEGIN
+    INSERT INTO jobs_vendor_fts (jobs_vendor_fts, rowid, vendor) VALUES ('delete', old.id, old.vendor);
+    INSERT INTO jobs_vendor_fts (rowid, vendor) VALUES (new.id, new.vendor);
+END;
@@ -0,0 +1,12 @@
+-- NOCASE compares email addresses case-insensitively like citext, although it only folds
+-- ASCII letters
+CREATE TABLE IF NOT EXISTS users (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    created_at DATETIME NOT NULL DEFAULT (datetime('now')),
+    name TEXT NOT NULL,
+    email TEXT NOT NULL COLLATE NOCASE,
+    password_hash BLOB NOT NULL,
+    activated BOOLEAN NOT NULL,
+    version INTEGER NOT NULL DEFAULT 1,
+    CONSTRAINT users_email_key UNIQUE (email)
+);
@@ -0,0 +1,6 @@
+CREATE TABLE IF NOT EXISTS tokens (
+    hash BLOB PRIMARY KEY,
+    user_id INTEGER NOT NULL REFERENCES users ON DELETE CASCADE,
+    expiry DATETIME NOT NULL,
+    scope TEXT NOT NULL
+);
@@ -0,0 +1,3 @@
+-- SQLite has no table partitioning, so the jobs table stays as it is. This migration keeps
+-- the SQLite schema versions in step with the PostgreSQL ones.
+SELECT 1;
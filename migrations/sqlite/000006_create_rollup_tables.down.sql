@@ -0,0 +1,11 @@
+DROP TRIGGER IF EXISTS jobs_rollup_dirty_delete;
+DROP TRIGGER IF EXISTS jobs_rollup_dirty_update;
+DROP TRIGGER IF EXISTS jobs_rollup_dirty_insert;
+DROP TABLE IF EXISTS jobs_rollup_dirty;
+DROP TABLE IF EXISTS jobs_rollup_state;
+DROP TABLE IF EXISTS jobs_rollup_monthly;
+DROP TRIGGER IF EXISTS jobs_rollup_weekly_fts_delete;
+DROP TRIGGER IF EXISTS jobs_rollup_weekly_fts_insert;
+DROP TABLE IF EXISTS jobs_rollup_weekly_fts;
+DROP TABLE IF EXISTS jobs_rollup_weekly;
+DROP TABLE IF EXISTS jobs_rollup_daily;
@@ -0,0 +1,95 @@
+CREATE TABLE IF NOT EXISTS jobs_rollup_daily (
+    vendor TEXT NOT NULL,
+    country TEXT NOT NULL,
+    period_start DATE NOT NULL,
+    min_amount INTEGER NOT NULL,
+    max_amount INTEGER NOT NULL,
+    avg_amount REAL NOT NULL,
+    last_amount INTEGER NOT NULL,
+    url TEXT NOT NULL,
+    samples INTEGER NOT NULL,
+    sum_amount INTEGER NOT NULL,
+    last_at DATETIME NOT NULL,
+    PRIMARY KEY (vendor, country, period_start)
+);
+
+CREATE TABLE IF NOT EXISTS jobs_rollup_weekly (
+    vendor TEXT NOT NULL,
+    country TEXT NOT NULL,
+    period_start DATE NOT NULL,
+    min_amount INTEGER NOT NULL,
+    max_amount INTEGER NOT NULL,
+    avg_amount REAL NOT NULL,
+    last_amount INTEGER NOT NULL,
+    url TEXT NOT NULL,
+    samples INTEGER NOT NULL,
+    PRIMARY KEY (vendor, country, period_start)
+);
+
+-- full-text index of the vendor names, like jobs_vendor_fts, used when listing a day whose
+-- snapshots have been purged
+CREATE VIRTUAL TABLE IF NOT EXISTS jobs_rollup_weekly_fts USING fts5(
+    vendor,
+    content = 'jobs_rollup_weekly',
+    content_rowid = 'rowid',
+    tokenize = 'unicode61 remove_diacritics 0'
+);
+
+CREATE TRIGGER IF NOT EXISTS jobs_rollup_weekly_fts_insert AFTER INSERT ON jobs_rollup_weekly BEGIN
+    INSERT INTO jobs_rollup_weekly_fts (rowid, vendor) VALUES (new.rowid, new.vendor);
+END;
+
+CREATE TRIGGER IF NOT EXISTS jobs_rollup_weekly_fts_delete AFTER DELETE ON jobs_rollup_weekly BEGIN
+    INSERT INTO jobs_rollup_weekly_fts (jobs_rollup_weekly_fts, rowid, vendor) VALUES ('delete', old.rowid, old.vendor);
+END;
+
+CREATE TABLE IF NOT EXISTS jobs_rollup_monthly (
+    vendor TEXT NOT NULL,
+    country TEXT NOT NULL,
+    period_start DATE NOT NULL,
+    min_amount INTEGER NOT NULL,
+    max_amount INTEGER NOT NULL,
+    avg_amount REAL NOT NULL,
+    last_amount INTEGER NOT NULL,
+    url TEXT NOT NULL,
+    samples INTEGER NOT NULL,
+    PRIMARY KEY (vendor, country, period_start)
+);
+
+CREATE TABLE IF NOT EXISTS jobs_rollup_state (
+    id INTEGER PRIMARY KEY CHECK (id = 1),
+    daily_through DATE,
+    weekly_through DATE,
+    monthly_through DATE,
+    purged_before DATE
+);
+
+INSERT OR IGNORE INTO jobs_rollup_state (id) VALUES (1);
+
+CREATE TABLE IF NOT EXISTS jobs_rollup_dirty (
+    day DATE PRIMARY KEY
+);
+
+-- the triggers standing in for jobs_rollup_mark_dirty. SQLite runs one write at a time, so
+-- they need no locking. Comparing with a NULL daily_through, before the first rollup, marks
+-- nothing.
+CREATE TRIGGER IF NOT EXISTS jobs_rollup_dirty_insert AFTER INSERT ON jobs
+WHEN date(new.created_at) < (SELECT daily_through FROM jobs_rollup_state) BEGIN
+    INSERT OR IGNORE INTO jobs_rollup_dirty (day) VALUES (date(new.created_at));
+END;
+
+CREATE TRIGGER IF NOT EXISTS jobs_rollup_dirty_update AFTER UPDATE ON jobs BEGIN
+    INSERT OR IGNORE INTO jobs_rollup_dirty (day)
+    SELECT date(new.created_at)
+    WHERE date(new.created_at) < (SELECT daily_through FROM jobs_rollup_state);
+    INSERT OR IGNORE INTO jobs_rollup_dirty (day)
+    SELECT date(old.created_at)
+    WHERE date(old.created_at) < (SELECT daily_through FROM jobs_rollup_state)
+    AND date(old.created_at) >= (SELECT coalesce(purged_before, '') FROM jobs_rollup_state);
+END;
+
+CREATE TRIGGER IF NOT EXISTS jobs_rollup_dirty_delete AFTER DELETE ON jobs
+WHEN date(old.created_at) < (SELECT daily_through FROM jobs_rollup_state)
+AND date(old.created_at) >= (SELECT coalesce(purged_before, '') FROM jobs_rollup_state) BEGIN
+    INSERT OR IGNORE INTO jobs_rollup_dirty (day) VALUES (date(old.created_at));
+END;